@@ -44,6 +44,9 @@ goc build --output /to/this/path
 
 # Build the current binary with cover variables injected, and set necessary build flags: -ldflags "-extldflags -static" -tags="embed kodo".
 goc build --buildflags="-ldflags '-extldflags -static' -tags='embed kodo'"
+
+# Print what goc build would do, the workspace, the packages to instrument and the go command, without building.
+goc build --dry-run
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		wd, err := os.Getwd()
@@ -58,12 +61,14 @@ var buildOutput string
 
 func init() {
 	addBuildFlags(buildCmd.Flags())
+	addWorkspaceFlags(buildCmd.Flags())
+	addDryRunFlag(buildCmd.Flags())
 	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "it forces build to write the resulting executable to the named output file")
 	rootCmd.AddCommand(buildCmd)
 }
 
 func runBuild(args []string, wd string) {
-	gocBuild, err := build.NewBuild(buildFlags, args, wd, buildOutput)
+	gocBuild, err := newBuild("build", args, wd)
 	if err != nil {
 		log.Fatalf("Fail to build: %v", err)
 	}
@@ -79,19 +84,48 @@ func runBuild(args []string, wd string) {
 		AgentPort:                agentPort.String(),
 		Center:                   center,
 		Singleton:                singleton,
+		IncludeGenerated:         includeGenerated,
+		SkipPackages:             skipPackages,
+		VendorPackages:           vendorPackages,
+		CacheDir:                 cacheDir,
+		Unlinked:                 unlinked,
+		Backend:                  coverBackend.String(),
 		IsMod:                    gocBuild.IsMod,
 		ModRootPath:              gocBuild.ModRootPath,
 		OneMainPackage:           true, // it is a go build
 		GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+		SourceDir:                gocBuild.WorkingDir,
+		GocVersion:               gocVersion(),
+	}
+	if dryRun != "" {
+		if err := runDryRun(gocBuild, ci); err != nil {
+			log.Fatalf("Fail to plan the build: %v", err)
+		}
+		return
 	}
 	err = cover.Execute(ci)
 	if err != nil {
 		log.Fatalf("Fail to build: %v", err)
 	}
+	gocBuild.AppendBuildFlags(ci.BuildFlags)
 	// do install in the temporary directory
 	err = gocBuild.Build()
 	if err != nil {
 		log.Fatalf("Fail to build: %v", err)
 	}
+	writeManifest(gocBuild, ci.Manifest)
 	return
 }
+
+// writeManifest writes the build manifest, failing to do so does not fail the build
+func writeManifest(b *build.Build, m *cover.Manifest) {
+	manifest, err := b.ManifestPath()
+	if err == nil {
+		err = m.Write(manifest)
+	}
+	if err != nil {
+		log.Warnf("failed to write the build manifest: %v", err)
+		return
+	}
+	log.Infof("Build manifest written to %s", manifest)
+}
@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -41,18 +42,37 @@ goc clear --center=http://192.168.1.1:8080
 		p := cover.ProfileParam{
 			Service: svrList,
 			Address: addrList,
+			Force:   true, // the services not found are left alone
 		}
-		res, err := cover.NewWorker(center).Clear(p)
+		client, err := cover.NewCenterClient(center, cover.CenterOptions{})
 		if err != nil {
-			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+			log.Fatalf("%v", err)
+		}
+		ctx, cancel := centerContext()
+		defer cancel()
+		results, err := client.Clear(ctx, p)
+		printServiceResults(os.Stdout, results)
+		if err != nil {
+			log.Fatalf("call host %v failed, err: %v", center, err)
 		}
-		fmt.Fprint(os.Stdout, string(res))
 	},
 }
 
 func init() {
 	addBasicFlags(clearCmd.Flags())
+	addTimeoutFlag(clearCmd.Flags())
 	clearCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to clear profile, see 'goc list' for all services.")
 	clearCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to clear profile, see 'goc list' for all addresses.")
 	rootCmd.AddCommand(clearCmd)
 }
+
+// printServiceResults prints the result of each service a clear or a remove selected
+func printServiceResults(w io.Writer, results []cover.ServiceResult) {
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s %s %s: %s\n", r.Name, r.Address, r.Result, r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s %s\n", r.Name, r.Address, r.Result)
+	}
+}
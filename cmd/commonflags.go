@@ -17,9 +17,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/qiniu/goc/pkg/build"
+	"github.com/qiniu/goc/pkg/cover"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -32,15 +39,29 @@ var (
 	debugInCISyncFile string
 	buildFlags        string
 	singleton         bool
+	includeGenerated  bool
+	skipPackages      []string
+	vendorPackages    []string
+	cacheDir          string
+	unlinked          bool
+	workDir           string
+	keepWorkDir       bool
 
 	goRunExecFlag  string
 	goRunArguments string
+
+	// centerTimeout bounds the calls to the center, the ones of goc run --watch too
+	centerTimeout = time.Minute
 )
 
 var coverMode = CoverMode{
 	mode: "count",
 }
 
+var coverBackend = CoverBackend{
+	backend: cover.BackendGoc,
+}
+
 // addBasicFlags adds a
 func addBasicFlags(cmdset *pflag.FlagSet) {
 	cmdset.StringVar(&center, "center", "http://127.0.0.1:7777", "cover profile host center")
@@ -48,22 +69,94 @@ func addBasicFlags(cmdset *pflag.FlagSet) {
 	viper.BindPFlags(cmdset)
 }
 
-func addCommonFlags(cmdset *pflag.FlagSet) {
+// addTimeoutFlag adds the flag bounding how long the commands calling the center wait for it
+func addTimeoutFlag(cmdset *pflag.FlagSet) {
+	cmdset.DurationVar(&centerTimeout, "timeout", centerTimeout, "give up on the center after this long, 0 to wait as long as it takes")
+	// bind to viper
+	viper.BindPFlags(cmdset)
+}
+
+// centerContext returns the context of the calls of a command to the center, done once --timeout
+// is over or goc is interrupted. Its cancel func stops watching the signals.
+func centerContext() (context.Context, context.CancelFunc) {
+	parent, stop := interruptContext()
+	ctx, cancel := centerCallContext(parent)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// centerCallContext returns the context of a call to the center, done once --timeout is over or
+// parent is done, for a command calling the center now and then, see runWatch
+func centerCallContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if centerTimeout > 0 {
+		return context.WithTimeout(parent, centerTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// interruptContext returns a context done once goc is interrupted. Its cancel func stops watching
+// the signals, for them to get their default behavior back.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// addInstrumentFlags adds the flags telling what to instrument and how, shared with goc toolexec
+func addInstrumentFlags(cmdset *pflag.FlagSet) {
 	addBasicFlags(cmdset)
-	cmdset.Var(&coverMode, "mode", "coverage mode: set, count, atomic")
+	cmdset.Var(&coverMode, "mode", "coverage mode: set, count, atomic, branch (atomic plus branch and condition counters)")
 	cmdset.Var(&agentPort, "agentport", "a fixed port such as :8100 for registered service communicate with goc server. if not provided, using a random one")
 	cmdset.BoolVar(&singleton, "singleton", false, "singleton mode, not register to goc center")
+	cmdset.BoolVar(&includeGenerated, "include-generated", false, "instrument the generated files too, the ones with a '// Code generated ... DO NOT EDIT.' header")
+	cmdset.StringSliceVar(&skipPackages, "skip-packages", nil, "leave the packages whose import path matches any of the patterns without counters")
+	cmdset.StringSliceVar(&vendorPackages, "vendor-packages", nil, "instrument the vendored packages whose import path, without the vendor directory, matches any of the patterns, vendored packages are left without counters otherwise")
+	// bind to viper
+	viper.BindPFlags(cmdset)
+}
+
+func addCommonFlags(cmdset *pflag.FlagSet) {
+	addInstrumentFlags(cmdset)
 	cmdset.StringVar(&buildFlags, "buildflags", "", "specify the build flags")
+	cmdset.StringVar(&cacheDir, "cache-dir", cover.DefaultCacheDir(), "directory caching the instrumented files across builds, empty to disable, defaults to $GOC_CACHE")
+	cmdset.BoolVar(&unlinked, "unlinked", false, "report the packages of the project no binary links with zero counts, so that total coverage covers the whole project")
 	// bind to viper
 	viper.BindPFlags(cmdset)
 }
 
 func addBuildFlags(cmdset *pflag.FlagSet) {
 	addCommonFlags(cmdset)
+	cmdset.Var(&coverBackend, "backend", "instrumentation backend: goc, or native to build with the toolchain's -cover (go 1.20+), which supports every syntax the toolchain does")
+	// bind to viper
+	viper.BindPFlags(cmdset)
+}
+
+// addWorkspaceFlags adds the flags of the commands copying the project to a workspace
+func addWorkspaceFlags(cmdset *pflag.FlagSet) {
+	cmdset.StringVar(&workDir, "workdir", "", "copy the project to this directory instead of a new temporary one, it must be empty or the workspace of a previous build")
+	cmdset.BoolVar(&keepWorkDir, "keep-workdir", false, "keep the workspace after the build, for debugging")
 	// bind to viper
 	viper.BindPFlags(cmdset)
 }
 
+// workspace returns the workspace the flags ask for
+func workspace() build.Workspace {
+	return build.Workspace{Dir: workDir, Keep: keepWorkDir}
+}
+
 func addRunFlags(cmdset *pflag.FlagSet) {
 	addBuildFlags(cmdset)
 	cmdset.StringVar(&goRunExecFlag, "exec", "", "same as -exec flag in 'go run' command")
@@ -87,7 +180,7 @@ func (m *CoverMode) Set(v string) error {
 		m.mode = "count"
 		return nil
 	}
-	if v != "set" && v != "count" && v != "atomic" {
+	if v != "set" && v != "count" && v != "atomic" && v != "branch" {
 		return fmt.Errorf("unknown mode")
 	}
 	m.mode = v
@@ -99,6 +192,33 @@ func (m *CoverMode) Type() string {
 	return "string"
 }
 
+// CoverBackend represents the backend instrumenting the source code
+type CoverBackend struct {
+	backend string
+}
+
+func (b *CoverBackend) String() string {
+	return b.backend
+}
+
+// Set sets the value to the CoverBackend struct, use goc as default if v is empty
+func (b *CoverBackend) Set(v string) error {
+	if v == "" {
+		b.backend = cover.BackendGoc
+		return nil
+	}
+	if v != cover.BackendGoc && v != cover.BackendNative {
+		return cover.ErrUnknownBackend
+	}
+	b.backend = v
+	return nil
+}
+
+// Type returns the type of CoverBackend
+func (b *CoverBackend) Type() string {
+	return "string"
+}
+
 // AgentPort is the struct to do agentPort check
 type AgentPort struct {
 	port string
@@ -17,11 +17,19 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/goc/pkg/cover"
 )
 
 func TestCoverModeFlag(t *testing.T) {
@@ -50,6 +58,11 @@ func TestCoverModeFlag(t *testing.T) {
 			expectedValue: "atomic",
 			err:           nil,
 		},
+		{
+			value:         "branch",
+			expectedValue: "branch",
+			err:           nil,
+		},
 		{
 			value:         "xxxxx",
 			expectedValue: "",
@@ -108,3 +121,78 @@ func TestAgentPortFlag(t *testing.T) {
 		}
 	}
 }
+
+func TestCoverBackendFlag(t *testing.T) {
+	var tcs = []struct {
+		value         string
+		expectedValue string
+		err           error
+	}{
+		{value: "", expectedValue: "goc"},
+		{value: "goc", expectedValue: "goc"},
+		{value: "native", expectedValue: "native"},
+		{value: "gcc", expectedValue: "", err: cover.ErrUnknownBackend},
+	}
+	for _, tc := range tcs {
+		backend := &CoverBackend{}
+		err := backend.Set(tc.value)
+		assert.Equal(t, tc.expectedValue, backend.String())
+		assert.Equal(t, tc.err, err)
+	}
+}
+
+func TestCenterContext(t *testing.T) {
+	// a center which never answers
+	done := make(chan struct{})
+	center := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer center.Close()
+	defer close(done)
+	client, err := cover.NewCenterClient(center.URL, cover.CenterOptions{})
+	assert.NoError(t, err)
+
+	defer func(timeout time.Duration) { centerTimeout = timeout }(centerTimeout)
+	centerTimeout = 100 * time.Millisecond
+	ctx, cancel := centerContext()
+	_, err = client.ListServices(ctx)
+	cancel()
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// interrupted
+	centerTimeout = 0
+	ctx, cancel = centerContext()
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(os.Interrupt)
+	}()
+	_, err = client.ListServices(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	// the calls of a long running command share one handler of the signals, each times out alone
+	centerTimeout = 100 * time.Millisecond
+	parent, stop := interruptContext()
+	defer stop()
+	callCtx, callCancel := centerCallContext(parent)
+	_, err = client.ListServices(callCtx)
+	callCancel()
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, callCtx.Err())
+	assert.NoError(t, parent.Err())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(os.Interrupt)
+	}()
+	<-parent.Done()
+	callCtx, callCancel = centerCallContext(parent)
+	defer callCancel()
+	assert.Equal(t, context.Canceled, callCtx.Err())
+}
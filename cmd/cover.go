@@ -17,15 +17,23 @@
 package cmd
 
 import (
+	"path/filepath"
+
+	"github.com/qiniu/goc/pkg/build"
 	"github.com/qiniu/goc/pkg/cover"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var exportDir string
+
 var coverCmd = &cobra.Command{
 	Use:   "cover",
 	Short: "Do cover for the target source",
-	Long:  `Do cover for the target source. You can select different cover mode (set, count, atomic), default: count`,
+	Long: `Do cover for the target source. You can select different cover mode (set, count, atomic), default: count
+
+With --export, the project is copied into the export directory and instrumented there, the target left untouched: the directory holds a complete project, with the package declaring the counters and a go.mod whose local replacements are absolute, for any build system to build. Its goc-export.json manifest tells what was instrumented and where and how to build.`,
 	Example: `
 # Do cover for the current path, default center: http://127.0.0.1:7777,  default cover mode: count.
 goc cover
@@ -35,30 +43,97 @@ goc cover --center=http://127.0.0.1:7777
 
 # Do cover for the target path,  cover mode: atomic.
 goc cover --center=http://127.0.0.1:7777 --target=/path/to/target --mode=atomic
+
+# Export an instrumented copy of the current project to /path/to/export, for a Dockerfile to build.
+goc cover --center=http://127.0.0.1:7777 --export=/path/to/export
 `,
-	Hidden: true,
 	Run: func(cmd *cobra.Command, args []string) {
+		if exportDir != "" {
+			runExport(target, exportDir)
+			return
+		}
 		runCover(target)
 	},
 }
 
+// runExport instruments a copy of the project in the target directory into the export directory
+func runExport(target, exportDir string) {
+	wd, err := filepath.Abs(target)
+	if err != nil {
+		log.Fatalf("Fail to export: %v", err)
+		return
+	}
+	gocBuild, err := build.NewExport(buildFlags, wd, exportDir)
+	if err != nil {
+		log.Fatalf("Fail to export: %v", err)
+		return
+	}
+	ci := &cover.CoverInfo{
+		Args:                     buildFlags,
+		GoPath:                   gocBuild.NewGOPATH,
+		Target:                   gocBuild.TmpDir,
+		Mode:                     coverMode.String(),
+		AgentPort:                agentPort.String(),
+		Center:                   center,
+		Singleton:                singleton,
+		IncludeGenerated:         includeGenerated,
+		SkipPackages:             skipPackages,
+		VendorPackages:           vendorPackages,
+		CacheDir:                 cacheDir,
+		Unlinked:                 unlinked,
+		Backend:                  coverBackend.String(),
+		IsMod:                    gocBuild.IsMod,
+		ModRootPath:              gocBuild.ModRootPath,
+		OneMainPackage:           false,
+		GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+		SourceDir:                gocBuild.WorkingDir,
+		GocVersion:               gocVersion(),
+	}
+	if err := cover.Execute(ci); err != nil {
+		log.Fatalf("Fail to export: %v", err)
+		return
+	}
+	gocBuild.AppendBuildFlags(ci.BuildFlags)
+	ci.Manifest.Export, err = gocBuild.ExportInfo()
+	if err == nil && ci.Manifest.Backend == cover.BackendNative {
+		// the toolchain declares the counters
+		ci.Manifest.Export.GlobalCoverVarImportPath = ""
+	}
+	if err == nil {
+		err = ci.Manifest.Write(filepath.Join(gocBuild.TmpDir, cover.ExportManifestFile))
+	}
+	if err != nil {
+		log.Fatalf("Fail to export: %v", err)
+		return
+	}
+	log.Infof("Instrumented project exported to %s", gocBuild.TmpDir)
+}
+
 func runCover(target string) {
 	buildFlags := viper.GetString("buildflags")
 	ci := &cover.CoverInfo{
-		Args:           buildFlags,
-		GoPath:         "",
-		Target:         target,
-		Mode:           coverMode.String(),
-		AgentPort:      agentPort.String(),
-		Center:         center,
-		Singleton:      singleton,
-		OneMainPackage: false,
+		Args:             buildFlags,
+		GoPath:           "",
+		Target:           target,
+		Mode:             coverMode.String(),
+		AgentPort:        agentPort.String(),
+		Center:           center,
+		Singleton:        singleton,
+		IncludeGenerated: includeGenerated,
+		SkipPackages:     skipPackages,
+		VendorPackages:   vendorPackages,
+		CacheDir:         cacheDir,
+		Unlinked:         unlinked,
+		Backend:          coverBackend.String(),
+		GocVersion:       gocVersion(),
+		OneMainPackage:   false,
 	}
 	_ = cover.Execute(ci)
 }
 
 func init() {
 	coverCmd.Flags().StringVar(&target, "target", ".", "target folder to cover")
-	addCommonFlags(coverCmd.Flags())
+	coverCmd.Flags().StringVar(&exportDir, "export", "", "copy the project into this empty directory and instrument it there, for an external build system to build")
+	addBuildFlags(coverCmd.Flags())
 	rootCmd.AddCommand(coverCmd)
 }
@@ -120,15 +120,34 @@ func doDiffForLocalProfiles(cmd *cobra.Command, args []string) {
 	//calculate diff file cov and display
 	rows := cover.GetDeltaCov(localP, baseP)
 	rows.Sort()
+	branches := localP.HasBranches() || baseP.HasBranches()
+	header := []string{"File", "Base Coverage", "New Coverage", "Delta"}
+	if branches {
+		header = append(header, "Base Branch", "New Branch")
+	}
+	alignment := make([]int, len(header))
+	for i := range alignment {
+		alignment[i] = tablewriter.ALIGN_CENTER
+	}
+	alignment[0] = tablewriter.ALIGN_LEFT
+
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"File", "Base Coverage", "New Coverage", "Delta"})
+	table.SetHeader(header)
 	table.SetAutoFormatHeaders(false)
-	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER})
+	table.SetColumnAlignment(alignment)
 	for _, row := range rows {
-		table.Append([]string{row.FileName, row.BasePer, row.NewPer, row.DeltaPer})
+		line := []string{row.FileName, row.BasePer, row.NewPer, row.DeltaPer}
+		if branches {
+			line = append(line, row.BaseBranchPer, row.NewBranchPer)
+		}
+		table.Append(line)
 	}
 	totalDelta := cover.PercentStr(cover.TotalDelta(localP, baseP))
-	table.Append([]string{"Total", baseP.TotalPercentage(), localP.TotalPercentage(), totalDelta})
+	total := []string{"Total", baseP.TotalPercentage(), localP.TotalPercentage(), totalDelta}
+	if branches {
+		total = append(total, baseP.TotalBranchPercentage(), localP.TotalBranchPercentage())
+	}
+	table.Append(total)
 	table.Render()
 }
 
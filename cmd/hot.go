@@ -0,0 +1,159 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/qiniu/goc/pkg/cover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var hotCmd = &cobra.Command{
+	Use:   "hot",
+	Short: "List the most called and the never called functions of the services under test",
+	Long: `hot attributes the block counters of count or atomic mode profiles to the functions of the source,
+and lists the most called functions and the ones never called, per service or per package.
+The source tree is looked up like 'go tool cover -func' does, run it from the project the services were built from.`,
+	Example: `
+# Top 10 hot and never called functions of every service registered to the default center
+goc hot
+
+# Top 20 per package of service1
+goc hot --service=service1 --top=20 --per-package
+
+# From a profile file, as json
+goc hot --profile=./coverage.cov --json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sources, err := hotSources()
+		if err != nil {
+			log.Fatalf("failed to get the profiles: %v", err)
+		}
+		report, err := cover.HotReport(sources, hotSource, hotTop, hotPerPackage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if hotJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+			return
+		}
+		printHotReport(os.Stdout, report)
+	},
+}
+
+var (
+	hotProfile    string   // --profile flag
+	hotSource     string   // --source flag
+	hotServices   []string // --service flag
+	hotTop        int      // --top flag
+	hotPerPackage bool     // --per-package flag
+	hotJSON       bool     // --json flag
+)
+
+func init() {
+	hotCmd.Flags().StringVar(&hotProfile, "profile", "", "count mode profile to read instead of asking the center")
+	hotCmd.Flags().StringVar(&hotSource, "source", ".", "directory the source files of the profile are looked up from")
+	hotCmd.Flags().StringSliceVar(&hotServices, "service", nil, "service names to rank, all of them by default, see 'goc list'")
+	hotCmd.Flags().IntVar(&hotTop, "top", 10, "number of hot and never called functions listed per group, 0 for all")
+	hotCmd.Flags().BoolVar(&hotPerPackage, "per-package", false, "rank the functions of each package separately")
+	hotCmd.Flags().BoolVar(&hotJSON, "json", false, "output the report as json")
+	addBasicFlags(hotCmd.Flags())
+	addTimeoutFlag(hotCmd.Flags())
+	rootCmd.AddCommand(hotCmd)
+}
+
+// hotSources reads the profile file, or gets the profile of each service from the center
+func hotSources() ([]cover.ProfileSource, error) {
+	if hotProfile != "" {
+		data, err := ioutil.ReadFile(hotProfile)
+		if err != nil {
+			return nil, err
+		}
+		profiles, _, err := cover.ParseProfileWithBranches(data)
+		if err != nil {
+			return nil, err
+		}
+		return []cover.ProfileSource{{Name: hotProfile, Profiles: profiles}}, nil
+	}
+
+	client, err := cover.NewCenterClient(center, cover.CenterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := centerContext()
+	defer cancel()
+	names := hotServices
+	if len(names) == 0 {
+		services, err := client.ListServices(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range services {
+			names = append(names, s.Name)
+		}
+	}
+
+	var sources []cover.ProfileSource
+	for _, name := range names {
+		profiles, err := client.Profile(ctx, cover.ProfileParam{Force: true, Service: []string{name}, Uploads: true})
+		if err != nil {
+			log.Warnf("failed to get the profile of %s: %v", name, err)
+			continue
+		}
+		sources = append(sources, cover.ProfileSource{Name: name, Profiles: profiles})
+	}
+	return sources, nil
+}
+
+// goc hot --top=2
+// service1: 12 functions, 5 never called, 1375 calls
+// +-------+------------------+-------------------------------+
+// | Calls |     Function     |           Position            |
+// +-------+------------------+-------------------------------+
+// |  1203 | (*Cache).Get     | example.com/svc/cache.go:31   |
+// |   172 | handle           | example.com/svc/main.go:18    |
+// |     0 | (*Cache).Purge   | example.com/svc/cache.go:60   |
+// |     0 | migrate          | example.com/svc/db.go:12      |
+// +-------+------------------+-------------------------------+
+func printHotReport(w io.Writer, report []*cover.HotGroup) {
+	for _, g := range report {
+		title := g.Service
+		if g.Package != "" {
+			title += " " + g.Package
+		}
+		fmt.Fprintf(w, "%s: %d functions, %d never called, %d calls\n", title, g.Funcs, g.NNeverCalled, g.Calls)
+
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Calls", "Function", "Position"})
+		table.SetAutoFormatHeaders(false)
+		table.SetColumnAlignment([]int{tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+		for _, f := range append(g.Hot, g.NeverCalled...) {
+			table.Append([]string{strconv.Itoa(f.Calls), f.Name, fmt.Sprintf("%s:%d", f.File, f.Line)})
+		}
+		table.Render()
+	}
+}
@@ -27,13 +27,21 @@ var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Clear the register information in order to start a new round of tests",
 	Run: func(cmd *cobra.Command, args []string) {
-		if res, err := cover.NewWorker(center).InitSystem(); err != nil {
-			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+		client, err := cover.NewCenterClient(center, cover.CenterOptions{})
+		if err != nil {
+			log.Fatalf("%v", err)
+			return
+		}
+		ctx, cancel := centerContext()
+		defer cancel()
+		if err := client.Init(ctx); err != nil {
+			log.Fatalf("call host %v failed, err: %v", center, err)
 		}
 	},
 }
 
 func init() {
 	addBasicFlags(initCmd.Flags())
+	addTimeoutFlag(initCmd.Flags())
 	rootCmd.AddCommand(initCmd)
 }
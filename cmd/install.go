@@ -19,7 +19,6 @@ package cmd
 import (
 	"os"
 
-	"github.com/qiniu/goc/pkg/build"
 	"github.com/qiniu/goc/pkg/cover"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -52,11 +51,13 @@ goc build --buildflags="-ldflags '-extldflags -static' -tags='embed kodo'"
 
 func init() {
 	addBuildFlags(installCmd.Flags())
+	addWorkspaceFlags(installCmd.Flags())
+	addDryRunFlag(installCmd.Flags())
 	rootCmd.AddCommand(installCmd)
 }
 
 func runInstall(args []string, wd string) {
-	gocBuild, err := build.NewInstall(buildFlags, args, wd)
+	gocBuild, err := newBuild("install", args, wd)
 	if err != nil {
 		log.Fatalf("Fail to install: %v", err)
 	}
@@ -72,19 +73,35 @@ func runInstall(args []string, wd string) {
 		AgentPort:                agentPort.String(),
 		Center:                   center,
 		Singleton:                singleton,
+		IncludeGenerated:         includeGenerated,
+		SkipPackages:             skipPackages,
+		VendorPackages:           vendorPackages,
+		CacheDir:                 cacheDir,
+		Unlinked:                 unlinked,
+		Backend:                  coverBackend.String(),
 		IsMod:                    gocBuild.IsMod,
 		ModRootPath:              gocBuild.ModRootPath,
 		OneMainPackage:           false,
 		GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+		SourceDir:                gocBuild.WorkingDir,
+		GocVersion:               gocVersion(),
+	}
+	if dryRun != "" {
+		if err := runDryRun(gocBuild, ci); err != nil {
+			log.Fatalf("Fail to plan the install: %v", err)
+		}
+		return
 	}
 	err = cover.Execute(ci)
 	if err != nil {
 		log.Fatalf("Fail to install: %v", err)
 	}
+	gocBuild.AppendBuildFlags(ci.BuildFlags)
 	// do install in the temporary directory
 	err = gocBuild.Install()
 	if err != nil {
 		log.Fatalf("Fail to install: %v", err)
 	}
+	writeManifest(gocBuild, ci.Manifest)
 	return
 }
@@ -17,6 +17,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -34,7 +35,21 @@ var listCmd = &cobra.Command{
 goc list [flags]
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		res, err := cover.NewWorker(center).ListServices()
+		client, err := cover.NewCenterClient(center, cover.CenterOptions{})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		ctx, cancel := centerContext()
+		defer cancel()
+		list, err := client.ListServices(ctx)
+		if err != nil {
+			log.Fatalf("list failed, err: %v", err)
+		}
+		services := make(map[string][]string, len(list))
+		for _, s := range list {
+			services[s.Name] = s.Addresses
+		}
+		res, err := json.Marshal(services)
 		if err != nil {
 			log.Fatalf("list failed, err: %v", err)
 		}
@@ -45,5 +60,6 @@ goc list [flags]
 
 func init() {
 	addBasicFlags(listCmd.Flags())
+	addTimeoutFlag(listCmd.Flags())
 	rootCmd.AddCommand(listCmd)
 }
@@ -17,6 +17,10 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+
+	goccover "github.com/qiniu/goc/pkg/cover"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
@@ -31,46 +35,128 @@ var mergeCmd = &cobra.Command{
 	Long: `merge will merge multiple Go coverage files into a single coverage file.
 merge requires that the files are 'coherent', meaning that if they both contain references to the
 same paths, then the contents of those source files were identical for the binary that generated
-each file.
+each file. With --skip-incoherent, files that are not coherent with the largest coherent group are
+skipped with a warning instead of failing the merge.
+
+Coverage directories written by binaries built with 'go build -cover', through GOCOVERDIR, are merged
+too with --covdir, and --output-covdir writes the result in that format for 'go tool covdata'.
+`,
+	Example: `
+# Merge goc profiles.
+goc merge a.cov b.cov -o merged.cov
+
+# Merge a goc profile with the coverage directory of a 'go build -cover' binary.
+goc merge a.cov --covdir=./coverdir -o merged.cov
+
+# Convert a goc profile for 'go tool covdata'.
+goc merge a.cov --output-covdir=./coverdir
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		runMerge(args, outputMergeProfile)
 	},
 }
 
-var outputMergeProfile string
+var (
+	outputMergeProfile string
+	skipIncoherent     bool
+	mergeCovDirs       []string
+	outputCovDir       string
+)
 
 func init() {
 	mergeCmd.Flags().StringVarP(&outputMergeProfile, "output", "o", "mergeprofile.cov", "output file")
+	mergeCmd.Flags().StringSliceVar(&mergeCovDirs, "covdir", nil, "coverage directories written by binaries built with 'go build -cover' to merge")
+	mergeCmd.Flags().StringVar(&outputCovDir, "output-covdir", "", "also write the merged coverage to the directory in the format of 'go build -cover'")
+	mergeCmd.Flags().BoolVar(&skipIncoherent, "skip-incoherent", false, "merge the largest coherent group of files and skip the files generated from different sources")
 
 	rootCmd.AddCommand(mergeCmd)
 }
 
 func runMerge(args []string, output string) {
-	if len(args) == 0 {
+	if len(args) == 0 && len(mergeCovDirs) == 0 {
 		log.Fatalln("Expected at least one coverage file.")
 		return
 	}
 
-	profiles := make([][]*cover.Profile, len(args))
+	profiles := make([][]*cover.Profile, 0, len(args))
+	sources := make([]goccover.ProfileSource, 0, len(args))
+	var branches [][]*goccover.BranchProfile
 	for _, path := range args {
-		profile, err := util.LoadProfile(path)
+		data, err := ioutil.ReadFile(path)
 		if err != nil {
 			log.Fatalf("failed to open %s: %v", path, err)
 			return
 		}
+		profile, branch, err := goccover.ParseProfileWithBranches(data)
+		if err != nil {
+			log.Fatalf("failed to parse %s: %v", path, err)
+			return
+		}
 		profiles = append(profiles, profile)
+		sources = append(sources, goccover.ProfileSource{Name: path, Profiles: profile, Branches: branch})
+		if branch != nil {
+			branches = append(branches, branch)
+		}
+	}
+	for _, dir := range mergeCovDirs {
+		profile, err := goccover.ReadCovDir(dir)
+		if err != nil {
+			log.Fatalf("failed to read the coverage directory %s: %v", dir, err)
+			return
+		}
+		profiles = append(profiles, profile)
+		sources = append(sources, goccover.ProfileSource{Name: dir, Profiles: profile})
 	}
 
-	merged, err := cov.MergeMultipleProfiles(profiles)
-	if err != nil {
-		log.Fatalf("failed to merge files: %v", err)
-		return
+	var merged []*cover.Profile
+	var mergedBranches []*goccover.BranchProfile
+	if skipIncoherent {
+		groups, err := goccover.MergeCoherentProfiles(sources)
+		if err != nil {
+			log.Fatalf("failed to merge files: %v", err)
+			return
+		}
+		// the largest coherent group wins
+		for _, g := range groups[1:] {
+			log.Warnf("files %v were generated from different sources than the others, skipped", g.Names)
+		}
+		merged, mergedBranches = groups[0].Profiles, groups[0].Branches
+	} else {
+		var err error
+		merged, err = cov.MergeMultipleProfiles(profiles)
+		if err != nil {
+			log.Fatalf("failed to merge files: %v", err)
+			return
+		}
+		if branches != nil {
+			mergedBranches = goccover.MergeBranchProfiles(branches...)
+		}
 	}
 
-	err = util.DumpProfile(output, merged)
+	err := util.DumpProfile(output, merged)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	if mergedBranches != nil {
+		if err := appendBranchSection(output, mergedBranches); err != nil {
+			log.Fatalln(err)
+			return
+		}
+	}
+	if outputCovDir != "" {
+		if err := goccover.WriteCovDir(outputCovDir, merged); err != nil {
+			log.Fatalf("failed to write the coverage directory %s: %v", outputCovDir, err)
+		}
+	}
+}
+
+// appendBranchSection appends the branch coverage section to the profile file
+func appendBranchSection(output string, branches []*goccover.BranchProfile) error {
+	f, err := os.OpenFile(output, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return goccover.WriteBranchProfiles(f, branches)
 }
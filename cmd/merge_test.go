@@ -24,6 +24,8 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	goccover "github.com/qiniu/goc/pkg/cover"
 )
 
 // use a variable to record if the tested function has failed
@@ -154,3 +156,51 @@ func TestMergeEmptyProfile(t *testing.T) {
 	assert.Equal(t, fatal, true)
 	assert.Contains(t, fatalStr, "failed to dump profile")
 }
+
+// merge incoherent profiles with --skip-incoherent keeps the largest coherent group
+func TestMergeSkipIncoherentProfiles(t *testing.T) {
+	profileA := filepath.Join(baseDir, "../tests/samples/merge_profile_samples/a.voc")
+	profileC := filepath.Join(baseDir, "../tests/samples/merge_profile_samples/c.voc")
+	profileOverlap := filepath.Join(baseDir, "../tests/samples/merge_profile_samples/overlap.voc")
+	mergeprofile := filepath.Join(baseDir, "../tests/samples/merge_profile_samples/merge.cov")
+
+	// clear fatal string in setup
+	fatalStr = ""
+	fatal = false
+	skipIncoherent = true
+	defer func() { skipIncoherent = false }()
+
+	runMerge([]string{profileOverlap, profileA, profileC}, mergeprofile)
+
+	contents, err := ioutil.ReadFile(mergeprofile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "qiniu.com/kodo/apiserver/server/main.go:32.49,33.13 1 60")
+	assert.NotContains(t, string(contents), "33.12")
+	assert.Equal(t, fatal, false)
+}
+
+// merge the coverage directory of a 'go build -cover' binary, and write it back in that format
+func TestMergeCovDir(t *testing.T) {
+	mergeprofile := filepath.Join(baseDir, "../tests/samples/merge_profile_samples/merge.cov")
+	dir, err := ioutil.TempDir("", "goc-merge")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// clear fatal string in setup
+	fatalStr = ""
+	fatal = false
+	mergeCovDirs = []string{filepath.Join(baseDir, "../tests/samples/covdata_samples")}
+	outputCovDir = dir
+	defer func() { mergeCovDirs, outputCovDir = nil, "" }()
+
+	runMerge(nil, mergeprofile)
+
+	contents, err := ioutil.ReadFile(mergeprofile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "example.com/cvd/main.go:18.3,19.1 1 6")
+	assert.Equal(t, fatal, false)
+
+	profiles, err := goccover.ReadCovDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+}
@@ -0,0 +1,154 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/qiniu/goc/pkg/build"
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/spf13/pflag"
+)
+
+var dryRun string // --dry-run flag, text or json
+
+// addDryRunFlag adds the --dry-run flag of the commands building the project
+func addDryRunFlag(cmdset *pflag.FlagSet) {
+	cmdset.StringVar(&dryRun, "dry-run", "", "print what the command would do, without copying, instrumenting or building anything: text, or json with --dry-run=json")
+	cmdset.Lookup("dry-run").NoOptDefVal = "text"
+}
+
+// newBuild creates the Build of the command verb, one which only plans it with --dry-run
+func newBuild(verb string, args []string, wd string) (*build.Build, error) {
+	if dryRun != "" {
+		if dryRun != "text" && dryRun != "json" {
+			return nil, fmt.Errorf("unknown --dry-run format: %s", dryRun)
+		}
+		return build.NewDryRun(verb, buildFlags, args, wd, buildOutput, workspace())
+	}
+	if verb == "install" {
+		return build.NewInstall(buildFlags, args, wd, workspace())
+	}
+	return build.NewBuild(buildFlags, args, wd, buildOutput, workspace())
+}
+
+// runDryRun prints the plan of the build instead of building
+func runDryRun(b *build.Build, ci *cover.CoverInfo) error {
+	plan, err := b.Plan(ci)
+	if err != nil {
+		return err
+	}
+	if dryRun == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	printPlan(os.Stdout, plan)
+	return nil
+}
+
+func printPlan(w io.Writer, p *build.Plan) {
+	kept := "removed after the build"
+	if p.KeepWorkspace {
+		kept = "kept after the build"
+	}
+	fmt.Fprintf(w, "Workspace: %s (%s)\n", p.Workspace, kept)
+	fmt.Fprintln(w, "Copies:")
+	for _, c := range p.Copies {
+		fmt.Fprintf(w, "  %s -> %s\n", c.From, c.To)
+	}
+	if p.CoverVarDir != "" {
+		fmt.Fprintf(w, "Counters package: %s\n", p.CoverVarDir)
+	}
+	if p.GoMod != nil {
+		fmt.Fprintf(w, "Rewritten go.mod: %s\n", p.GoMod.File)
+		for _, line := range strings.Split(strings.TrimSpace(p.GoMod.Content), "\n") {
+			if line == "" {
+				fmt.Fprintln(w)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if p.ModulesTxt != nil {
+		fmt.Fprintf(w, "Rewritten vendor/modules.txt: %s\n", p.ModulesTxt.File)
+	}
+	if p.GoWork != "" {
+		if p.GoWorkUsed {
+			fmt.Fprintf(w, "go.work: %s, used as GOWORK names it\n", p.GoWork)
+		} else {
+			fmt.Fprintf(w, "go.work: %s, not copied, the build ignores it\n", p.GoWork)
+		}
+	}
+
+	m := p.Cover.Manifest
+	backend := m.Backend
+	if backend == "" {
+		backend = cover.BackendGoc
+	}
+	fmt.Fprintf(w, "Instrumented packages (%s backend, %s mode):\n", backend, m.Mode)
+	var files, blocks int
+	for _, mp := range m.Packages {
+		n := 0
+		for _, f := range mp.Files {
+			n += f.Blocks
+		}
+		files += len(mp.Files)
+		blocks += n
+		if backend == cover.BackendNative {
+			// the toolchain counts the blocks
+			fmt.Fprintf(w, "  %s: %d files\n", mp.ImportPath, len(mp.Files))
+		} else {
+			fmt.Fprintf(w, "  %s: %d files, %d blocks\n", mp.ImportPath, len(mp.Files), n)
+		}
+	}
+	if backend == cover.BackendNative {
+		fmt.Fprintf(w, "Total: %d files\n", files)
+	} else {
+		fmt.Fprintf(w, "Total: %d files, %d blocks\n", files, blocks)
+	}
+	if len(m.Skipped) > 0 {
+		fmt.Fprintln(w, "Skipped files:")
+		for _, f := range m.Skipped {
+			fmt.Fprintf(w, "  %s: %s\n", f.File, f.Reason)
+		}
+	}
+	if len(m.Unlinked) > 0 {
+		fmt.Fprintf(w, "Unlinked packages: %s\n", strings.Join(m.Unlinked, ", "))
+	}
+	fmt.Fprintf(w, "Agent injected into: %s\n", strings.Join(p.Cover.Mains, ", "))
+	fmt.Fprintf(w, "Manifest hash: %s\n", m.Hash)
+
+	fmt.Fprintf(w, "Command, in %s:\n", p.Dir)
+	fmt.Fprintf(w, "  %s\n", strings.Join(append(append([]string{}, p.Env...), quoteArgs(p.Command)...), " "))
+}
+
+// quoteArgs quotes the arguments a shell would split
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted[i] = arg
+	}
+	return quoted
+}
@@ -0,0 +1,51 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/qiniu/goc/pkg/build"
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintPlan(t *testing.T) {
+	p := &build.Plan{
+		Verb:      "build",
+		Workspace: "/tmp/goc-build-1-*",
+		Copies:    []build.Copy{{From: "/p", To: "/tmp/goc-build-1-*"}},
+		Dir:       "/tmp/goc-build-1-*",
+		Command:   []string{"go", "build", "-ldflags", "-X main.v=1", "-o", "/p/p"},
+		Cover: &cover.CoverPlan{
+			Mains: []string{"example.com/p"},
+			Manifest: &cover.Manifest{
+				Hash: "0123",
+				Mode: "count",
+				Packages: []cover.ManifestPackage{
+					{ImportPath: "example.com/p", Files: []cover.ManifestFile{{File: "example.com/p/main.go", Blocks: 3}, {File: "example.com/p/a.go", Blocks: 2}}},
+				},
+			},
+		},
+	}
+	var out bytes.Buffer
+	printPlan(&out, p)
+	assert.Contains(t, out.String(), "Workspace: /tmp/goc-build-1-* (removed after the build)\n")
+	assert.Contains(t, out.String(), "  example.com/p: 2 files, 5 blocks\n")
+	assert.Contains(t, out.String(), "  go build -ldflags '-X main.v=1' -o /p/p\n")
+}
@@ -18,14 +18,17 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/qiniu/goc/pkg/cover"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/test-infra/gopherage/pkg/cov"
 )
 
 var profileCmd = &cobra.Command{
@@ -50,6 +53,15 @@ goc profile --coverfile=pattern1,pattern2,pattern3
 
 # Force fetching all available profiles.
 goc profile --force
+
+# Get every group of services built from the same sources as json, instead of only the largest one.
+goc profile --groups
+
+# Append the branch coverage of services built with --mode=branch.
+goc profile --branch
+
+# Add zero counts for the packages no service links, reported by services built with --unlinked.
+goc profile --unlinked
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		p := cover.ProfileParam{
@@ -58,10 +70,15 @@ goc profile --force
 			Address:           addrList,
 			CoverFilePatterns: coverFilePatterns,
 			SkipFilePatterns:  skipFilePatterns,
+			Groups:            groups,
+			Branch:            branch,
+			Unlinked:          profileUnlinked,
+			Uploads:           true,
 		}
-		res, err := cover.NewWorker(center).Profile(p)
+		res, err := getProfile(center, p)
 		if err != nil {
 			log.Fatalf("Goc server %v return an error: %v", center, err)
+			return
 		}
 
 		if output == "" {
@@ -98,6 +115,9 @@ var (
 	output            string   // --output flag
 	coverFilePatterns []string // --coverfile flag
 	skipFilePatterns  []string // --skipfile flag
+	groups            bool     // --groups flag
+	branch            bool     // --branch flag
+	profileUnlinked   bool     // --unlinked flag
 )
 
 func init() {
@@ -107,6 +127,46 @@ func init() {
 	profileCmd.Flags().BoolVarP(&force, "force", "f", true, "force fetching all available profiles")
 	profileCmd.Flags().StringSliceVarP(&coverFilePatterns, "coverfile", "", nil, "only output coverage data of the files matching the patterns")
 	profileCmd.Flags().StringSliceVarP(&skipFilePatterns, "skipfile", "", nil, "skip the files matching the patterns when outputing coverage data")
+	profileCmd.Flags().BoolVar(&groups, "groups", false, "output every group of coherent profiles as json, profiles built from different sources are not merged together")
+	profileCmd.Flags().BoolVar(&branch, "branch", false, "append the branch coverage section of the services built with --mode=branch")
+	profileCmd.Flags().BoolVar(&profileUnlinked, "unlinked", false, "add zero counts for the packages no service links, reported by the services built with --unlinked")
 	addBasicFlags(profileCmd.Flags())
+	addTimeoutFlag(profileCmd.Flags())
 	rootCmd.AddCommand(profileCmd)
 }
+
+// getProfile gets the profile of the services p selects from the center, in the text format,
+// or its groups as json
+func getProfile(host string, p cover.ProfileParam) ([]byte, error) {
+	client, err := cover.NewCenterClient(host, cover.CenterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := centerContext()
+	defer cancel()
+	if p.Groups {
+		groups, err := client.ProfileGroups(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(groups)
+	}
+
+	report, err := client.ProfileReport(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Incoherent) > 0 {
+		log.Warnf("profiles of %s were built from different sources and are left out, use --groups to get them separately", strings.Join(report.Incoherent, ","))
+	}
+	var buf bytes.Buffer
+	if err := cov.DumpProfile(report.Profiles, &buf); err != nil {
+		return nil, err
+	}
+	if report.Branches != nil {
+		if err := cover.WriteBranchProfiles(&buf, report.Branches); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
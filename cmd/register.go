@@ -38,11 +38,16 @@ goc register [flags]
 			Address:  address,
 			IPRevise: ipRevise,
 		}
-		res, err := cover.NewWorker(center).RegisterService(s)
+		client, err := cover.NewCenterClient(center, cover.CenterOptions{})
 		if err != nil {
 			log.Fatalf("register service failed, err: %v", err)
 		}
-		fmt.Fprint(os.Stdout, string(res))
+		ctx, cancel := centerContext()
+		defer cancel()
+		if err := client.Register(ctx, s); err != nil {
+			log.Fatalf("register service failed, err: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "service %s registered successfully at %s\n", s.Name, s.Address)
 	},
 }
 
@@ -57,6 +62,7 @@ func init() {
 	registerCmd.Flags().StringVarP(&name, "name", "n", "", "service name")
 	registerCmd.Flags().StringVarP(&address, "address", "a", "", "service address")
 	registerCmd.Flags().StringVarP(&ipRevise, "ip_revise", "", "true", "whether to do ip revise during registering")
+	addTimeoutFlag(registerCmd.Flags())
 	registerCmd.MarkFlagRequired("name")
 	registerCmd.MarkFlagRequired("address")
 	rootCmd.AddCommand(registerCmd)
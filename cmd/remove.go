@@ -17,7 +17,6 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -41,17 +40,25 @@ goc remove --address="http://127.0.0.1:53" --center=http://192.168.1.1:8080
 		p := cover.ProfileParam{
 			Service: svrList,
 			Address: addrList,
+			Force:   true, // the services not found are left alone
 		}
-		res, err := cover.NewWorker(center).Remove(p)
+		client, err := cover.NewCenterClient(center, cover.CenterOptions{})
 		if err != nil {
-			log.Fatalf("call host %v failed, err: %v, response: %v", center, err, string(res))
+			log.Fatalf("%v", err)
+		}
+		ctx, cancel := centerContext()
+		defer cancel()
+		results, err := client.Remove(ctx, p)
+		printServiceResults(os.Stdout, results)
+		if err != nil {
+			log.Fatalf("call host %v failed, err: %v", center, err)
 		}
-		fmt.Fprint(os.Stdout, string(res))
 	},
 }
 
 func init() {
 	addBasicFlags(removeCmd.Flags())
+	addTimeoutFlag(removeCmd.Flags())
 	removeCmd.Flags().StringSliceVarP(&svrList, "service", "", nil, "service name to clear profile, see 'goc list' for all services.")
 	removeCmd.Flags().StringSliceVarP(&addrList, "address", "", nil, "address to clear profile, see 'goc list' for all addresses.")
 	rootCmd.AddCommand(removeCmd)
@@ -0,0 +1,155 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+	"k8s.io/test-infra/gopherage/pkg/util"
+
+	goccover "github.com/qiniu/goc/pkg/cover"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report [files...]",
+	Short: "Report the coverage of every package of the project, the ones no binary links included",
+	Long: `report merges the coverage files and prints the coverage of every package.
+
+Packages no binary links never show up in the coverage files, report adds them with zero counts,
+either from the build manifests of binaries built with --unlinked, or by listing the packages of
+the project in the --source directory, so that the total reflects the whole project.
+`,
+	Example: `
+# Add the packages the build found unlinked.
+goc report coverage.cov --manifest=./service.goc.json
+
+# Add every package of the project missing from the coverage files, and write the merged profile.
+goc report a.cov b.cov --source=. -o total.cov
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runReport(args, reportManifests, reportSource, reportOutput, os.Stdout)
+	},
+}
+
+var (
+	reportManifests []string // --manifest flag
+	reportSource    string   // --source flag
+	reportOutput    string   // --output flag
+)
+
+func init() {
+	reportCmd.Flags().StringSliceVar(&reportManifests, "manifest", nil, "build manifests whose unlinked packages are added with zero counts")
+	reportCmd.Flags().StringVar(&reportSource, "source", "", "project directory, every package of it missing from the coverage files is added with zero counts")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "write the merged profile, zero counts included, to the file")
+
+	rootCmd.AddCommand(reportCmd)
+}
+
+func runReport(args, manifests []string, source, output string, w io.Writer) {
+	if len(args) == 0 {
+		log.Fatalln("Expected at least one coverage file.")
+		return
+	}
+
+	profiles := make([][]*cover.Profile, 0, len(args))
+	for _, path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalf("failed to open %s: %v", path, err)
+			return
+		}
+		profile, _ := goccover.SplitBranchSection(data)
+		p, err := cover.ParseProfilesFromReader(bytes.NewReader(profile))
+		if err != nil {
+			log.Fatalf("failed to parse %s: %v", path, err)
+			return
+		}
+		profiles = append(profiles, p)
+	}
+	merged, err := cov.MergeMultipleProfiles(profiles)
+	if err != nil {
+		log.Fatalf("failed to merge files: %v", err)
+		return
+	}
+	linked := goccover.PackageCoverList(merged).Map()
+
+	for _, path := range manifests {
+		var m goccover.Manifest
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &m)
+		}
+		if err != nil {
+			log.Fatalf("failed to read the build manifest %s: %v", path, err)
+			return
+		}
+		if m.UnlinkedProfile == "" {
+			log.Warnf("build manifest %s has no unlinked packages, build with --unlinked to record them", path)
+			continue
+		}
+		zero, err := cover.ParseProfilesFromReader(bytes.NewReader([]byte(m.UnlinkedProfile)))
+		if err != nil {
+			log.Fatalf("failed to parse the unlinked packages of %s: %v", path, err)
+			return
+		}
+		merged = goccover.AddZeroProfiles(merged, zero)
+	}
+	if source != "" {
+		mode := "set"
+		if len(merged) > 0 {
+			mode = merged[0].Mode
+		}
+		zero, err := goccover.StaticZeroProfiles(source, mode)
+		if err != nil {
+			log.Fatalf("failed to list the packages of %s: %v", source, err)
+			return
+		}
+		merged = goccover.AddZeroProfiles(merged, zero)
+	}
+
+	if output != "" {
+		if err := util.DumpProfile(output, merged); err != nil {
+			log.Fatalln(err)
+			return
+		}
+	}
+
+	packages := goccover.PackageCoverList(merged)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Package", "Coverage", "Statements", ""})
+	table.SetAutoFormatHeaders(false)
+	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
+	for _, c := range packages {
+		note := ""
+		if _, ok := linked[c.Name()]; !ok {
+			note = "not linked"
+		}
+		table.Append([]string{c.Name(), c.Percentage(), strconv.Itoa(c.NAllStmts), note})
+	}
+	table.Append([]string{"Total", packages.TotalPercentage(), "", ""})
+	table.Render()
+}
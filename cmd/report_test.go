@@ -0,0 +1,51 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportWithUnlinked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-report")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	profile := filepath.Join(dir, "a.cov")
+	assert.NoError(t, ioutil.WriteFile(profile, []byte("mode: count\np/a/a.go:1.1,2.2 1 4\n"), 0644))
+	manifest := filepath.Join(dir, "a.goc.json")
+	m := &cover.Manifest{Mode: "count", Unlinked: []string{"p/z"}, UnlinkedProfile: "mode: count\np/z/z.go:1.1,4.2 3 0\n"}
+	assert.NoError(t, m.Write(manifest))
+	output := filepath.Join(dir, "total.cov")
+
+	var out bytes.Buffer
+	runReport([]string{profile}, []string{manifest}, "", output, &out)
+	assert.False(t, fatal)
+	assert.Regexp(t, `p/z +\| +0\.0% +\| +3 \| not linked`, out.String())
+	assert.Regexp(t, `Total +\| +25\.0%`, out.String())
+
+	merged, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "mode: count\np/a/a.go:1.1,2.2 1 4\np/z/z.go:1.1,4.2 3 0\n", string(merged))
+}
@@ -22,7 +22,6 @@ import (
 	"net"
 	"os"
 
-	"github.com/qiniu/goc/pkg/build"
 	"github.com/qiniu/goc/pkg/cover"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -37,13 +36,16 @@ It is exactly behave as 'go run .' in addition of some internal goc features.`,
 	Example: `	
 goc run .
 goc run . [--buildflags] [--exec] [--arguments]
+
+# Rebuild and restart the program whenever its sources change, keeping the coverage of the previous runs
+goc run . --watch
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		wd, err := os.Getwd()
 		if err != nil {
 			log.Fatalf("Fail to build: %v", err)
 		}
-		gocBuild, err := build.NewBuild(buildFlags, args, wd, buildOutput)
+		gocBuild, err := newBuild("run", args, wd)
 		if err != nil {
 			log.Fatalf("Fail to run: %v", err)
 		}
@@ -51,18 +53,22 @@ goc run . [--buildflags] [--exec] [--arguments]
 		gocBuild.GoRunArguments = goRunArguments
 		defer gocBuild.Clean()
 
-		server := cover.NewMemoryBasedServer() // only save services in memory
+		// a dry run starts no server, the program registers to the one goc run would start
+		var gocServer string
+		if dryRun == "" {
+			server := cover.NewMemoryBasedServer() // only save services in memory
 
-		// start goc server
-		var l = newLocalListener(agentPort.String())
-		go func() {
-			err = server.Route(ioutil.Discard).RunListener(l)
-			if err != nil {
-				log.Fatalf("Start goc server failed: %v", err)
-			}
-		}()
-		gocServer := fmt.Sprintf("http://%s", l.Addr().String())
-		fmt.Printf("[goc] goc server started: %s \n", gocServer)
+			// start goc server
+			var l = newLocalListener(agentPort.String())
+			go func() {
+				err = server.Route(ioutil.Discard).RunListener(l)
+				if err != nil {
+					log.Fatalf("Start goc server failed: %v", err)
+				}
+			}()
+			gocServer = fmt.Sprintf("http://%s", l.Addr().String())
+			fmt.Printf("[goc] goc server started: %s \n", gocServer)
+		}
 
 		if viper.IsSet("center") {
 			gocServer = center
@@ -76,16 +82,35 @@ goc run . [--buildflags] [--exec] [--arguments]
 			Mode:                     coverMode.String(),
 			Center:                   gocServer,
 			Singleton:                singleton,
+			IncludeGenerated:         includeGenerated,
+			SkipPackages:             skipPackages,
+			VendorPackages:           vendorPackages,
+			CacheDir:                 cacheDir,
+			Unlinked:                 unlinked,
+			Backend:                  coverBackend.String(),
 			AgentPort:                "",
 			IsMod:                    gocBuild.IsMod,
 			ModRootPath:              gocBuild.ModRootPath,
 			OneMainPackage:           true, // go run is similar with go build, build only one main package
 			GlobalCoverVarImportPath: gocBuild.GlobalCoverVarImportPath,
+			SourceDir:                gocBuild.WorkingDir,
+			GocVersion:               gocVersion(),
+		}
+		if dryRun != "" {
+			if err := runDryRun(gocBuild, ci); err != nil {
+				log.Fatalf("Fail to plan the run: %v", err)
+			}
+			return
+		}
+		if watchRun {
+			runWatch(gocBuild, ci, gocServer)
+			return
 		}
 		err = cover.Execute(ci)
 		if err != nil {
 			log.Fatalf("Fail to run: %v", err)
 		}
+		gocBuild.AppendBuildFlags(ci.BuildFlags)
 
 		if err := gocBuild.Run(); err != nil {
 			log.Fatalf("Fail to run: %v", err)
@@ -95,6 +120,9 @@ goc run . [--buildflags] [--exec] [--arguments]
 
 func init() {
 	addRunFlags(runCmd.Flags())
+	addWorkspaceFlags(runCmd.Flags())
+	addDryRunFlag(runCmd.Flags())
+	runCmd.Flags().BoolVar(&watchRun, "watch", false, "rebuild and restart the program whenever its sources change, carrying its coverage across the restarts")
 	rootCmd.AddCommand(runCmd)
 }
 
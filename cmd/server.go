@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"log"
+	"path/filepath"
 
 	"github.com/qiniu/goc/pkg/cover"
 	"github.com/spf13/cobra"
@@ -26,7 +27,10 @@ import (
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start a service registry center",
-	Long:  `Start a service registry center.`,
+	Long: `Start a service registry center.
+
+The center serves the /v2 API, described by the OpenAPI document at /v2/openapi.json,
+and the /v1 API for compatibility.`,
 	Example: `
 # Start a service registry center, default port :7777.
 goc server
@@ -36,6 +40,9 @@ goc server --port=:8080
 
 # Start a service registry center with localhost:8080.
 goc server --port=localhost:8080
+
+# Start a service registry center serving the hot report of the project it runs from.
+goc server --source-dir=.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		server, err := cover.NewFileBasedServer(localPersistence)
@@ -43,16 +50,24 @@ goc server --port=localhost:8080
 			log.Fatalf("New file based server failed, err: %v", err)
 		}
 		server.IPRevise = IPRevise
-		server.Run(port)
+		if sourceDir != "" {
+			if server.SourceDir, err = filepath.Abs(sourceDir); err != nil {
+				log.Fatalf("invalid source directory, err: %v", err)
+			}
+		}
+		if err := server.Run(port); err != nil {
+			log.Fatalf("goc server stopped, err: %v", err)
+		}
 	},
 }
 
-var port, localPersistence string
+var port, localPersistence, sourceDir string
 var IPRevise bool
 
 func init() {
 	serverCmd.Flags().StringVarP(&port, "port", "", ":7777", "listen port to start a coverage host center")
-	serverCmd.Flags().StringVarP(&localPersistence, "local-persistence", "", "_svrs_address.txt", "the file to save services address information")
+	serverCmd.Flags().StringVarP(&localPersistence, "local-persistence", "", "_svrs_address.txt", "the file to save services address information, the uploaded profiles are saved in the directory of the same name followed by .uploads")
 	serverCmd.Flags().BoolVarP(&IPRevise, "ip_revise", "", true, "whether to do ip revise during registering. Recommend to set this as false if under NAT or Proxy environment")
+	serverCmd.Flags().StringVar(&sourceDir, "source-dir", "", "source tree of the services, to serve the hot report of /v1/cover/hot and /v2/cover/hot from, the report is off if empty")
 	rootCmd.AddCommand(serverCmd)
 }
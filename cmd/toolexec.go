@@ -0,0 +1,73 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/qiniu/goc/pkg/cover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var toolexecStateDir string
+
+var toolexecCmd = &cobra.Command{
+	Use:   "toolexec [flags] tool [tool args]",
+	Short: "Do cover for the packages the go command compiles, as its -toolexec program",
+	Long: `
+Toolexec runs the tools of a go build, instrumenting the files of the packages it compiles and injecting the agent into their main packages, so that the project is built in place with the go command's own flags and build cache.
+The files of the module cache, of the standard library and the vendored ones, unless --vendor-packages selects them, are left without counters.
+`,
+	Example: `
+# Build the current binary with cover variables injected, and set the registry center to http://127.0.0.1:7777.
+go build -toolexec="goc toolexec --center=http://127.0.0.1:7777" .
+
+# Install all binaries with cover variables injected in atomic mode, with the race detector.
+go install -race -toolexec="goc toolexec --mode=atomic" ./...
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &cover.ToolexecConfig{
+			Mode:             coverMode.String(),
+			AgentPort:        agentPort.String(),
+			Center:           center,
+			Singleton:        singleton,
+			IncludeGenerated: includeGenerated,
+			SkipPackages:     skipPackages,
+			VendorPackages:   vendorPackages,
+			StateDir:         toolexecStateDir,
+		}
+		code, err := cover.Toolexec(cfg, args[0], args[1:])
+		if err != nil {
+			log.Fatalf("Fail to run %s: %v", args[0], err)
+			return
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	// the flags of the tool follow its path
+	toolexecCmd.Flags().SetInterspersed(false)
+	addInstrumentFlags(toolexecCmd.Flags())
+	toolexecCmd.Flags().StringVar(&toolexecStateDir, "state-dir", cover.DefaultToolexecStateDir(), "directory recording the counters of the compiled packages, it must live as long as the build cache")
+	// bind to viper
+	viper.BindPFlags(toolexecCmd.Flags())
+	rootCmd.AddCommand(toolexecCmd)
+}
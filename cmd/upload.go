@@ -0,0 +1,112 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/qiniu/goc/pkg/cover"
+)
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload [files...]",
+	Short: "Upload coverage files into the service center",
+	Long: `upload sends coverage the center can't pull to it, such as the coverage directory of a binary built
+with 'go build -cover' that has exited, or goc profiles collected elsewhere.
+
+The center lists the uploads under their name with an upload:// address, 'goc profile' merges them
+with the profiles of the registered services, and 'goc clear' drops them.
+`,
+	Example: `
+# Upload the coverage directory a 'go build -cover' binary wrote through GOCOVERDIR.
+goc upload --name=e2e --covdir=./coverdir
+
+# Upload goc profiles to a specified register center.
+goc upload --name=e2e a.cov b.cov --center=http://192.168.1.1:8080
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := runUpload(center, uploadName, uploadCovDirs, args)
+		if err != nil {
+			log.Fatalf("upload to %v failed, err: %v", center, err)
+			return
+		}
+		res, _ := json.Marshal(result)
+		fmt.Fprintln(os.Stdout, string(res))
+	},
+}
+
+var (
+	uploadName    string   // --name flag
+	uploadCovDirs []string // --covdir flag
+)
+
+func init() {
+	addBasicFlags(uploadCmd.Flags())
+	addTimeoutFlag(uploadCmd.Flags())
+	uploadCmd.Flags().StringVarP(&uploadName, "name", "n", "", "name the center lists the uploaded coverage under")
+	uploadCmd.Flags().StringSliceVar(&uploadCovDirs, "covdir", nil, "coverage directories written by binaries built with 'go build -cover' to upload")
+	uploadCmd.MarkFlagRequired("name")
+	rootCmd.AddCommand(uploadCmd)
+}
+
+func runUpload(host, name string, covDirs, args []string) (*cover.UploadResult, error) {
+	files := make(map[string][]byte)
+	for _, dir := range covDirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !cover.IsCovDataFile(e.Name()) {
+				continue
+			}
+			if _, ok := files[e.Name()]; ok {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			files[e.Name()] = data
+		}
+	}
+	for i, path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// the center tells the files apart by name, profiles of the same base name must not collide
+		files[fmt.Sprintf("%d-%s", i, filepath.Base(path))] = data
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no coverage file to upload")
+	}
+	client, err := cover.NewCenterClient(host, cover.CenterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := centerContext()
+	defer cancel()
+	return client.Upload(ctx, name, files)
+}
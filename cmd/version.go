@@ -34,18 +34,25 @@ var versionCmd = &cobra.Command{
 goc version
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// if it is "Unstable", means user build local or with go get
-		if version == "Unstable" {
-			if info, ok := debug.ReadBuildInfo(); ok {
-				fmt.Println(info.Main.Version)
-			}
-		} else {
-			// otherwise the value is injected in CI
-			fmt.Println(version)
+		if v := gocVersion(); v != "" {
+			fmt.Println(v)
 		}
 	},
 }
 
+// gocVersion returns the version of goc, empty if unknown
+func gocVersion() string {
+	// if it is "Unstable", means user build local or with go get
+	if version == "Unstable" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			return info.Main.Version
+		}
+		return ""
+	}
+	// otherwise the value is injected in CI
+	return version
+}
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
 }
@@ -0,0 +1,203 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/qiniu/goc/pkg/build"
+	"github.com/qiniu/goc/pkg/cover"
+	log "github.com/sirupsen/logrus"
+)
+
+var watchRun bool // --watch flag
+
+const (
+	watchInterval    = 500 * time.Millisecond // how often the sources are polled
+	watchStopTimeout = 10 * time.Second       // how long the program has to exit once interrupted
+)
+
+// runWatch runs the program, and builds and runs it again whenever its sources change. The
+// workspace is kept between the rebuilds, see Build.Update: only the changed files are copied
+// and annotated again, the instrument cache gives back the others, and the build cache of go
+// their compilation. The coverage of the previous runs goes to the center uploaded under the
+// name of the service, for the files left unchanged, so that its profile covers them all. ci
+// instruments the workspace.
+func runWatch(b *build.Build, ci *cover.CoverInfo, center string) {
+	flags := b.BuildFlags
+	name := os.Getenv("GOC_SERVICE_NAME")
+	if name == "" {
+		name = filepath.Base(b.Target)
+	}
+	// a singleton program registers nowhere
+	var client *cover.CenterClient
+	if !ci.Singleton {
+		c, err := cover.NewCenterClient(center, cover.CenterOptions{})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		client = c
+	}
+	if ci.CacheDir == "" {
+		// the rebuilds need one all the same
+		dir, err := ioutil.TempDir("", "goc-watch-cache")
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(dir)
+		ci.CacheDir = dir
+	}
+	watcher := b.NewWatcher()
+
+	// the only handler of the signals for the whole watch, the calls to the center derive from it
+	ctx, stop := interruptContext()
+	defer stop()
+
+	var (
+		program  *build.Program
+		manifest *cover.Manifest // of the build of the program
+		carry    *cover.Carry
+		written  []string // by the instrumentation of the workspace
+	)
+	for first := true; ; first = false {
+		if !first {
+			fmt.Println("[goc] sources changed, rebuilding")
+			if program != nil {
+				carry = collectCarry(ctx, client, name, manifest, carry)
+				program.Stop(watchStopTimeout)
+				program = nil
+			}
+			if client != nil {
+				// the registration of the stopped program, and the coverage carried so far
+				callCtx, cancel := centerCallContext(ctx)
+				client.Remove(callCtx, cover.ProfileParam{Service: []string{name}})
+				cancel()
+			}
+		}
+
+		var changes []string
+		if !first {
+			changes = watcher.Changes()
+		}
+		p, m, err := startWatched(ctx, b, ci, flags, first, changes, &written)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[goc] build failed, waiting for changes: %v\n", err)
+		} else {
+			program, manifest = p, m
+			uploadCarry(ctx, client, name, manifest, carry)
+		}
+
+		if !waitForChanges(ctx, watcher, program) {
+			if program != nil {
+				program.Stop(watchStopTimeout)
+			}
+			return
+		}
+	}
+}
+
+// startWatched instruments the workspace, unless first updated with the changed files and
+// the ones written by its previous instrumentation, then builds and starts the program.
+// written is set to the files this instrumentation writes.
+func startWatched(ctx context.Context, b *build.Build, ci *cover.CoverInfo, flags string, first bool, changes []string, written *[]string) (*build.Program, *cover.Manifest, error) {
+	b.BuildFlags = flags
+	if !first {
+		err := b.Update(changes, *written)
+		*written = nil
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	c := *ci
+	c.GoPath = b.NewGOPATH
+	err := cover.ExecuteContext(ctx, &c)
+	*written = c.Written
+	if err != nil {
+		return nil, nil, err
+	}
+	b.AppendBuildFlags(c.BuildFlags)
+	p, err := b.StartProgram()
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, c.Manifest, nil
+}
+
+// collectCarry returns the coverage of the running program together with the one carried so far,
+// carry if the center has none
+func collectCarry(ctx context.Context, client *cover.CenterClient, name string, manifest *cover.Manifest, carry *cover.Carry) *cover.Carry {
+	if client == nil {
+		return nil
+	}
+	ctx, cancel := centerCallContext(ctx)
+	defer cancel()
+	profiles, err := client.Profile(ctx, cover.ProfileParam{Service: []string{name}, Force: true, Uploads: true})
+	if err != nil {
+		log.Warnf("failed to get the coverage of %s before restarting it: %v", name, err)
+		return carry
+	}
+	return cover.NewCarry(profiles, manifest)
+}
+
+// uploadCarry uploads the carried coverage of the files the build with manifest left unchanged
+func uploadCarry(ctx context.Context, client *cover.CenterClient, name string, manifest *cover.Manifest, carry *cover.Carry) {
+	if client == nil {
+		return
+	}
+	profile, err := carry.Profile(manifest)
+	if err == nil && profile != nil {
+		ctx, cancel := centerCallContext(ctx)
+		defer cancel()
+		_, err = client.Upload(ctx, name, map[string][]byte{"previous.cov": profile})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[goc] failed to carry the coverage of the previous runs: %v\n", err)
+	}
+}
+
+// waitForChanges waits until the sources change and settle, false if goc is interrupted first, ctx done
+func waitForChanges(ctx context.Context, watcher *build.Watcher, program *build.Program) bool {
+	var done <-chan struct{}
+	if program != nil {
+		done = program.Done()
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-done:
+			fmt.Printf("[goc] the program exited (%v), waiting for changes\n", program.Err())
+			done = nil
+		case <-ticker.C:
+			if !watcher.Changed() {
+				continue
+			}
+			// an editor or a checkout may write several files
+			for watcher.Changed() {
+				time.Sleep(watchInterval)
+			}
+			return true
+		}
+	}
+}
@@ -17,14 +17,13 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/qiniu/goc/pkg/cover"
-	log "github.com/sirupsen/logrus"
 )
 
 // Build is to describe the building/installing process of a goc build/install
@@ -56,11 +55,15 @@ type Build struct {
 	OneMainPackage           bool   // whether this build is a go build or go install? true: build, false: install
 	GlobalCoverVarImportPath string // Importpath for storing cover variables
 	GlobalCoverVarFilePath   string // Importpath for storing cover variables
+
+	Workspace Workspace // where to copy the project, TmpDir is the workspace
+	lock      *os.File  // held until Clean, the workspace is in use
+	dryRun    string    // the go command a Build from NewDryRun plans
 }
 
 // NewBuild creates a Build struct which can build from goc temporary directory,
 // and generate binary in current working directory
-func NewBuild(buildflags string, args []string, workingDir string, outputDir string) (*Build, error) {
+func NewBuild(buildflags string, args []string, workingDir string, outputDir string, workspace Workspace) (*Build, error) {
 	if err := checkParameters(args, workingDir); err != nil {
 		return nil, err
 	}
@@ -69,9 +72,9 @@ func NewBuild(buildflags string, args []string, workingDir string, outputDir str
 		BuildFlags: buildflags,
 		Packages:   strings.Join(args, " "),
 		WorkingDir: workingDir,
+		Workspace:  workspace,
 	}
 	if false == b.validatePackageForBuild() {
-		log.Errorln(ErrWrongPackageTypeForBuild)
 		return nil, ErrWrongPackageTypeForBuild
 	}
 	if err := b.MvProjectsToTmp(); err != nil {
@@ -85,23 +88,33 @@ func NewBuild(buildflags string, args []string, workingDir string, outputDir str
 	return b, nil
 }
 
+// AppendBuildFlags adds the flags the instrumented project needs to the build flags,
+// such as the -cover ones of the native backend
+func (b *Build) AppendBuildFlags(flags string) {
+	if flags != "" {
+		b.BuildFlags = strings.TrimSpace(b.BuildFlags + " " + flags)
+	}
+}
+
 // Build calls 'go build' tool to do building
 func (b *Build) Build() error {
+	return b.BuildContext(context.Background())
+}
+
+// BuildContext is Build, go build is killed if ctx is done before it completes
+func (b *Build) BuildContext(ctx context.Context) error {
 	log.Infoln("Go building in temp...")
 	// new -o will overwrite  previous ones
-	b.BuildFlags = b.BuildFlags + " -o " + b.Target
-	cmd := exec.Command("/bin/bash", "-c", "go build "+b.BuildFlags+" "+b.Packages)
-	cmd.Dir = b.TmpWorkingDir
+	args, err := b.goArgs("build", []string{"-o", b.Target}, nil)
+	if err != nil {
+		return err
+	}
+	cmd := cover.GoCommandContext(ctx, b.TmpWorkingDir, b.NewGOPATH, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if b.NewGOPATH != "" {
-		// Change to temp GOPATH for go install command
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", b.NewGOPATH))
-	}
-
 	log.Printf("go build cmd is: %v", cmd.Args)
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return fmt.Errorf("fail to execute: %v, err: %w", cmd.Args, err)
 	}
@@ -112,6 +125,42 @@ func (b *Build) Build() error {
 	return nil
 }
 
+// modFlag returns the -mod flag of the build, from the build flags or GOFLAGS
+func (b *Build) modFlag() string {
+	flags, err := cover.SplitBuildFlags(b.BuildFlags)
+	if err != nil {
+		return ""
+	}
+	mod, _ := cover.BuildFlagValue(flags, "mod")
+	return mod
+}
+
+// goArgs returns the arguments of the go command running verb: the build flags,
+// the flags goc adds, the packages then the arguments of the program for go run
+func (b *Build) goArgs(verb string, flags []string, arguments []string) ([]string, error) {
+	buildFlags, err := cover.SplitBuildFlags(b.BuildFlags)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{verb}, buildFlags...)
+	args = append(args, flags...)
+	args = append(args, strings.Fields(b.Packages)...)
+	return append(args, arguments...), nil
+}
+
+// ManifestPath returns where the build manifest goes: next to the binary for go build,
+// in the install directory and named after the working directory for go install
+func (b *Build) ManifestPath() (string, error) {
+	if b.Target != "" {
+		return b.Target + cover.ManifestSuffix, nil
+	}
+	dir, err := b.findWhereToInstall()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(b.WorkingDir)+cover.ManifestSuffix), nil
+}
+
 // determineOutputDir, as we only allow . as package name,
 // the binary name is always same as the directory name of current directory
 func (b *Build) determineOutputDir(outputDir string) (string, error) {
@@ -155,7 +204,6 @@ func (b *Build) validatePackageForBuild() bool {
 
 func checkParameters(args []string, workingDir string) error {
 	if len(args) > 1 {
-		log.Errorln(ErrTooManyArgs)
 		return ErrTooManyArgs
 	}
 
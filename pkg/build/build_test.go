@@ -17,12 +17,15 @@
 package build
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/qiniu/goc/pkg/cover"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,7 +37,7 @@ func TestInvalidPackage(t *testing.T) {
 	os.Setenv("GOPATH", gopath)
 	os.Setenv("GO111MODULE", "on")
 
-	_, err := NewBuild("", []string{"example.com/simple-project"}, workingDir, "")
+	_, err := NewBuild("", []string{"example.com/simple-project"}, workingDir, "", Workspace{})
 	if !assert.Equal(t, err, ErrWrongPackageTypeForBuild) {
 		assert.FailNow(t, "the package name should be invalid")
 	}
@@ -48,7 +51,7 @@ func TestBasicBuildForModProject(t *testing.T) {
 	os.Setenv("GO111MODULE", "on")
 	fmt.Println(workingDir)
 	buildFlags, args, buildOutput := "", []string{"."}, ""
-	gocBuild, err := NewBuild(buildFlags, args, workingDir, buildOutput)
+	gocBuild, err := NewBuild(buildFlags, args, workingDir, buildOutput, Workspace{})
 	if !assert.Equal(t, err, nil) {
 		assert.FailNow(t, "should create temporary directory successfully")
 	}
@@ -59,6 +62,51 @@ func TestBasicBuildForModProject(t *testing.T) {
 	}
 }
 
+func TestBuildReusesGoCache(t *testing.T) {
+	workingDir := filepath.Join(baseDir, "../../tests/samples/simple_project")
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+
+	output, err := ioutil.TempDir("", "goc-build-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(output)
+	build := func() (string, string) {
+		gocBuild, err := NewBuild("", []string{"."}, workingDir, output, Workspace{})
+		assert.NoError(t, err)
+		defer gocBuild.Clean()
+		cmd := cover.GoCommand(gocBuild.TmpWorkingDir, gocBuild.NewGOPATH, "build", "-x", "-o", output, ".")
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		return gocBuild.TmpDir, string(out)
+	}
+
+	first, _ := build()
+	second, out := build()
+	assert.Equal(t, first, second)
+	// nothing compiled again, only linked
+	assert.NotRegexp(t, `(?m)[/\\]compile(\.exe)?"? `, out)
+}
+
+func TestBuildContextCanceled(t *testing.T) {
+	workingDir := filepath.Join(baseDir, "../../tests/samples/simple_project")
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+
+	output, err := ioutil.TempDir("", "goc-build-canceled")
+	assert.NoError(t, err)
+	defer os.RemoveAll(output)
+	gocBuild, err := NewBuild("", []string{"."}, workingDir, output, Workspace{})
+	assert.NoError(t, err)
+	defer gocBuild.Clean()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, gocBuild.BuildContext(ctx))
+	files, err := ioutil.ReadDir(output)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
 func TestCheckParameters(t *testing.T) {
 	err := checkParameters([]string{"aa", "bb"}, "aa")
 	assert.Equal(t, err, ErrTooManyArgs, "too many arguments should failed")
@@ -85,7 +133,7 @@ func TestInvalidPackageNameForBuild(t *testing.T) {
 	os.Setenv("GO111MODULE", "on")
 
 	buildFlags, packages := "", []string{"main.go"}
-	_, err := NewBuild(buildFlags, packages, workingDir, "")
+	_, err := NewBuild(buildFlags, packages, workingDir, "", Workspace{})
 	if !assert.Equal(t, err, ErrWrongPackageTypeForBuild) {
 		assert.FailNow(t, "should not success with non . or ./... package")
 	}
@@ -93,9 +141,9 @@ func TestInvalidPackageNameForBuild(t *testing.T) {
 
 // test NewBuild with wrong parameters
 func TestNewBuildWithWrongParameters(t *testing.T) {
-	_, err := NewBuild("", []string{"a.go", "b.go"}, "cur", "cur")
+	_, err := NewBuild("", []string{"a.go", "b.go"}, "cur", "cur", Workspace{})
 	assert.Equal(t, err, ErrTooManyArgs)
 
-	_, err = NewBuild("", []string{"a.go"}, "", "cur")
+	_, err = NewBuild("", []string{"a.go"}, "", "cur", Workspace{})
 	assert.Equal(t, err, ErrInvalidWorkingDir)
 }
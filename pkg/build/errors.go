@@ -21,4 +21,12 @@ var (
 	ErrEmptyTempWorkingDir = errors.New("temporary working directory is empty")
 	// ErrNoPlaceToInstall represents the err that no place to install the generated binary
 	ErrNoPlaceToInstall = errors.New("don't know where to install")
+	// ErrInvalidExportDir represents the error that the export directory is not empty or inside the project
+	ErrInvalidExportDir = errors.New("the export directory must be empty and outside the project")
+	// ErrInvalidWorkDir represents the error that the work directory is neither empty nor a workspace, or inside the project
+	ErrInvalidWorkDir = errors.New("the work directory must be empty or a goc workspace, and outside the project")
+	// ErrWorkDirInUse represents the error that another build uses the work directory
+	ErrWorkDirInUse = errors.New("the work directory is used by another build")
+	// ErrNoMainPackage represents the error that the project has no main package to build
+	ErrNoMainPackage = errors.New("no main package to build")
 )
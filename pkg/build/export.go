@@ -0,0 +1,86 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/qiniu/goc/pkg/cover"
+)
+
+// NewExport creates a Build struct which copies the project into the export directory
+// instead of a temporary one, to be instrumented and built by any build system
+func NewExport(buildflags string, workingDir string, exportDir string) (*Build, error) {
+	if err := checkParameters(nil, workingDir); err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(exportDir)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExportDir, dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if insideProject(workingDir, dir) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExportDir, dir)
+	}
+	b := &Build{
+		BuildFlags: buildflags,
+		WorkingDir: workingDir,
+		TmpDir:     dir,
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := b.MvProjectsToTmp(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ExportInfo describes how to build the exported project
+func (b *Build) ExportInfo() (*cover.ExportInfo, error) {
+	wd, err := filepath.Rel(b.TmpDir, b.TmpWorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	// as cover.Execute names it
+	globalCoverVarImportPath := filepath.Base(b.GlobalCoverVarImportPath)
+	if b.IsMod {
+		globalCoverVarImportPath = path.Join(b.ModRootPath, filepath.ToSlash(b.GlobalCoverVarImportPath))
+	}
+	info := &cover.ExportInfo{
+		WorkingDir:               filepath.ToSlash(wd),
+		GlobalCoverVarImportPath: globalCoverVarImportPath,
+		BuildFlags:               b.BuildFlags,
+		GOPATH:                   b.NewGOPATH,
+	}
+	for _, pkg := range b.Pkgs {
+		if pkg.Name == "main" {
+			info.MainPackages = append(info.MainPackages, pkg.ImportPath)
+		}
+	}
+	sort.Strings(info.MainPackages)
+	return info, nil
+}
@@ -0,0 +1,60 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExport(t *testing.T) {
+	workingDir := filepath.Join(baseDir, "../../tests/samples/gomod_replace_project")
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+	dir, err := ioutil.TempDir("", "goc-export")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	exportDir := filepath.Join(dir, "export")
+
+	b, err := NewExport("", workingDir, exportDir)
+	assert.NoError(t, err)
+	assert.Equal(t, exportDir, b.TmpDir)
+	// the relative replacement points at the library from the export too
+	mod, err := ioutil.ReadFile(filepath.Join(exportDir, "go.mod"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(mod), "=> "+filepath.Join(baseDir, "../../tests/samples/gomod_replace_library"))
+	_, err = os.Stat(filepath.Join(exportDir, "main.go"))
+	assert.NoError(t, err)
+
+	info, err := b.ExportInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, ".", info.WorkingDir)
+	assert.Equal(t, []string{"example.com/simple-project"}, info.MainPackages)
+	assert.True(t, strings.HasPrefix(info.GlobalCoverVarImportPath, "example.com/simple-project/src/gocbuild"), info.GlobalCoverVarImportPath)
+
+	// neither a directory in use nor one inside the project
+	_, err = NewExport("", workingDir, exportDir)
+	assert.True(t, errors.Is(err, ErrInvalidExportDir))
+	_, err = NewExport("", workingDir, filepath.Join(workingDir, "export"))
+	assert.True(t, errors.Is(err, ErrInvalidExportDir))
+}
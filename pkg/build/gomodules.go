@@ -23,24 +23,12 @@ import (
 	"path/filepath"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
-	"github.com/tongjingran/copy"
 	"golang.org/x/mod/modfile"
 )
 
 func (b *Build) cpGoModulesProject() {
-	for _, v := range b.Pkgs {
-		if v.Name == "main" {
-			dst := b.TmpDir
-			src := v.Module.Dir
-
-			if err := copy.Copy(src, dst, copy.Options{Skip: skipCopy}); err != nil {
-				log.Errorf("Failed to Copy the folder from %v to %v, the error is: %v ", src, dst, err)
-			}
-			break
-		} else {
-			continue
-		}
+	for _, c := range b.mainPackageCopies(true) {
+		copyDir(c)
 	}
 }
 
@@ -54,7 +42,7 @@ func (b *Build) cpGoModulesProject() {
 // 'replace github.com/qiniu/bar => /path/to/aa/bb/home/foo/bar'
 func (b *Build) updateGoModFile() (updateFlag bool, newModFile []byte, err error) {
 	// use buildflags `-mod=vendor` and exist vendor folder, should not update go.mod
-	if _, err1 := os.Stat(path.Join(b.ModRoot, "vendor")); err1 == nil && strings.Contains(b.BuildFlags, "-mod=vendor") {
+	if _, err1 := os.Stat(path.Join(b.ModRoot, "vendor")); err1 == nil && b.modFlag() == "vendor" {
 		return
 	}
 	tempModfile := filepath.Join(b.TmpDir, "go.mod")
@@ -95,3 +83,34 @@ func (b *Build) updateGoModFile() (updateFlag bool, newModFile []byte, err error
 	newModFile, _ = oriGoModFile.Format()
 	return
 }
+
+// updateVendorModules rewrites the vendor/modules.txt file in the temporary directory as
+// updateGoModFile rewrites go.mod, the go command checks that both replace the modules alike.
+// ex.
+// '# github.com/qiniu/bar => ../bar' becomes '# github.com/qiniu/bar => /path/to/aa/bb/home/foo/bar'
+func (b *Build) updateVendorModules() (updateFlag bool, newModules []byte, err error) {
+	buf, err := ioutil.ReadFile(filepath.Join(b.TmpDir, "vendor", "modules.txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	lines := strings.Split(string(buf), "\n")
+	for i, line := range lines {
+		// '# module [version] => replacement [version]', a local replacement has no version
+		index := strings.Index(line, " => ")
+		if !strings.HasPrefix(line, "# ") || index < 0 {
+			continue
+		}
+		newPath := line[index+len(" => "):]
+		if strings.Contains(newPath, " ") || filepath.IsAbs(newPath) {
+			continue
+		}
+		absPath, _ := filepath.Abs(filepath.Join(b.ModRoot, newPath))
+		lines[i] = line[:index+len(" => ")] + absPath
+		updateFlag = true
+	}
+	newModules = []byte(strings.Join(lines, "\n"))
+	return
+}
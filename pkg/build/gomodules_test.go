@@ -19,21 +19,22 @@ package build
 import (
 	"bytes"
 	"errors"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/qiniu/goc/pkg/cover"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
 func captureOutput(f func()) string {
 	var buf bytes.Buffer
-	log.SetOutput(&buf)
+	logrus.SetOutput(&buf)
 	f()
-	log.SetOutput(os.Stderr)
+	logrus.SetOutput(os.Stderr)
 	return buf.String()
 }
 
@@ -101,3 +102,39 @@ func TestWithWrongGoModFile(t *testing.T) {
 	assert.NotEqual(t, err, nil)
 	assert.Equal(t, updated, false)
 }
+
+// test vendor/modules.txt update
+func TestUpdateVendorModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-vendor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	b := &Build{
+		TmpDir:  dir,
+		ModRoot: "/aa/bb/cc",
+	}
+
+	// no vendor directory, nothing to update
+	updated, _, err := b.updateVendorModules()
+	assert.NoError(t, err)
+	assert.False(t, updated)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), os.ModePerm))
+	modules := `# github.com/qiniu/bar v0.0.0 => ../home/foo/bar
+## explicit
+github.com/qiniu/bar
+# github.com/qiniu/bar2 v1.0.0 => github.com/baniu/bar3 v1.2.3
+## explicit
+github.com/qiniu/bar2
+# github.com/qiniu/bar => ../home/foo/bar
+# github.com/qiniu/bar2 => github.com/baniu/bar3 v1.2.3
+`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "vendor", "modules.txt"), []byte(modules), 0644))
+	updated, newModules, err := b.updateVendorModules()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	// rewritten as updateGoModFile rewrites the replace directives
+	assert.Contains(t, string(newModules), "# github.com/qiniu/bar v0.0.0 => /aa/bb/home/foo/bar\n")
+	assert.Contains(t, string(newModules), "# github.com/qiniu/bar => /aa/bb/home/foo/bar\n")
+	assert.Contains(t, string(newModules), "# github.com/qiniu/bar2 => github.com/baniu/bar3 v1.2.3\n")
+	assert.NotContains(t, string(newModules), "../home/foo/bar")
+}
@@ -17,16 +17,16 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/qiniu/goc/pkg/cover"
 )
 
 // NewInstall creates a Build struct which can install from goc temporary directory
-func NewInstall(buildflags string, args []string, workingDir string) (*Build, error) {
+func NewInstall(buildflags string, args []string, workingDir string, workspace Workspace) (*Build, error) {
 	if err := checkParameters(args, workingDir); err != nil {
 		return nil, err
 	}
@@ -34,9 +34,9 @@ func NewInstall(buildflags string, args []string, workingDir string) (*Build, er
 		BuildFlags: buildflags,
 		Packages:   strings.Join(args, " "),
 		WorkingDir: workingDir,
+		Workspace:  workspace,
 	}
 	if false == b.validatePackageForInstall() {
-		log.Errorln(ErrWrongPackageTypeForInstall)
 		return nil, ErrWrongPackageTypeForInstall
 	}
 	if err := b.MvProjectsToTmp(); err != nil {
@@ -47,33 +47,35 @@ func NewInstall(buildflags string, args []string, workingDir string) (*Build, er
 
 // Install use the 'go install' tool to install packages
 func (b *Build) Install() error {
+	return b.InstallContext(context.Background())
+}
+
+// InstallContext is Install, go install is killed if ctx is done before it completes
+func (b *Build) InstallContext(ctx context.Context) error {
 	log.Println("Go building in temp...")
-	cmd := exec.Command("/bin/bash", "-c", "go install "+b.BuildFlags+" "+b.Packages)
-	cmd.Dir = b.TmpWorkingDir
+	args, err := b.goArgs("install", nil, nil)
+	if err != nil {
+		return err
+	}
+	cmd := cover.GoCommandContext(ctx, b.TmpWorkingDir, b.NewGOPATH, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	whereToInstall, err := b.findWhereToInstall()
 	if err != nil {
 		// ignore the err
-		log.Errorf("No place to install: %v", err)
+		log.Warnf("No place to install: %v", err)
 	}
 	// Change the temp GOBIN, to force binary install to original place
-	cmd.Env = append(os.Environ(), fmt.Sprintf("GOBIN=%v", whereToInstall))
-	if b.NewGOPATH != "" {
-		// Change to temp GOPATH for go install command
-		cmd.Env = append(cmd.Env, fmt.Sprintf("GOPATH=%v", b.NewGOPATH))
-	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("GOBIN=%v", whereToInstall))
 
 	log.Infof("go install cmd is: %v", cmd.Args)
 	err = cmd.Start()
 	if err != nil {
-		log.Errorf("Fail to execute: %v. The error is: %v", cmd.Args, err)
-		return err
+		return fmt.Errorf("fail to execute: %v, err: %w", cmd.Args, err)
 	}
 	if err = cmd.Wait(); err != nil {
-		log.Errorf("go install failed. The error is: %v", err)
-		return err
+		return fmt.Errorf("fail to execute: %v, err: %w", cmd.Args, err)
 	}
 	log.Infof("Go install successful. Binary installed in: %v", whereToInstall)
 	return nil
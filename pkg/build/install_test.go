@@ -16,7 +16,7 @@ func TestBasicInstallForModProject(t *testing.T) {
 	os.Setenv("GO111MODULE", "on")
 
 	buildFlags, packages := "", []string{"."}
-	gocBuild, err := NewInstall(buildFlags, packages, workingDir)
+	gocBuild, err := NewInstall(buildFlags, packages, workingDir, Workspace{})
 	if !assert.Equal(t, err, nil) {
 		assert.FailNow(t, "should create temporary directory successfully")
 	}
@@ -35,7 +35,7 @@ func TestInvalidPackageNameForInstall(t *testing.T) {
 	os.Setenv("GO111MODULE", "on")
 
 	buildFlags, packages := "", []string{"main.go"}
-	_, err := NewInstall(buildFlags, packages, workingDir)
+	_, err := NewInstall(buildFlags, packages, workingDir, Workspace{})
 	if !assert.Equal(t, err, ErrWrongPackageTypeForInstall) {
 		assert.FailNow(t, "should not success with non . or ./... package")
 	}
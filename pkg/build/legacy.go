@@ -19,38 +19,67 @@ package build
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/qiniu/goc/pkg/cover"
 	"github.com/tongjingran/copy"
 )
 
+// Copy is a directory of the project copied into the workspace
+type Copy struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// copyDir copies the directory, failures are logged
+func copyDir(c Copy) {
+	if err := copy.Copy(c.From, c.To, copy.Options{Skip: skipCopy}); err != nil {
+		log.Errorf("Failed to Copy the folder from %v to %v, the error is: %v ", c.From, c.To, err)
+	}
+}
+
 func (b *Build) cpLegacyProject() {
+	for _, c := range b.legacyCopies() {
+		copyDir(c)
+	}
+}
+
+// legacyCopies returns the directories of the packages and of their dependencies
+// in the same GOPATH, copied to the src directory of the workspace
+func (b *Build) legacyCopies() []Copy {
+	var copies []Copy
 	visited := make(map[string]bool)
-	for k, v := range b.Pkgs {
-		dst := filepath.Join(b.TmpDir, "src", k)
+	importPaths := make([]string, 0, len(b.Pkgs))
+	for k := range b.Pkgs {
+		importPaths = append(importPaths, k)
+	}
+	sort.Strings(importPaths)
+	for _, k := range importPaths {
+		v := b.Pkgs[k]
 		src := v.Dir
-
 		if _, ok := visited[src]; ok {
 			// Skip if already copied
 			continue
 		}
-
-		if err := copy.Copy(src, dst, copy.Options{Skip: skipCopy}); err != nil {
-			log.Errorf("Failed to Copy the folder from %v to %v, the error is: %v ", src, dst, err)
-		}
-
+		copies = append(copies, Copy{From: src, To: filepath.Join(b.TmpDir, "src", k)})
 		visited[src] = true
 
-		b.cpDepPackages(v, visited)
+		copies = append(copies, b.depCopies(v, visited)...)
 	}
+	return copies
 }
 
 // only cp dependency in root(current gopath),
 // skip deps in other GOPATHs
 func (b *Build) cpDepPackages(pkg *cover.Package, visited map[string]bool) {
+	for _, c := range b.depCopies(pkg, visited) {
+		copyDir(c)
+	}
+}
+
+func (b *Build) depCopies(pkg *cover.Package, visited map[string]bool) []Copy {
+	var copies []Copy
 	gopath := pkg.Root
 	for _, dep := range pkg.Deps {
 		src := filepath.Join(gopath, "src", dep)
@@ -65,28 +94,36 @@ func (b *Build) cpDepPackages(pkg *cover.Package, visited map[string]bool) {
 			continue
 		}
 
-		dst := filepath.Join(b.TmpDir, "src", dep)
-
-		if err := copy.Copy(src, dst, copy.Options{Skip: skipCopy}); err != nil {
-			log.Errorf("Failed to Copy the folder from %v to %v, the error is: %v ", src, dst, err)
-		}
-
+		copies = append(copies, Copy{From: src, To: filepath.Join(b.TmpDir, "src", dep)})
 		visited[src] = true
 	}
+	return copies
 }
 
 func (b *Build) cpNonStandardLegacy() {
-	for _, v := range b.Pkgs {
-		if v.Name == "main" {
-			dst := b.TmpDir
-			src := v.Dir
-
-			if err := copy.Copy(src, dst, copy.Options{Skip: skipCopy}); err != nil {
-				log.Printf("Failed to Copy the folder from %v to %v, the error is: %v ", src, dst, err)
-			}
-			break
+	for _, c := range b.mainPackageCopies(false) {
+		copyDir(c)
+	}
+}
+
+// mainPackageCopies returns the directory of a main package, or of its module, copied to the workspace
+func (b *Build) mainPackageCopies(module bool) []Copy {
+	importPaths := make([]string, 0, len(b.Pkgs))
+	for k := range b.Pkgs {
+		importPaths = append(importPaths, k)
+	}
+	sort.Strings(importPaths)
+	for _, k := range importPaths {
+		v := b.Pkgs[k]
+		if v.Name != "main" {
+			continue
+		}
+		if module {
+			return []Copy{{From: v.Module.Dir, To: b.TmpDir}}
 		}
+		return []Copy{{From: v.Dir, To: b.TmpDir}}
 	}
+	return nil
 }
 
 // skipCopy skip copy .git dir and irregular files
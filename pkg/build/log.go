@@ -0,0 +1,37 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+// log is the logger of the package, the standard logger of logrus unless SetLogger replaces it
+var log logrus.FieldLogger = logrus.StandardLogger()
+
+// SetLogger makes the package log to l, and discards its logs if l is nil.
+// The instrumentation logs to the logger of the cover package, see cover.SetLogger.
+func SetLogger(l logrus.FieldLogger) {
+	if l == nil {
+		discard := logrus.New()
+		discard.Out = ioutil.Discard
+		l = discard
+	}
+	log = l
+}
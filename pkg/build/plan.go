@@ -0,0 +1,217 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qiniu/goc/pkg/cover"
+)
+
+// Plan is what a goc build, install or run would do, see NewDryRun
+type Plan struct {
+	Verb          string           `json:"verb"`
+	Workspace     string           `json:"workspace"`            // where the project is copied, * stands for the random part of a new directory
+	KeepWorkspace bool             `json:"keepWorkspace"`        // whether the workspace is kept after the build
+	IsMod         bool             `json:"isMod"`                // whether it is a Go modules project
+	Copies        []Copy           `json:"copies"`               // the directories copied into the workspace
+	CoverVarDir   string           `json:"coverVarDir"`          // the package declaring the counters, goc backend only
+	GoMod         *GoModRewrite    `json:"goMod,omitempty"`      // the go.mod of the workspace if its replace directives are rewritten
+	ModulesTxt    *GoModRewrite    `json:"modulesTxt,omitempty"` // the vendor/modules.txt rewritten alike
+	GoWork        string           `json:"goWork,omitempty"`     // the go.work file of the project, it is not copied
+	GoWorkUsed    bool             `json:"goWorkUsed"`           // whether the build still uses GoWork, GOWORK naming it
+	Dir           string           `json:"dir"`                  // where the go command runs
+	Env           []string         `json:"env,omitempty"`        // the environment goc sets for the go command
+	Command       []string         `json:"command"`              // the go command
+	Cover         *cover.CoverPlan `json:"cover"`                // the packages and files instrumented
+}
+
+// GoModRewrite is a go.mod or vendor/modules.txt goc writes into the workspace
+type GoModRewrite struct {
+	File    string `json:"file"`
+	Content string `json:"content"`
+}
+
+// NewDryRun creates a Build which plans a goc build, install or run, named by verb, see Plan:
+// it lists the packages of the project, but no workspace is created and nothing is copied
+func NewDryRun(verb string, buildflags string, args []string, workingDir string, outputDir string, workspace Workspace) (*Build, error) {
+	if err := checkParameters(args, workingDir); err != nil {
+		return nil, err
+	}
+	b := &Build{
+		BuildFlags: buildflags,
+		Packages:   strings.Join(args, " "),
+		WorkingDir: workingDir,
+		Workspace:  workspace,
+		dryRun:     verb,
+	}
+	switch verb {
+	case "build", "run":
+		if false == b.validatePackageForBuild() {
+			return nil, ErrWrongPackageTypeForBuild
+		}
+	case "install":
+		if false == b.validatePackageForInstall() {
+			return nil, ErrWrongPackageTypeForInstall
+		}
+	default:
+		return nil, fmt.Errorf("unknown go command: %s", verb)
+	}
+
+	if err := b.listPackages(); err != nil {
+		return nil, err
+	}
+	dir, err := b.plannedWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	b.TmpDir = dir
+	if err := b.layout(); err != nil {
+		return nil, err
+	}
+	b.setGOPATH()
+	if verb != "install" {
+		if b.Target, err = b.determineOutputDir(outputDir); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// plannedWorkspace returns the workspace createWorkspace would create
+func (b *Build) plannedWorkspace() (string, error) {
+	if b.Workspace.Dir == "" {
+		return filepath.Join(os.TempDir(), tmpFolderName(b.WorkingDir)+"-*"), nil
+	}
+	dir, err := filepath.Abs(b.Workspace.Dir)
+	if err != nil {
+		return "", err
+	}
+	if insideProject(b.WorkingDir, dir) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidWorkDir, dir)
+	}
+	return dir, nil
+}
+
+// Plan returns the plan of a Build created by NewDryRun, ci is the CoverInfo the build would
+// instrument the workspace with, the packages are planned from the project itself
+func (b *Build) Plan(ci *cover.CoverInfo) (*Plan, error) {
+	if b.dryRun == "" {
+		return nil, fmt.Errorf("not a dry run: %w", ErrShouldNotReached)
+	}
+	p := &Plan{
+		Verb:          b.dryRun,
+		Workspace:     b.TmpDir,
+		KeepWorkspace: b.Workspace.Keep,
+		IsMod:         b.IsMod,
+		Dir:           b.TmpWorkingDir,
+	}
+
+	// the copy of the project Execute lists is the project itself here
+	planned := *ci
+	planned.GoPath = ""
+	var patterns []string
+	if b.IsMod == false && b.Root != "" {
+		p.Copies = b.legacyCopies()
+		planned.Target = b.WorkingDir
+		for _, c := range p.Copies {
+			importPath, err := filepath.Rel(filepath.Join(b.TmpDir, "src"), c.To)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, filepath.ToSlash(importPath)+"/...")
+		}
+	} else {
+		p.Copies = b.mainPackageCopies(b.IsMod)
+		if len(p.Copies) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoMainPackage, b.WorkingDir)
+		}
+		planned.Target = p.Copies[0].From
+		patterns = []string{"./..."}
+	}
+	coverPlan, err := cover.PlanCover(&planned, patterns)
+	if err != nil {
+		return nil, err
+	}
+	p.Cover = coverPlan
+	if ci.Backend != cover.BackendNative {
+		p.CoverVarDir = filepath.Join(b.TmpDir, b.GlobalCoverVarImportPath)
+	}
+
+	if b.IsMod {
+		if err := b.planGoMod(p); err != nil {
+			return nil, err
+		}
+	}
+
+	// the build flags of the backend, as the build appends them
+	c := *b
+	c.AppendBuildFlags(coverPlan.BuildFlags)
+	switch b.dryRun {
+	case "build":
+		p.Command, err = c.goArgs("build", []string{"-o", b.Target}, nil)
+	case "install":
+		p.Command, err = c.goArgs("install", nil, nil)
+		if whereToInstall, err := b.findWhereToInstall(); err == nil {
+			p.Env = append(p.Env, "GOBIN="+whereToInstall)
+		}
+	case "run":
+		p.Command, err = c.runArgs()
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Command = append([]string{"go"}, p.Command...)
+	if b.NewGOPATH != "" {
+		p.Env = append([]string{"GOPATH=" + b.NewGOPATH}, p.Env...)
+	}
+	return p, nil
+}
+
+// planGoMod plans the rewrite of the go.mod, and finds the go.work left behind
+func (b *Build) planGoMod(p *Plan) error {
+	// updateGoModFile reads the go.mod of the workspace, the one of the project here
+	c := *b
+	c.TmpDir = b.ModRoot
+	updated, content, err := c.updateGoModFile()
+	if err != nil {
+		return fmt.Errorf("fail to generate new go.mod: %v", err)
+	}
+	if updated {
+		p.GoMod = &GoModRewrite{File: filepath.Join(b.TmpDir, "go.mod"), Content: string(content)}
+		updated, content, err := c.updateVendorModules()
+		if err != nil {
+			return fmt.Errorf("fail to generate new vendor/modules.txt: %v", err)
+		}
+		if updated {
+			p.ModulesTxt = &GoModRewrite{File: filepath.Join(b.TmpDir, "vendor", "modules.txt"), Content: string(content)}
+		}
+	}
+
+	out, err := cover.GoCommand(b.WorkingDir, "", "env", "GOWORK").Output()
+	if err != nil {
+		return fmt.Errorf("fail to get GOWORK: %v", err)
+	}
+	if goWork := strings.TrimSpace(string(out)); goWork != "" && goWork != "off" {
+		p.GoWork = goWork
+		p.GoWorkUsed = os.Getenv("GOWORK") != ""
+	}
+	return nil
+}
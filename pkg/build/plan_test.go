@@ -0,0 +1,69 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunPlan(t *testing.T) {
+	workingDir := filepath.Join(baseDir, "../../tests/samples/gomod_replace_project")
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+
+	b, err := NewDryRun("build", "", []string{"."}, workingDir, "", Workspace{})
+	assert.NoError(t, err)
+	defer b.Clean()
+	// nothing is created
+	assert.True(t, strings.HasSuffix(b.TmpDir, "-*"), b.TmpDir)
+	_, err = os.Stat(b.TmpDir)
+	assert.True(t, os.IsNotExist(err))
+
+	p, err := b.Plan(&cover.CoverInfo{
+		Mode:                     "count",
+		IsMod:                    b.IsMod,
+		ModRootPath:              b.ModRootPath,
+		GlobalCoverVarImportPath: b.GlobalCoverVarImportPath,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Copy{{From: workingDir, To: b.TmpDir}}, p.Copies)
+	assert.Equal(t, filepath.Join(b.TmpDir, b.GlobalCoverVarImportPath), p.CoverVarDir)
+	// the relative replacement is rewritten
+	if assert.NotNil(t, p.GoMod) {
+		assert.Contains(t, p.GoMod.Content, "=> "+filepath.Join(baseDir, "../../tests/samples/gomod_replace_library"))
+	}
+	assert.Equal(t, []string{"go", "build", "-o", filepath.Join(workingDir, "simple-project"), "."}, p.Command)
+	assert.Equal(t, b.TmpDir, p.Dir)
+	assert.Equal(t, []string{"example.com/simple-project"}, p.Cover.Mains)
+	if assert.Len(t, p.Cover.Manifest.Packages, 1) {
+		assert.True(t, p.Cover.Manifest.Packages[0].Files[0].Blocks > 0)
+	}
+	assert.NotEmpty(t, p.Cover.Manifest.Hash)
+
+	// the work directory is checked, not created
+	_, err = NewDryRun("install", "", nil, workingDir, "", Workspace{Dir: filepath.Join(workingDir, "work")})
+	assert.True(t, errors.Is(err, ErrInvalidWorkDir))
+	_, err = NewDryRun("install", "", []string{"./cmd"}, workingDir, "", Workspace{})
+	assert.True(t, errors.Is(err, ErrWrongPackageTypeForInstall))
+}
@@ -17,27 +17,31 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/qiniu/goc/pkg/cover"
 )
 
 // Run excutes the main package in addition with the internal goc features
 func (b *Build) Run() error {
-	cmd := exec.Command("/bin/bash", "-c", "go run "+b.BuildFlags+" "+b.GoRunExecFlag+" "+b.Packages+" "+b.GoRunArguments)
-	cmd.Dir = b.TmpWorkingDir
+	return b.RunContext(context.Background())
+}
 
-	if b.NewGOPATH != "" {
-		// Change to temp GOPATH for go install command
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", b.NewGOPATH))
+// RunContext is Run, go run is killed if ctx is done before it completes
+func (b *Build) RunContext(ctx context.Context) error {
+	args, err := b.runArgs()
+	if err != nil {
+		return err
 	}
+	cmd := cover.GoCommandContext(ctx, b.TmpWorkingDir, b.NewGOPATH, args...)
 
 	log.Infof("go build cmd is: %v", cmd.Args)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return fmt.Errorf("fail to execute: %v, err: %w", cmd.Args, err)
 	}
@@ -48,3 +52,28 @@ func (b *Build) Run() error {
 
 	return nil
 }
+
+// runArgs returns the arguments of go run, with the -exec flag and the arguments of the program
+func (b *Build) runArgs() ([]string, error) {
+	execFlags, err := b.execFlags()
+	if err != nil {
+		return nil, err
+	}
+	arguments, err := cover.SplitBuildFlags(b.GoRunArguments)
+	if err != nil {
+		return nil, err
+	}
+	return b.goArgs("run", execFlags, arguments)
+}
+
+// execFlags returns the -exec flag of go run
+func (b *Build) execFlags() ([]string, error) {
+	if b.GoRunExecFlag == "" {
+		return nil, nil
+	}
+	// the program, or the -exec flag with it
+	if strings.HasPrefix(b.GoRunExecFlag, "-exec") || strings.HasPrefix(b.GoRunExecFlag, "--exec") {
+		return cover.SplitBuildFlags(b.GoRunExecFlag)
+	}
+	return []string{"-exec", b.GoRunExecFlag}, nil
+}
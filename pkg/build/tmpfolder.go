@@ -26,29 +26,35 @@ import (
 	"strings"
 
 	"github.com/qiniu/goc/pkg/cover"
-	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 // MvProjectsToTmp moves the projects into a temporary directory
 func (b *Build) MvProjectsToTmp() error {
-	listArgs := []string{"-json"}
-	if len(b.BuildFlags) != 0 {
-		listArgs = append(listArgs, b.BuildFlags)
-	}
-	listArgs = append(listArgs, "./...")
-	var err error
-	b.Pkgs, err = cover.ListPackages(b.WorkingDir, strings.Join(listArgs, " "), "")
-	if err != nil {
-		log.Errorln(err)
+	if err := b.listPackages(); err != nil {
 		return err
 	}
 
-	err = b.mvProjectsToTmp()
+	if err := b.mvProjectsToTmp(); err != nil {
+		return fmt.Errorf("fail to move the project to temporary directory: %w", err)
+	}
+	b.setGOPATH()
+	log.Infof("New GOPATH: %v", b.NewGOPATH)
+	return nil
+}
+
+// listPackages lists the packages of the project with the build flags
+func (b *Build) listPackages() error {
+	flags, err := cover.SplitBuildFlags(b.BuildFlags)
 	if err != nil {
-		log.Errorf("Fail to move the project to temporary directory")
 		return err
 	}
+	listArgs := append(cover.ListFlags(flags), "-json", "./...")
+	b.Pkgs, err = cover.ListPackages(b.WorkingDir, listArgs, "")
+	return err
+}
+
+// setGOPATH sets the GOPATH building the project in the temporary directory
+func (b *Build) setGOPATH() {
 	b.OriGOPATH = os.Getenv("GOPATH")
 	if b.IsMod {
 		b.NewGOPATH = ""
@@ -64,34 +70,25 @@ func (b *Build) MvProjectsToTmp() error {
 	if b.NewGOPATH == "" && b.Root == "" && !b.IsMod {
 		b.NewGOPATH = b.OriGOPATH
 	}
-	log.Infof("New GOPATH: %v", b.NewGOPATH)
-	return nil
 }
 
 func (b *Build) mvProjectsToTmp() error {
-	b.TmpDir = filepath.Join(os.TempDir(), tmpFolderName(b.WorkingDir))
-
-	// Delete previous tmp folder and its content
-	os.RemoveAll(b.TmpDir)
+	// a workspace of its own, concurrent builds of the same project don't share one
+	if b.TmpDir == "" {
+		if err := b.createWorkspace(); err != nil {
+			return err
+		}
+	}
+	if err := b.layout(); err != nil {
+		return err
+	}
 	// Create a new tmp folder and a new importpath for storing cover variables
-	b.GlobalCoverVarImportPath = filepath.Join("src", tmpPackageName(b.WorkingDir))
 	err := os.MkdirAll(filepath.Join(b.TmpDir, b.GlobalCoverVarImportPath), os.ModePerm)
 	if err != nil {
 		return fmt.Errorf("Fail to create the temporary build directory. The err is: %v", err)
 	}
 	log.Infof("Tmp project generated in: %v", b.TmpDir)
 
-	// traverse pkg list to get project meta info
-	b.IsMod, b.Root, err = b.traversePkgsList()
-	log.Infof("mod project? %v", b.IsMod)
-	if errors.Is(err, ErrShouldNotReached) {
-		return fmt.Errorf("mvProjectsToTmp with a empty project: %w", err)
-	}
-	// we should get corresponding working directory in temporary directory
-	b.TmpWorkingDir, err = b.getTmpwd()
-	if err != nil {
-		return fmt.Errorf("getTmpwd failed with error: %w", err)
-	}
 	// issue #14
 	// if b.Root == "", then the project is non-standard project
 	// known cases:
@@ -111,9 +108,19 @@ func (b *Build) mvProjectsToTmp() error {
 			if err != nil {
 				return fmt.Errorf("fail to update go.mod: %v", err)
 			}
+			updated, newModules, err := b.updateVendorModules()
+			if err != nil {
+				return fmt.Errorf("fail to generate new vendor/modules.txt: %v", err)
+			}
+			if updated {
+				log.Infoln("vendor/modules.txt needs rewrite")
+				err := ioutil.WriteFile(filepath.Join(b.TmpDir, "vendor", "modules.txt"), newModules, os.ModePerm)
+				if err != nil {
+					return fmt.Errorf("fail to update vendor/modules.txt: %v", err)
+				}
+			}
 		}
 	} else if b.IsMod == false && b.Root == "" {
-		b.TmpWorkingDir = b.TmpDir
 		b.cpNonStandardLegacy()
 	}
 
@@ -121,8 +128,32 @@ func (b *Build) mvProjectsToTmp() error {
 	return nil
 }
 
+// layout sets where the project goes in the temporary directory, from the listed packages
+func (b *Build) layout() error {
+	// a new importpath for storing cover variables
+	b.GlobalCoverVarImportPath = filepath.Join("src", tmpPackageName(b.WorkingDir))
+
+	// traverse pkg list to get project meta info
+	var err error
+	b.IsMod, b.Root, err = b.traversePkgsList()
+	log.Infof("mod project? %v", b.IsMod)
+	if errors.Is(err, ErrShouldNotReached) {
+		return fmt.Errorf("mvProjectsToTmp with a empty project: %w", err)
+	}
+	// we should get corresponding working directory in temporary directory
+	b.TmpWorkingDir, err = b.getTmpwd()
+	if err != nil {
+		return fmt.Errorf("getTmpwd failed with error: %w", err)
+	}
+	if b.IsMod == false && b.Root == "" {
+		b.TmpWorkingDir = b.TmpDir
+	}
+	return nil
+}
+
 // tmpFolderName uses the first six characters of the input path's SHA256 checksum
-// as the suffix.
+// as the suffix, the workspaces add the number of their locked slot, name-0 to name-7,
+// or a random one once all the slots are held, see workspaceSlot.
 func tmpFolderName(path string) string {
 	sum := sha256.Sum256([]byte(path))
 	h := fmt.Sprintf("%x", sum[:6])
@@ -157,7 +188,6 @@ func (b *Build) traversePkgsList() (isMod bool, root string, err error) {
 		b.ModRootPath = v.Module.Path
 		return
 	}
-	log.Error(ErrShouldNotReached)
 	err = ErrShouldNotReached
 	return
 }
@@ -202,11 +232,3 @@ func (b *Build) findWhereToInstall() (string, error) {
 	}
 	return filepath.Join(os.Getenv("HOME"), "go", "bin"), nil
 }
-
-// Clean clears up the temporary workspace
-func (b *Build) Clean() error {
-	if !viper.GetBool("debug") {
-		return os.RemoveAll(b.TmpDir)
-	}
-	return nil
-}
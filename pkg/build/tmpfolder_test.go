@@ -39,7 +39,7 @@ func TestNewDirParseInLegacyProject(t *testing.T) {
 	os.Setenv("GOPATH", gopath)
 	os.Setenv("GO111MODULE", "off")
 
-	b, _ := NewInstall("", []string{"."}, workingDir)
+	b, _ := NewInstall("", []string{"."}, workingDir, Workspace{})
 	if -1 == strings.Index(b.TmpWorkingDir, b.TmpDir) {
 		t.Fatalf("Directory parse error. newwd: %v, tmpdir: %v", b.TmpWorkingDir, b.TmpDir)
 	}
@@ -48,7 +48,7 @@ func TestNewDirParseInLegacyProject(t *testing.T) {
 		t.Fatalf("The New GOPATH is wrong. newgopath: %v, tmpdir: %v", b.NewGOPATH, b.TmpDir)
 	}
 
-	b, _ = NewBuild("", []string{"."}, workingDir, "")
+	b, _ = NewBuild("", []string{"."}, workingDir, "", Workspace{})
 	if -1 == strings.Index(b.TmpWorkingDir, b.TmpDir) {
 		t.Fatalf("Directory parse error. newwd: %v, tmpdir: %v", b.TmpWorkingDir, b.TmpDir)
 	}
@@ -66,7 +66,7 @@ func TestNewDirParseInModProject(t *testing.T) {
 	os.Setenv("GOPATH", gopath)
 	os.Setenv("GO111MODULE", "on")
 
-	b, _ := NewInstall("", []string{"."}, workingDir)
+	b, _ := NewInstall("", []string{"."}, workingDir, Workspace{})
 	if -1 == strings.Index(b.TmpWorkingDir, b.TmpDir) {
 		t.Fatalf("Directory parse error. newwd: %v, tmpdir: %v", b.TmpWorkingDir, b.TmpDir)
 	}
@@ -75,7 +75,7 @@ func TestNewDirParseInModProject(t *testing.T) {
 		t.Fatalf("The New GOPATH is wrong. newgopath: %v, tmpdir: %v", b.NewGOPATH, b.TmpDir)
 	}
 
-	b, _ = NewBuild("", []string{"."}, workingDir, "")
+	b, _ = NewBuild("", []string{"."}, workingDir, "", Workspace{})
 	if -1 == strings.Index(b.TmpWorkingDir, b.TmpDir) {
 		t.Fatalf("Directory parse error. newwd: %v, tmpdir: %v", b.TmpWorkingDir, b.TmpDir)
 	}
@@ -94,7 +94,7 @@ func TestLegacyProjectNotInGoPATH(t *testing.T) {
 	os.Setenv("GOPATH", gopath)
 	os.Setenv("GO111MODULE", "off")
 
-	b, _ := NewBuild("", []string{"."}, workingDir, "")
+	b, _ := NewBuild("", []string{"."}, workingDir, "", Workspace{})
 	if !strings.HasSuffix(b.NewGOPATH, b.OriGOPATH) {
 		t.Fatalf("New GOPATH should contains old GOPATH for this kind of project. New: %v, old: %v", b.NewGOPATH, b.OriGOPATH)
 	}
@@ -0,0 +1,282 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/tongjingran/copy"
+)
+
+// Refresh copies the whole project into the same workspace again, for goc run --watch to build
+// it anew after it changed. The instrument cache spares the files left unchanged the
+// instrumentation, and the build cache of go their compilation.
+func (b *Build) Refresh() error {
+	entries, err := ioutil.ReadDir(b.TmpDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == workspaceLockFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(b.TmpDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return b.MvProjectsToTmp()
+}
+
+// Update brings the workspace of a module project up to date for goc run --watch to build it
+// anew: the changed files of the project are copied, or removed, and the files written, which
+// instrumenting the workspace annotated or added, restored from the project. The other files
+// keep their copy, and instrumenting the workspace again annotates the changed files only, the
+// instrument cache giving back the others. A project outside of a module, or a change of
+// go.mod, go.sum or vendor/modules.txt, gets the whole project copied again by Refresh.
+func (b *Build) Update(changes, written []string) error {
+	copies := b.mainPackageCopies(true)
+	if !b.IsMod || len(copies) != 1 {
+		return b.Refresh()
+	}
+	c := copies[0]
+	for _, file := range changes {
+		rel, err := filepath.Rel(c.From, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return b.Refresh()
+		}
+		switch filepath.ToSlash(rel) {
+		case "go.mod", "go.sum", "vendor/modules.txt":
+			return b.Refresh()
+		}
+	}
+
+	for _, file := range written {
+		rel, err := filepath.Rel(c.To, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if err := syncFile(filepath.Join(c.From, rel), file); err != nil {
+			log.Warnf("failed to restore %v, copying the project again: %v", file, err)
+			return b.Refresh()
+		}
+	}
+	for _, file := range changes {
+		rel, _ := filepath.Rel(c.From, file)
+		if err := syncFile(file, filepath.Join(c.To, rel)); err != nil {
+			log.Warnf("failed to copy %v, copying the project again: %v", file, err)
+			return b.Refresh()
+		}
+	}
+	log.Infof("%d changed files copied to %v", len(changes), b.TmpDir)
+	return nil
+}
+
+// syncFile copies src to dest, or removes dest if there is no src
+func syncFile(src, dest string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return copy.Copy(src, dest)
+}
+
+// Watcher polls the directories a build copies for changes
+type Watcher struct {
+	dirs    []string
+	files   map[string]fileState
+	changes map[string]bool // since the last call of Changes
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewWatcher watches the sources of the project
+func (b *Build) NewWatcher() *Watcher {
+	var copies []Copy
+	if b.IsMod == false && b.Root != "" {
+		copies = b.legacyCopies()
+	} else {
+		copies = b.mainPackageCopies(b.IsMod)
+	}
+	w := &Watcher{}
+	for _, c := range copies {
+		w.dirs = append(w.dirs, c.From)
+	}
+	w.files = w.scan()
+	return w
+}
+
+// Changed reports whether a file was added, removed or modified since the last call,
+// the tests and the hidden files aside
+func (w *Watcher) Changed() bool {
+	files := w.scan()
+	changed := false
+	for name, state := range files {
+		if old, ok := w.files[name]; !ok || old != state {
+			w.change(name)
+			changed = true
+		}
+	}
+	for name := range w.files {
+		if _, ok := files[name]; !ok {
+			w.change(name)
+			changed = true
+		}
+	}
+	w.files = files
+	return changed
+}
+
+func (w *Watcher) change(name string) {
+	if w.changes == nil {
+		w.changes = make(map[string]bool)
+	}
+	w.changes[name] = true
+}
+
+// Changes returns the sorted files Changed found added, removed or modified since the last call
+func (w *Watcher) Changes() []string {
+	changes := make([]string, 0, len(w.changes))
+	for name := range w.changes {
+		changes = append(changes, name)
+	}
+	sort.Strings(changes)
+	w.changes = nil
+	return changes
+}
+
+func (w *Watcher) scan() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, dir := range w.dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// gone meanwhile
+				return nil
+			}
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && !strings.HasSuffix(info.Name(), "_test.go") {
+				files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// Program is a main package goc run --watch builds and runs, it restarts it when it changes
+type Program struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// StartProgram builds the main package into the workspace and starts it as go run would,
+// with its -exec program and its arguments. The binary is named after the target.
+func (b *Build) StartProgram() (*Program, error) {
+	bin := filepath.Join(b.TmpDir, ".goc-run", filepath.Base(b.Target))
+	args, err := b.goArgs("build", []string{"-o", bin}, nil)
+	if err != nil {
+		return nil, err
+	}
+	cmd := cover.GoCommand(b.TmpWorkingDir, b.NewGOPATH, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Infof("go build cmd is: %v", cmd.Args)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("fail to execute: %v, err: %w", cmd.Args, err)
+	}
+
+	program, err := b.execProgram()
+	if err != nil {
+		return nil, err
+	}
+	arguments, err := cover.SplitBuildFlags(b.GoRunArguments)
+	if err != nil {
+		return nil, err
+	}
+	program = append(append(program, bin), arguments...)
+	p := &Program{cmd: exec.Command(program[0], program[1:]...), done: make(chan struct{})}
+	p.cmd.Dir = b.WorkingDir
+	p.cmd.Stdin = os.Stdin
+	p.cmd.Stdout = os.Stdout
+	p.cmd.Stderr = os.Stderr
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("fail to execute: %v, err: %w", p.cmd.Args, err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// execProgram returns the program and its arguments the -exec flag of go run gives
+func (b *Build) execProgram() ([]string, error) {
+	flags, err := b.execFlags()
+	if err != nil || len(flags) == 0 {
+		return nil, err
+	}
+	value := ""
+	if len(flags) > 1 {
+		value = flags[1]
+	} else if i := strings.Index(flags[0], "="); i >= 0 {
+		value = flags[0][i+1:]
+	}
+	return cover.SplitBuildFlags(value)
+}
+
+// Done is closed when the program exits
+func (p *Program) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns how the program exited, once Done is closed
+func (p *Program) Err() error {
+	return p.err
+}
+
+// Stop interrupts the program and waits for it to exit, it is killed after timeout
+func (p *Program) Stop(timeout time.Duration) {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		// no interrupt on windows
+		p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		log.Warnf("%v did not exit after %v, killing it", p.cmd.Args, timeout)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
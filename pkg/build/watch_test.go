@@ -0,0 +1,107 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qiniu/goc/pkg/cover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	main := filepath.Join(dir, "main.go")
+	assert.NoError(t, ioutil.WriteFile(main, []byte("package main\n"), 0644))
+
+	w := &Watcher{dirs: []string{dir}}
+	w.files = w.scan()
+	assert.False(t, w.Changed())
+
+	// tests and hidden files are ignored
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main_test.go"), []byte("package main\n"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref\n"), 0644))
+	assert.False(t, w.Changed())
+
+	assert.NoError(t, ioutil.WriteFile(main, []byte("package main\n\nfunc main() {}\n"), 0644))
+	assert.True(t, w.Changed())
+	assert.False(t, w.Changed())
+	assert.Equal(t, []string{main}, w.Changes())
+	assert.Empty(t, w.Changes())
+
+	lib := filepath.Join(dir, "lib", "lib.go")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(lib), 0755))
+	assert.NoError(t, ioutil.WriteFile(lib, []byte("package lib\n"), 0644))
+	assert.True(t, w.Changed())
+
+	assert.NoError(t, os.Remove(lib))
+	assert.True(t, w.Changed())
+	assert.Equal(t, []string{lib}, w.Changes())
+}
+
+func TestBuildUpdate(t *testing.T) {
+	src, err := ioutil.TempDir("", "goc-update-src")
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+	ws, err := ioutil.TempDir("", "goc-update-ws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(ws)
+
+	write := func(file, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+	read := func(file string) string {
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		return string(content)
+	}
+	for _, name := range []string{"go.mod", "main.go", "lib/lib.go", "old.go", "same.go"} {
+		write(filepath.Join(src, name), "source of "+name)
+		write(filepath.Join(ws, name), "copy of "+name)
+	}
+	write(filepath.Join(ws, "main.go"), "annotated main.go")
+	write(filepath.Join(ws, "agent.go"), "agent")
+	write(filepath.Join(src, "lib/lib.go"), "changed lib.go")
+	write(filepath.Join(src, "new.go"), "new.go")
+	assert.NoError(t, os.Remove(filepath.Join(src, "old.go")))
+
+	b := &Build{
+		IsMod:  true,
+		TmpDir: ws,
+		Pkgs:   map[string]*cover.Package{"example.com/m": {Name: "main", Module: &cover.ModulePublic{Dir: src}}},
+	}
+	changes := []string{filepath.Join(src, "lib/lib.go"), filepath.Join(src, "new.go"), filepath.Join(src, "old.go")}
+	written := []string{filepath.Join(ws, "main.go"), filepath.Join(ws, "agent.go")}
+	assert.NoError(t, b.Update(changes, written))
+
+	// the written files are restored, the changed ones copied, the others left alone
+	assert.Equal(t, "source of main.go", read(filepath.Join(ws, "main.go")))
+	assert.Equal(t, "changed lib.go", read(filepath.Join(ws, "lib/lib.go")))
+	assert.Equal(t, "new.go", read(filepath.Join(ws, "new.go")))
+	assert.Equal(t, "copy of same.go", read(filepath.Join(ws, "same.go")))
+	for _, name := range []string{"agent.go", "old.go"} {
+		_, err := os.Stat(filepath.Join(ws, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// workspaceLockFile is held locked by the build using the workspace, the workspace of a
+	// build which exited without cleaning it is the one whose lock can be taken
+	workspaceLockFile = ".goc.lock"
+	// workspaceKeepFile marks a workspace kept on purpose, never cleaned automatically
+	workspaceKeepFile = ".goc.keep"
+	// workspaceSlots is how many builds of a checkout at once get a workspace of the same
+	// path from build to build, the ones beyond get a new directory
+	workspaceSlots = 8
+)
+
+// errLockUnsupported is returned by lockFile where files can't be locked
+var errLockUnsupported = errors.New("file locks unsupported")
+
+// Workspace tells where the project is copied to be instrumented and built. The zero value
+// is a directory under the temporary directory, removed after the build. Its path only
+// depends on the checkout so that go build reuses its cache from a build to the next.
+type Workspace struct {
+	Dir  string // a fixed directory instead, empty or the workspace of a previous build
+	Keep bool   // keep the workspace after the build, for debugging
+}
+
+// createWorkspace creates the workspace of the build and locks it until Clean,
+// it removes the workspaces builds which crashed left behind first
+func (b *Build) createWorkspace() error {
+	removeStaleWorkspaces(os.TempDir())
+
+	if b.Workspace.Dir == "" {
+		dir, lock, err := workspaceSlot(os.TempDir(), tmpFolderName(b.WorkingDir))
+		if err != nil {
+			return fmt.Errorf("Fail to create the temporary build directory. The err is: %v", err)
+		}
+		b.TmpDir = dir
+		b.lock = lock
+		return nil
+	}
+
+	dir, err := filepath.Abs(b.Workspace.Dir)
+	if err != nil {
+		return err
+	}
+	if insideProject(b.WorkingDir, dir) {
+		return fmt.Errorf("%w: %s", ErrInvalidWorkDir, dir)
+	}
+	if err := clearWorkspace(dir); err != nil {
+		return err
+	}
+	lock, err := lockWorkspace(dir)
+	if err != nil {
+		return err
+	}
+	b.TmpDir = dir
+	b.lock = lock
+	return nil
+}
+
+// workspaceSlot takes the first of the workspaces name-0, name-1... under dir no build holds,
+// the workspace of a path the previous builds used rather than a random one, which would
+// change the packages' paths and so make go build compile them all again
+func workspaceSlot(dir, name string) (string, *os.File, error) {
+	for n := 0; n < workspaceSlots; n++ {
+		slot := filepath.Join(dir, fmt.Sprintf("%s-%d", name, n))
+		err := os.Mkdir(slot, os.ModePerm)
+		if err == nil {
+			lock, err := lockWorkspace(slot)
+			return slot, lock, err
+		}
+		if !os.IsExist(err) {
+			return "", nil, err
+		}
+		if lock := reuseSlot(slot); lock != nil {
+			return slot, lock, nil
+		}
+	}
+	slot, err := ioutil.TempDir(dir, name+"-")
+	if err != nil {
+		return "", nil, err
+	}
+	lock, err := lockWorkspace(slot)
+	return slot, lock, err
+}
+
+// reuseSlot empties the slot left by a build which is over and returns its lock, still held,
+// nil if a build holds it, keeps it, or is creating it
+func reuseSlot(slot string) *os.File {
+	if _, err := os.Stat(filepath.Join(slot, workspaceKeepFile)); err == nil {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(slot, workspaceLockFile))
+	if err != nil {
+		return nil
+	}
+	if err := lockFile(f); err != nil {
+		// held, or no way to tell it isn't
+		f.Close()
+		return nil
+	}
+	entries, err := ioutil.ReadDir(slot)
+	if err != nil {
+		f.Close()
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.Name() == workspaceLockFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(slot, entry.Name())); err != nil {
+			f.Close()
+			return nil
+		}
+	}
+	return f
+}
+
+// clearWorkspace empties the directory given as workspace, if it is the one of a previous build
+func clearWorkspace(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) || err == nil && len(entries) == 0 {
+		return os.MkdirAll(dir, os.ModePerm)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, workspaceLockFile))
+	if err != nil {
+		// not a workspace, the user's files stay
+		return fmt.Errorf("%w: %s", ErrInvalidWorkDir, dir)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil && err != errLockUnsupported {
+		return fmt.Errorf("%w: %s", ErrWorkDirInUse, dir)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockWorkspace locks the workspace, the lock file is locked before it gets its name
+// so that no other build takes the workspace for a stale one meanwhile
+func lockWorkspace(dir string) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, workspaceLockFile+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil && err != errLockUnsupported {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, workspaceLockFile)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// removeStaleWorkspaces removes the workspaces under dir no build holds and none keeps
+func removeStaleWorkspaces(dir string) {
+	workspaces, _ := filepath.Glob(filepath.Join(dir, "goc-build-*"))
+	for _, ws := range workspaces {
+		if _, err := os.Stat(filepath.Join(ws, workspaceKeepFile)); err == nil {
+			continue
+		}
+		f, err := os.Open(filepath.Join(ws, workspaceLockFile))
+		if err != nil {
+			// a workspace of an older goc, or not one at all
+			continue
+		}
+		if lockFile(f) == nil {
+			log.Infof("Remove the stale workspace %s", ws)
+			os.RemoveAll(ws)
+		}
+		f.Close()
+	}
+}
+
+// insideProject reports whether the directory is in the project, from its module root on,
+// which can't be copied into itself
+func insideProject(workingDir, dir string) bool {
+	root := workingDir
+	for d := workingDir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			root = d
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Clean clears up the workspace, unless it is kept, a dry run has none
+func (b *Build) Clean() error {
+	if b.dryRun != "" {
+		return nil
+	}
+	if b.lock != nil {
+		defer b.lock.Close()
+	}
+	if b.Workspace.Keep || viper.GetBool("debug") {
+		log.Infof("Workspace kept in %s", b.TmpDir)
+		return ioutil.WriteFile(filepath.Join(b.TmpDir, workspaceKeepFile), nil, 0644)
+	}
+	return os.RemoveAll(b.TmpDir)
+}
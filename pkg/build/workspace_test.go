@@ -0,0 +1,132 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package build
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveStaleWorkspaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-workspaces")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mkdir := func(name string) string {
+		ws := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(ws, 0755))
+		return ws
+	}
+	stale := mkdir("goc-build-a-1")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(stale, workspaceLockFile), nil, 0644))
+	kept := mkdir("goc-build-a-2")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(kept, workspaceLockFile), nil, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(kept, workspaceKeepFile), nil, 0644))
+	inUse := mkdir("goc-build-a-3")
+	lock, err := lockWorkspace(inUse)
+	assert.NoError(t, err)
+	defer lock.Close()
+	older := mkdir("goc-build-a")
+
+	removeStaleWorkspaces(dir)
+	for ws, exists := range map[string]bool{stale: false, kept: true, inUse: true, older: true} {
+		_, err := os.Stat(ws)
+		assert.Equal(t, exists, err == nil, ws)
+	}
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-workspaces")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	workingDir := filepath.Join(baseDir, "../../tests/samples/simple_project")
+
+	// a fixed directory is reused once the build using it is over
+	ws := filepath.Join(dir, "ws")
+	b := &Build{WorkingDir: workingDir, Workspace: Workspace{Dir: ws, Keep: true}}
+	assert.NoError(t, b.createWorkspace())
+	assert.Equal(t, ws, b.TmpDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(ws, "main.go"), nil, 0644))
+	err = (&Build{WorkingDir: workingDir, Workspace: Workspace{Dir: ws}}).createWorkspace()
+	assert.True(t, errors.Is(err, ErrWorkDirInUse))
+	assert.NoError(t, b.Clean())
+	_, err = os.Stat(filepath.Join(ws, workspaceKeepFile))
+	assert.NoError(t, err)
+
+	b = &Build{WorkingDir: workingDir, Workspace: Workspace{Dir: ws}}
+	assert.NoError(t, b.createWorkspace())
+	_, err = os.Stat(filepath.Join(ws, "main.go"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, b.Clean())
+	_, err = os.Stat(ws)
+	assert.True(t, os.IsNotExist(err))
+
+	// neither the user's files nor the project
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+	err = (&Build{WorkingDir: workingDir, Workspace: Workspace{Dir: dir}}).createWorkspace()
+	assert.True(t, errors.Is(err, ErrInvalidWorkDir))
+	err = (&Build{WorkingDir: workingDir, Workspace: Workspace{Dir: filepath.Join(workingDir, "ws")}}).createWorkspace()
+	assert.True(t, errors.Is(err, ErrInvalidWorkDir))
+
+	// or a slot of the checkout, the same one once the build using it is over
+	b = &Build{WorkingDir: workingDir}
+	assert.NoError(t, b.createWorkspace())
+	defer b.Clean()
+	assert.Contains(t, filepath.Base(b.TmpDir), tmpFolderName(workingDir)+"-")
+	other := &Build{WorkingDir: workingDir}
+	assert.NoError(t, other.createWorkspace())
+	assert.NotEqual(t, b.TmpDir, other.TmpDir)
+	slot := other.TmpDir
+	assert.NoError(t, other.Clean())
+	other = &Build{WorkingDir: workingDir}
+	assert.NoError(t, other.createWorkspace())
+	assert.Equal(t, slot, other.TmpDir)
+	assert.NoError(t, other.Clean())
+}
+
+func TestWorkspaceSlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-workspaces")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	slot, lock, err := workspaceSlot(dir, "goc-build-a")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "goc-build-a-0"), slot)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(slot, "main.go"), nil, 0644))
+
+	// held
+	next, nextLock, err := workspaceSlot(dir, "goc-build-a")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "goc-build-a-1"), next)
+	nextLock.Close()
+
+	// left by a build which crashed, emptied
+	lock.Close()
+	again, lock, err := workspaceSlot(dir, "goc-build-a")
+	assert.NoError(t, err)
+	defer lock.Close()
+	assert.Equal(t, slot, again)
+	_, err = os.Stat(filepath.Join(slot, "main.go"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(slot, workspaceLockFile))
+	assert.NoError(t, err)
+}
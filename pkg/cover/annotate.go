@@ -0,0 +1,219 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+)
+
+// InstrumentError is the failure to instrument a file
+type InstrumentError struct {
+	Package string // import path
+	File    string // file name in the package
+	Err     error  // tells the position for syntax errors
+}
+
+func (e *InstrumentError) Error() string {
+	return fmt.Sprintf("package %s, file %s: %v", e.Package, e.File, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *InstrumentError) Unwrap() error {
+	return e.Err
+}
+
+// InstrumentErrors gathers the failures of all the files which could not be instrumented
+type InstrumentErrors []*InstrumentError
+
+func (e InstrumentErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed to instrument %d files:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// InstrumentStats tells how long the instrumentation took
+type InstrumentStats struct {
+	Packages int
+	Files    int
+	Workers  int
+	Cached   int // files got from the instrument cache
+	Duration time.Duration
+	Slowest  string        // the file which took the longest
+	SlowestD time.Duration // and how long it took
+}
+
+func (s *InstrumentStats) String() string {
+	return fmt.Sprintf("instrumented %d files (%d cached) of %d packages in %v with %d workers, slowest %s took %v",
+		s.Files, s.Cached, s.Packages, s.Duration, s.Workers, s.Slowest, s.SlowestD)
+}
+
+// annotateJob is a file to annotate and its outcome
+type annotateJob struct {
+	pkg      *Package
+	file     string
+	coverVar *FileVar
+	decl     string
+	cached   bool
+	duration time.Duration
+	err      error
+}
+
+// annotatePackages annotates the files of the packages with at most parallel
+// files at a time, GOMAXPROCS if parallel <= 0. It returns the declarations of
+// the cover variables, in a stable order, or the errors of all the failed files.
+// Files are taken from the cache when possible, the cache may be nil.
+func annotatePackages(ctx context.Context, covers map[string]*PackageCover, mode, globalCoverVarImportPath string, parallel int, cache *InstrumentCache) (string, *InstrumentStats, error) {
+	importPaths := make([]string, 0, len(covers))
+	for importPath := range covers {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	var jobs []*annotateJob
+	for _, importPath := range importPaths {
+		pc := covers[importPath]
+		files := make([]string, 0, len(pc.Vars))
+		for file := range pc.Vars {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			jobs = append(jobs, &annotateJob{pkg: pc.Package, file: file, coverVar: pc.Vars[file]})
+		}
+	}
+
+	if parallel <= 0 {
+		parallel = runtime.GOMAXPROCS(0)
+	}
+	stats := &InstrumentStats{Packages: len(covers), Files: len(jobs), Workers: parallel}
+	start := time.Now()
+
+	queue := make(chan *annotateJob)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if job.err = ctx.Err(); job.err != nil {
+					continue
+				}
+				begin := time.Now()
+				job.decl, job.coverVar.SHA256, job.cached, job.err = annotateFile(cache, path.Join(job.pkg.Dir, job.file), mode, job.coverVar.Var, globalCoverVarImportPath)
+				job.coverVar.Blocks = declBlocks(job.decl)
+				job.duration = time.Since(begin)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	stats.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+
+	var (
+		decl strings.Builder
+		errs InstrumentErrors
+	)
+	for _, job := range jobs {
+		if job.duration > stats.SlowestD {
+			stats.Slowest, stats.SlowestD = job.coverVar.File, job.duration
+		}
+		if job.cached {
+			stats.Cached++
+		}
+		if job.err != nil {
+			errs = append(errs, &InstrumentError{Package: job.pkg.ImportPath, File: job.file, Err: job.err})
+			continue
+		}
+		decl.WriteString("\n" + job.decl + "\n")
+	}
+	if len(errs) > 0 {
+		return "", stats, errs
+	}
+	return decl.String(), stats, nil
+}
+
+// annotateFile annotates the file in place, or copies the annotated file from the cache.
+// It returns the declarations of the counters, the SHA256 of the source and whether they
+// come from the cache.
+func annotateFile(cache *InstrumentCache, name, mode, varVar, globalCoverVarImportPath string) (string, string, bool, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", "", false, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if cache == nil {
+		decl, err := tool.Annotate(name, mode, varVar, globalCoverVarImportPath)
+		return decl, hash, false, err
+	}
+
+	// the annotated file starts with a line directive naming it, it is left out of
+	// the cache so that entries can be shared by the copies of a project
+	directive := []byte(fmt.Sprintf("//line %s:1\n", name))
+	key := cache.key(content, mode, varVar, globalCoverVarImportPath)
+	if src, decl, ok := cache.get(key); ok {
+		return decl, hash, true, ioutil.WriteFile(name, append(directive, src...), 0644)
+	}
+
+	decl, err := tool.Annotate(name, mode, varVar, globalCoverVarImportPath)
+	if err != nil {
+		return "", "", false, err
+	}
+	src, err := ioutil.ReadFile(name)
+	if err == nil {
+		err = cache.put(key, bytes.TrimPrefix(src, directive), decl)
+	}
+	if err != nil {
+		log.Warnf("failed to cache the instrumented %s: %v", name, err)
+	}
+	return decl, hash, false, nil
+}
+
+var declBlocksRe = regexp.MustCompile(`\n\tCount +\[(\d+)\]uint32\n`)
+
+// declBlocks returns the number of blocks the declaration of the counters of a file has
+func declBlocks(decl string) int {
+	m := declBlocksRe.FindStringSubmatch(decl)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
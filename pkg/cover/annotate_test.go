@@ -0,0 +1,89 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeAnnotateTestPackage(t *testing.T, files map[string]string) *Package {
+	dir, err := ioutil.TempDir("", "goc-annotate")
+	assert.NoError(t, err)
+	pkg := &Package{Dir: dir, ImportPath: "example.com/a", Name: "a"}
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		pkg.GoFiles = append(pkg.GoFiles, name)
+	}
+	sort.Strings(pkg.GoFiles)
+	return pkg
+}
+
+func TestAnnotatePackagesConcurrently(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("f%02d.go", i)] = fmt.Sprintf("package a\n\nfunc F%d(x int) int {\n\tif x > 0 {\n\t\treturn x\n\t}\n\treturn 0\n}\n", i)
+	}
+	pkg := writeAnnotateTestPackage(t, files)
+	defer os.RemoveAll(pkg.Dir)
+
+	pc := newPackageCover(pkg)
+	decl, stats, err := annotatePackages(context.Background(), map[string]*PackageCover{pkg.ImportPath: pc}, "count", "example.com/a/globalcover", 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, stats.Files)
+	assert.Equal(t, 4, stats.Workers)
+
+	// the declarations come in the order of the files, whatever the worker finishing first
+	last := -1
+	for _, name := range pkg.GoFiles {
+		i := strings.Index(decl, pc.Vars[name].Var+" = struct")
+		assert.True(t, i > last, "declaration of %s out of order", name)
+		last = i
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(pkg.Dir, "f07.go"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), pc.Vars["f07.go"].Var+".Count[0]++")
+}
+
+func TestAnnotatePackagesErrors(t *testing.T) {
+	pkg := writeAnnotateTestPackage(t, map[string]string{
+		"ok.go":     "package a\n\nfunc A() {}\n",
+		"broken.go": "package a\n\nfunc B() {\n",
+		"worse.go":  "package a\n\nfunc C( {}\n",
+	})
+	defer os.RemoveAll(pkg.Dir)
+
+	_, _, err := annotatePackages(context.Background(), map[string]*PackageCover{pkg.ImportPath: newPackageCover(pkg)}, "count", "example.com/a/globalcover", 0, nil)
+	var errs InstrumentErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "example.com/a", errs[0].Package)
+	assert.Equal(t, "broken.go", errs[0].File)
+	assert.Equal(t, "worse.go", errs[1].File)
+	// the syntax error tells where it is
+	assert.Contains(t, err.Error(), "broken.go:3:12")
+}
@@ -0,0 +1,273 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIResponse is the envelope of the /v2 responses, Data is set on success and Error on failure.
+// A clear or remove failing on some of the services carries both.
+type APIResponse struct {
+	Data  interface{} `json:"data"`
+	Page  *APIPage    `json:"page,omitempty"`
+	Error *APIError   `json:"error,omitempty"`
+}
+
+// APIError is the error of a failed /v2 request
+type APIError struct {
+	Code    string `json:"code"` // invalid_argument, not_found, upstream_failed or internal
+	Message string `json:"message"`
+}
+
+// APIPage tells the part of the services a /v2 list response carries
+type APIPage struct {
+	Offset int  `json:"offset"`
+	Limit  int  `json:"limit"`
+	Total  int  `json:"total"`
+	Next   *int `json:"next,omitempty"` // offset of the next page, none on the last one
+}
+
+// ServiceResult is the result of a clear or remove call on one of the selected services
+type ServiceResult struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	Result  string `json:"result"` // cleared, removed or failed
+	Error   string `json:"error,omitempty"`
+
+	message string // the answer of the agent
+}
+
+// ProfileResult is the data of a /v2 profile response
+type ProfileResult struct {
+	Profile    string   `json:"profile"`
+	Incoherent []string `json:"incoherent,omitempty"` // addresses of the services built from other sources, left out
+}
+
+// ListParam is param of the /v2 list API
+type ListParam struct {
+	Offset int `form:"offset"`
+	Limit  int `form:"limit"`
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// failV2 answers the request with the error, in the envelope
+func failV2(c *gin.Context, err error) {
+	status := statusOf(err)
+	c.JSON(status, APIResponse{Error: &APIError{Code: errorCode(status), Message: err.Error()}})
+}
+
+func errorCode(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status == http.StatusBadGateway:
+		return "upstream_failed"
+	case status >= http.StatusInternalServerError:
+		return "internal"
+	}
+	return "invalid_argument"
+}
+
+func (s *server) openAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(openAPIDocument))
+}
+
+// list API examples:
+// GET /v2/cover/list?offset=100&limit=100
+// lists the services sorted by name
+func (s *server) listServicesV2(c *gin.Context) {
+	var param ListParam
+	if err := c.ShouldBindQuery(&param); err != nil {
+		failV2(c, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	if param.Offset < 0 || param.Limit < 0 {
+		failV2(c, withStatus(http.StatusBadRequest, errors.New("offset and limit must not be negative")))
+		return
+	}
+	limit := param.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	} else if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	all := s.allServices(true)
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := make([]Service, 0, limit)
+	for i := param.Offset; i < len(names) && i < param.Offset+limit; i++ {
+		services = append(services, Service{Name: names[i], Addresses: all[names[i]]})
+	}
+	page := &APIPage{Offset: param.Offset, Limit: limit, Total: len(names)}
+	if next := param.Offset + limit; next < len(names) {
+		page.Next = &next
+	}
+	c.JSON(http.StatusOK, APIResponse{Data: services, Page: page})
+}
+
+// registerServiceV2 answers 201 with the service if the address is new, 200 otherwise
+func (s *server) registerServiceV2(c *gin.Context) {
+	var service ServiceUnderTest
+	if err := c.ShouldBind(&service); err != nil {
+		failV2(c, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	service, created, err := s.register(service, c.ClientIP())
+	if err != nil {
+		failV2(c, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, APIResponse{Data: Service{Name: service.Name, Addresses: s.Store.Get(service.Name)}})
+}
+
+// profileV2 answers the merged profile as text in a ProfileResult, or every group with ProfileParam.Groups
+func (s *server) profileV2(c *gin.Context) {
+	var body ProfileParam
+	if err := c.ShouldBind(&body); err != nil {
+		failV2(c, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	body.Uploads = true
+	groups, err := s.profileGroups(body)
+	if err == nil {
+		err = dumpGroups(groups)
+	}
+	if err != nil {
+		failV2(c, err)
+		return
+	}
+	if body.Groups {
+		c.JSON(http.StatusOK, APIResponse{Data: groups})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Data: ProfileResult{Profile: groups[0].Profile, Incoherent: incoherentAddresses(groups)}})
+}
+
+// clearV2 answers the result of every selected service, 502 if any failed.
+// Unknown services are not found unless ProfileParam.Force.
+func (s *server) clearV2(c *gin.Context) {
+	infos, ok := s.selectServicesV2(c)
+	if !ok {
+		return
+	}
+	respondResults(c, s.clearServices(infos), http.StatusBadGateway)
+}
+
+// removeServicesV2 answers the result of every selected service, 500 if any failed.
+// Unknown services are not found unless ProfileParam.Force.
+func (s *server) removeServicesV2(c *gin.Context) {
+	infos, ok := s.selectServicesV2(c)
+	if !ok {
+		return
+	}
+	respondResults(c, s.removeAddresses(infos), http.StatusInternalServerError)
+}
+
+// selectServicesV2 returns the services the request selects, named, false if it has been answered
+func (s *server) selectServicesV2(c *gin.Context) ([]ServiceUnderTest, bool) {
+	var body ProfileParam
+	if err := c.ShouldBind(&body); err != nil {
+		failV2(c, withStatus(http.StatusBadRequest, err))
+		return nil, false
+	}
+	all := s.allServices(true)
+	infos, err := filterAddrInfo(body.Service, body.Address, body.Force, all)
+	if err != nil {
+		failV2(c, err)
+		return nil, false
+	}
+	for i := range infos {
+		if infos[i].Name != "" {
+			continue
+		}
+		for name, addrs := range all {
+			if contains(addrs, infos[i].Address) {
+				infos[i].Name = name
+				break
+			}
+		}
+	}
+	return infos, true
+}
+
+// respondResults answers the results of the services, with status if any of them failed
+func respondResults(c *gin.Context, results []ServiceResult, status int) {
+	var failed int
+	for _, r := range results {
+		if r.Result == "failed" {
+			failed++
+		}
+	}
+	if failed == 0 {
+		c.JSON(http.StatusOK, APIResponse{Data: results})
+		return
+	}
+	c.JSON(status, APIResponse{
+		Data:  results,
+		Error: &APIError{Code: errorCode(status), Message: fmt.Sprintf("%d of %d services failed", failed, len(results))},
+	})
+}
+
+func (s *server) initSystemV2(c *gin.Context) {
+	if err := s.reset(); err != nil {
+		failV2(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{})
+}
+
+func (s *server) hotV2(c *gin.Context) {
+	var body HotParam
+	if err := c.ShouldBind(&body); err != nil {
+		failV2(c, withStatus(http.StatusBadRequest, err))
+		return
+	}
+	report, err := s.hotReport(body)
+	if err != nil {
+		failV2(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Data: report})
+}
+
+func (s *server) uploadV2(c *gin.Context) {
+	result, err := s.addUpload(c)
+	if err != nil {
+		failV2(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, APIResponse{Data: result})
+}
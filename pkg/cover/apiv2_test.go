@@ -0,0 +1,230 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// callV2 sends the json body to the api of the center, and decodes the envelope of the response into data
+func callV2(t *testing.T, ts *httptest.Server, method, api string, body interface{}, data interface{}) (int, APIResponse) {
+	var r *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		r = bytes.NewReader(b)
+	} else {
+		r = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, ts.URL+api, r)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	envelope := APIResponse{Data: data}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&envelope))
+	return res.StatusCode, envelope
+}
+
+func TestAPIV2(t *testing.T) {
+	ts := httptest.NewServer(NewMemoryBasedServer().Route(ioutil.Discard))
+	defer ts.Close()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mode: count\nexample.com/app/main.go:30.13,48.33 13 1\n"))
+	}))
+	defer agent.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// no profiles
+	status, res := callV2(t, ts, "POST", "/v2/cover/profile", ProfileParam{}, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, &APIError{Code: "not_found", Message: "no profiles"}, res.Error)
+
+	status, res = callV2(t, ts, "POST", "/v2/cover/register", ServiceUnderTest{Name: "app", Address: "ftp://127.0.0.1"}, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_argument", res.Error.Code)
+
+	var service Service
+	status, _ = callV2(t, ts, "POST", "/v2/cover/register", ServiceUnderTest{Name: "app", Address: agent.URL}, &service)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, Service{Name: "app", Addresses: []string{agent.URL}}, service)
+	status, _ = callV2(t, ts, "POST", "/v2/cover/register", ServiceUnderTest{Name: "app", Address: agent.URL}, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = callV2(t, ts, "POST", "/v2/cover/register", ServiceUnderTest{Name: "down", Address: down.URL}, nil)
+	assert.Equal(t, http.StatusCreated, status)
+
+	// an unreachable service fails the profile unless forced
+	status, res = callV2(t, ts, "POST", "/v2/cover/profile", ProfileParam{}, nil)
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Equal(t, "upstream_failed", res.Error.Code)
+	var profile ProfileResult
+	status, _ = callV2(t, ts, "POST", "/v2/cover/profile", ProfileParam{Force: true}, &profile)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "mode: count\nexample.com/app/main.go:30.13,48.33 13 1\n", profile.Profile)
+	var groups []*ProfileGroup
+	status, _ = callV2(t, ts, "POST", "/v2/cover/profile", ProfileParam{Force: true, Groups: true}, &groups)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, groups, 1)
+
+	// clear and remove answer the result of every service
+	status, res = callV2(t, ts, "POST", "/v2/cover/clear", ProfileParam{Service: []string{"unknown"}}, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "service [unknown] not found", res.Error.Message)
+	var results []ServiceResult
+	status, res = callV2(t, ts, "POST", "/v2/cover/clear", ProfileParam{}, &results)
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Equal(t, "1 of 2 services failed", res.Error.Message)
+	assert.Len(t, results, 2)
+	for _, r := range results {
+		if r.Name == "app" {
+			assert.Equal(t, ServiceResult{Name: "app", Address: agent.URL, Result: "cleared"}, r)
+		} else {
+			assert.Equal(t, "failed", r.Result)
+			assert.NotEmpty(t, r.Error)
+		}
+	}
+	results = nil
+	status, res = callV2(t, ts, "POST", "/v2/cover/remove", ProfileParam{Address: []string{down.URL}}, &results)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, res.Error)
+	assert.Equal(t, []ServiceResult{{Name: "down", Address: down.URL, Result: "removed"}}, results)
+
+	status, res = callV2(t, ts, "POST", "/v2/cover/init", nil, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, res.Error)
+	var services []Service
+	status, res = callV2(t, ts, "GET", "/v2/cover/list", nil, &services)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, services)
+	assert.Equal(t, &APIPage{Limit: defaultListLimit}, res.Page)
+}
+
+func TestAPIV2Upload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-uploads")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := NewMemoryBasedServer()
+	ts := httptest.NewServer(server.Route(ioutil.Discard))
+	defer ts.Close()
+
+	upload := func(name, data string) (int, APIResponse) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "a.cov")
+		part.Write([]byte(data))
+		mw.Close()
+		res, err := http.Post(ts.URL+"/v2/cover/upload?name="+name, mw.FormDataContentType(), &body)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		var envelope APIResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&envelope))
+		return res.StatusCode, envelope
+	}
+	profile := "mode: count\nexample.com/app/main.go:30.13,48.33 13 1\n"
+
+	status, _ := upload("e2e", profile)
+	assert.Equal(t, http.StatusCreated, status)
+	// the faults of the request
+	status, res := upload("", profile)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_argument", res.Error.Code)
+	status, _ = upload("e2e", "garbage")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = upload("e2e", "mode: count\nexample.com/app/main.go:30.13,48.34 13 1\n")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// and the ones of the center
+	server.uploads.dir = filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(server.uploads.dir, nil, 0644))
+	status, res = upload("other", profile)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "internal", res.Error.Code)
+}
+
+func TestAPIV2ListPages(t *testing.T) {
+	server := NewMemoryBasedServer()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: fmt.Sprintf("service%d", i), Address: fmt.Sprintf("http://127.0.0.1:%d", 8000+i)}))
+	}
+	ts := httptest.NewServer(server.Route(ioutil.Discard))
+	defer ts.Close()
+
+	var names []string
+	for offset := 0; ; {
+		var services []Service
+		status, res := callV2(t, ts, "GET", fmt.Sprintf("/v2/cover/list?offset=%d&limit=2", offset), nil, &services)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 5, res.Page.Total)
+		assert.True(t, len(services) <= 2)
+		for _, s := range services {
+			names = append(names, s.Name)
+		}
+		if res.Page.Next == nil {
+			break
+		}
+		offset = *res.Page.Next
+	}
+	assert.Equal(t, []string{"service0", "service1", "service2", "service3", "service4"}, names)
+
+	status, res := callV2(t, ts, "GET", "/v2/cover/list?offset=-1", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_argument", res.Error.Code)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	var doc struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(openAPIDocument), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	// every v2 route is documented
+	routes := NewMemoryBasedServer().Route(ioutil.Discard).Routes()
+	var documented int
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, "/v2/") {
+			continue
+		}
+		documented++
+		assert.Contains(t, doc.Paths[r.Path], strings.ToLower(r.Method), r.Path)
+	}
+	assert.Equal(t, 9, documented)
+
+	ts := httptest.NewServer(NewMemoryBasedServer().Route(ioutil.Discard))
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/v2/openapi.json")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "application/json")
+}
@@ -0,0 +1,237 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// BranchSectionHeader starts the branch coverage section appended to a profile
+// built with --mode=branch. Tools that only know the classic format must cut
+// the section off before parsing, see SplitBranchSection.
+const BranchSectionHeader = "# goc:branch"
+
+// BranchBlock is one arm of a branch point and how many times it was taken
+type BranchBlock struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Kind      string // if, for, cond, switch, typeswitch or select
+	Arm       string // T or F for conditions, the clause index or default for switches
+	Count     int
+}
+
+// BranchProfile holds the branch arms of one file
+type BranchProfile struct {
+	FileName string
+	Blocks   []BranchBlock
+}
+
+// Covered returns the number of arms taken at least once and the number of all arms
+func (p *BranchProfile) Covered() (covered, total int) {
+	for _, b := range p.Blocks {
+		if b.Count > 0 {
+			covered++
+		}
+	}
+	return covered, len(p.Blocks)
+}
+
+var branchLineRe = regexp.MustCompile(`^(.+):([0-9]+)\.([0-9]+),([0-9]+)\.([0-9]+) (\S+) (\S+) ([0-9]+)$`)
+
+// SplitBranchSection splits a profile into the classic part and the branch section, if any
+func SplitBranchSection(data []byte) (profile, branches []byte) {
+	header := []byte(BranchSectionHeader + "\n")
+	if bytes.HasPrefix(data, header) {
+		return nil, data
+	}
+	if i := bytes.Index(data, append([]byte("\n"), header...)); i >= 0 {
+		return data[:i+1], data[i+1:]
+	}
+	return data, nil
+}
+
+// ParseProfileWithBranches parses a profile which may carry a branch section
+func ParseProfileWithBranches(data []byte) ([]*cover.Profile, []*BranchProfile, error) {
+	profile, section := SplitBranchSection(data)
+	profiles, err := cover.ParseProfilesFromReader(bytes.NewReader(profile))
+	if err != nil || section == nil {
+		return profiles, nil, err
+	}
+	branches, err := ParseBranchProfiles(bytes.NewReader(section))
+	return profiles, branches, err
+}
+
+// ParseBranchProfiles parses a branch section, the files come back sorted by name
+func ParseBranchProfiles(r io.Reader) ([]*BranchProfile, error) {
+	files := make(map[string]*BranchProfile)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line == BranchSectionHeader {
+			continue
+		}
+		m := branchLineRe.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %q doesn't match the branch format", line)
+		}
+		p := files[m[1]]
+		if p == nil {
+			p = &BranchProfile{FileName: m[1]}
+			files[m[1]] = p
+		}
+		p.Blocks = append(p.Blocks, BranchBlock{
+			StartLine: atoi(m[2]),
+			StartCol:  atoi(m[3]),
+			EndLine:   atoi(m[4]),
+			EndCol:    atoi(m[5]),
+			Kind:      m[6],
+			Arm:       m[7],
+			Count:     atoi(m[8]),
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*BranchProfile, 0, len(files))
+	for _, p := range files {
+		profiles = append(profiles, p)
+	}
+	return MergeBranchProfiles(profiles), nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// MergeBranchProfiles sums the counts of the same arms, arms are sorted by position
+func MergeBranchProfiles(profiles ...[]*BranchProfile) []*BranchProfile {
+	type key struct {
+		startLine, startCol, endLine, endCol int
+		kind, arm                            string
+	}
+	files := make(map[string]map[key]int)
+	for _, ps := range profiles {
+		for _, p := range ps {
+			arms := files[p.FileName]
+			if arms == nil {
+				arms = make(map[key]int)
+				files[p.FileName] = arms
+			}
+			for _, b := range p.Blocks {
+				arms[key{b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.Kind, b.Arm}] += b.Count
+			}
+		}
+	}
+
+	merged := make([]*BranchProfile, 0, len(files))
+	for name, arms := range files {
+		p := &BranchProfile{FileName: name}
+		for k, count := range arms {
+			p.Blocks = append(p.Blocks, BranchBlock{k.startLine, k.startCol, k.endLine, k.endCol, k.kind, k.arm, count})
+		}
+		sort.Slice(p.Blocks, func(i, j int) bool {
+			a, b := p.Blocks[i], p.Blocks[j]
+			if a.StartLine != b.StartLine {
+				return a.StartLine < b.StartLine
+			}
+			if a.StartCol != b.StartCol {
+				return a.StartCol < b.StartCol
+			}
+			if a.EndLine != b.EndLine {
+				return a.EndLine < b.EndLine
+			}
+			if a.EndCol != b.EndCol {
+				return a.EndCol < b.EndCol
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			return armLess(a.Arm, b.Arm)
+		})
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].FileName < merged[j].FileName })
+	return merged
+}
+
+// armLess orders T before F and switch clauses by index, the implicit default last
+func armLess(a, b string) bool {
+	rank := func(arm string) int {
+		switch arm {
+		case "T":
+			return -2
+		case "F":
+			return -1
+		case "default":
+			return 1 << 30
+		}
+		return atoi(arm)
+	}
+	return rank(a) < rank(b)
+}
+
+// WriteBranchProfiles writes the branch section, header included
+func WriteBranchProfiles(w io.Writer, profiles []*BranchProfile) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, BranchSectionHeader)
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			fmt.Fprintf(bw, "%s:%d.%d,%d.%d %s %s %d\n", p.FileName, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.Kind, b.Arm, b.Count)
+		}
+	}
+	return bw.Flush()
+}
+
+// filterBranchProfiles keeps the files matching any of the patterns, or not matching any if skip
+func filterBranchProfiles(patterns []string, skip bool, profiles []*BranchProfile) ([]*BranchProfile, error) {
+	var out = make([]*BranchProfile, 0)
+	for _, p := range profiles {
+		matched := false
+		for _, pattern := range patterns {
+			ok, err := regexp.MatchString(pattern, p.FileName)
+			if err != nil {
+				return nil, fmt.Errorf("filterBranchProfiles failed with pattern %s for profile %s, err: %v", pattern, p.FileName, err)
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if matched != skip {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// isBranchSection reports whether a response body is a branch section
+func isBranchSection(body []byte) bool {
+	return strings.HasPrefix(string(body), BranchSectionHeader)
+}
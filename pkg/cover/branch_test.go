@@ -0,0 +1,149 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const branchProfile = `mode: atomic
+a/a.go:3.10,5.2 2 1
+a/a.go:5.2,7.3 1 0
+# goc:branch
+a/a.go:3.5,3.10 if T 1
+a/a.go:3.5,3.10 if F 0
+a/a.go:8.2,8.10 switch 0 2
+a/a.go:8.2,8.10 switch default 0
+`
+
+func TestSplitBranchSection(t *testing.T) {
+	profile, section := SplitBranchSection([]byte(branchProfile))
+	assert.Equal(t, "mode: atomic\na/a.go:3.10,5.2 2 1\na/a.go:5.2,7.3 1 0\n", string(profile))
+	assert.True(t, isBranchSection(section))
+
+	profile, section = SplitBranchSection([]byte("mode: set\na/a.go:1.1,2.2 1 1\n"))
+	assert.Equal(t, "mode: set\na/a.go:1.1,2.2 1 1\n", string(profile))
+	assert.Nil(t, section)
+}
+
+func TestParseProfileWithBranches(t *testing.T) {
+	profiles, branches, err := ParseProfileWithBranches([]byte(branchProfile))
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Len(t, branches, 1)
+	assert.Equal(t, "a/a.go", branches[0].FileName)
+	assert.Len(t, branches[0].Blocks, 4)
+	assert.Equal(t, BranchBlock{3, 5, 3, 10, "if", "T", 1}, branches[0].Blocks[0])
+	assert.Equal(t, "default", branches[0].Blocks[3].Arm)
+
+	covered, total := branches[0].Covered()
+	assert.Equal(t, 2, covered)
+	assert.Equal(t, 4, total)
+
+	_, _, err = ParseProfileWithBranches([]byte("mode: set\n# goc:branch\nbroken line\n"))
+	assert.Error(t, err)
+}
+
+func TestMergeBranchProfiles(t *testing.T) {
+	_, a, err := ParseProfileWithBranches([]byte(branchProfile))
+	assert.NoError(t, err)
+	b, err := ParseBranchProfiles(strings.NewReader("# goc:branch\na/a.go:3.5,3.10 if F 3\nb/b.go:1.1,1.5 for T 1\n"))
+	assert.NoError(t, err)
+
+	merged := MergeBranchProfiles(a, b)
+	var buf bytes.Buffer
+	assert.NoError(t, WriteBranchProfiles(&buf, merged))
+	assert.Equal(t, `# goc:branch
+a/a.go:3.5,3.10 if T 1
+a/a.go:3.5,3.10 if F 3
+a/a.go:8.2,8.10 switch 0 2
+a/a.go:8.2,8.10 switch default 0
+b/b.go:1.1,1.5 for T 1
+`, buf.String())
+}
+
+func TestCovListWithBranches(t *testing.T) {
+	covList, err := CovList(strings.NewReader(branchProfile))
+	assert.NoError(t, err)
+	assert.Len(t, covList, 1)
+	assert.Equal(t, "66.7%", covList[0].Percentage())
+	assert.Equal(t, "50.0%", covList[0].BranchPercentage())
+	assert.True(t, covList.HasBranches())
+	assert.Equal(t, "50.0%", covList.TotalBranchPercentage())
+
+	covList, err = CovList(strings.NewReader("mode: set\na/a.go:1.1,2.2 1 1\n"))
+	assert.NoError(t, err)
+	assert.False(t, covList.HasBranches())
+	assert.Equal(t, "N/A", covList.TotalBranchPercentage())
+}
+
+func TestDeltaCovWithBranches(t *testing.T) {
+	base := CoverageList{{FileName: "a/a.go", NCoveredStmts: 1, NAllStmts: 2, NCoveredBranches: 1, NAllBranches: 4}}
+	local := CoverageList{{FileName: "a/a.go", NCoveredStmts: 1, NAllStmts: 2, NCoveredBranches: 3, NAllBranches: 4}}
+
+	// the statement coverage is the same, the branch coverage is not
+	d := GetDeltaCov(local, base)
+	assert.Equal(t, DeltaCovList{{FileName: "a/a.go", BasePer: "50.0%", NewPer: "50.0%", DeltaPer: "0.0%", BaseBranchPer: "25.0%", NewBranchPer: "75.0%"}}, d)
+
+	assert.Empty(t, GetDeltaCov(local, local))
+}
+
+func TestProfileWithBranches(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case CoverProfileAPI:
+			w.Write([]byte("mode: atomic\na/a.go:3.10,5.2 2 1\n"))
+		case CoverBranchAPI:
+			w.Write([]byte("# goc:branch\na/a.go:3.5,3.10 if T 1\na/a.go:3.5,3.10 if F 0\n"))
+		}
+	}))
+	defer agent.Close()
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != CoverProfileAPI {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("mode: atomic\nb/b.go:1.1,2.2 1 1\n"))
+	}))
+	defer old.Close()
+
+	server := &server{Store: NewMemoryStore()}
+	assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "a", Address: agent.URL}))
+	assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "b", Address: old.URL}))
+	router := server.Route(os.Stdout)
+
+	// services not built with --mode=branch contribute no branches
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/cover/profile?force=true&branch=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mode: atomic\na/a.go:3.10,5.2 2 1\nb/b.go:1.1,2.2 1 1\n# goc:branch\na/a.go:3.5,3.10 if T 1\na/a.go:3.5,3.10 if F 0\n", w.Body.String())
+
+	// without asking, the profile stays in the classic format
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/cover/profile?force=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mode: atomic\na/a.go:3.10,5.2 2 1\nb/b.go:1.1,2.2 1 1\n", w.Body.String())
+}
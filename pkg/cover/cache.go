@@ -0,0 +1,188 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cacheTrimInterval is how often unused entries are looked for
+	cacheTrimInterval = 24 * time.Hour
+	// cacheTrimLimit is how long an entry is kept without being used
+	cacheTrimLimit = 5 * 24 * time.Hour
+	// cacheTouchInterval avoids updating the mtime of an entry on every use
+	cacheTouchInterval = time.Hour
+)
+
+// InstrumentCache keeps the annotated files and the declarations of their counters across builds.
+// Entries are keyed on the source file and everything else the annotation depends on,
+// so unchanged files are not annotated again and come out byte for byte the same,
+// which lets the go command reuse its own build cache too.
+type InstrumentCache struct {
+	Dir string
+}
+
+// DefaultCacheDir returns $GOC_CACHE, or goc/instrument in the user cache directory.
+// GOC_CACHE=off disables the cache, as an empty result does.
+func DefaultCacheDir() string {
+	if dir := os.Getenv("GOC_CACHE"); dir != "" {
+		if dir == "off" {
+			return ""
+		}
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goc", "instrument")
+}
+
+// NewInstrumentCache opens the cache in dir, nil if dir is empty or can't be used
+func NewInstrumentCache(dir string) *InstrumentCache {
+	if dir == "" || gocBinaryID() == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("instrument cache disabled, failed to create %s: %v", dir, err)
+		return nil
+	}
+	c := &InstrumentCache{Dir: dir}
+	c.trim()
+	return c
+}
+
+// key hashes what the annotated file depends on: the source, the cover mode
+// and variable, the import path of the global cover variables and goc itself
+func (c *InstrumentCache) key(content []byte, mode, varVar, globalCoverVarImportPath string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "goc %s\nmode %s\nvar %s\nimport %s\n", gocBinaryID(), mode, varVar, globalCoverVarImportPath)
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *InstrumentCache) path(key, suffix string) string {
+	return filepath.Join(c.Dir, key[:2], key+suffix)
+}
+
+// get returns the annotated file and its declarations
+func (c *InstrumentCache) get(key string) ([]byte, string, bool) {
+	src, err := ioutil.ReadFile(c.path(key, "-src"))
+	if err != nil {
+		return nil, "", false
+	}
+	decl, err := ioutil.ReadFile(c.path(key, "-decl"))
+	if err != nil {
+		return nil, "", false
+	}
+	if info, err := os.Stat(c.path(key, "-decl")); err == nil && time.Since(info.ModTime()) > cacheTouchInterval {
+		now := time.Now()
+		os.Chtimes(c.path(key, "-decl"), now, now)
+	}
+	return src, string(decl), true
+}
+
+// put stores the annotated file and its declarations, the declarations go last
+// so an entry is only seen once complete
+func (c *InstrumentCache) put(key string, src []byte, decl string) error {
+	if err := os.MkdirAll(filepath.Dir(c.path(key, "")), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path(key, "-src"), src); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(key, "-decl"), []byte(decl))
+}
+
+// trim removes the entries not used for cacheTrimLimit, at most once every cacheTrimInterval
+func (c *InstrumentCache) trim() {
+	marker := filepath.Join(c.Dir, "trim.txt")
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < cacheTrimInterval {
+		return
+	}
+	ioutil.WriteFile(marker, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+
+	subdirs, _ := ioutil.ReadDir(c.Dir)
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+		entries, _ := ioutil.ReadDir(filepath.Join(c.Dir, subdir.Name()))
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), "-decl") || time.Since(entry.ModTime()) < cacheTrimLimit {
+				continue
+			}
+			key := strings.TrimSuffix(entry.Name(), "-decl")
+			os.Remove(c.path(key, "-decl"))
+			os.Remove(c.path(key, "-src"))
+		}
+	}
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+var (
+	binaryIDOnce sync.Once
+	binaryID     string
+)
+
+// gocBinaryID identifies the running goc by the hash of its executable,
+// so the cache never serves files annotated by another version, empty if unknown
+func gocBinaryID() string {
+	binaryIDOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return
+		}
+		binaryID = hex.EncodeToString(h.Sum(nil))
+	})
+	return binaryID
+}
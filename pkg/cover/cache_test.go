@@ -0,0 +1,102 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var cacheTestFiles = map[string]string{
+	"a.go": "package a\n\nfunc A(x int) int {\n\tif x > 0 {\n\t\treturn x\n\t}\n\treturn 0\n}\n",
+	"b.go": "package a\n\nfunc B() {}\n",
+}
+
+func annotateWithCache(t *testing.T, pkg *Package, cache *InstrumentCache) (string, *InstrumentStats) {
+	covers := map[string]*PackageCover{pkg.ImportPath: newPackageCover(pkg)}
+	decl, stats, err := annotatePackages(context.Background(), covers, "count", "example.com/a/globalcover", 0, cache)
+	assert.NoError(t, err)
+	return decl, stats
+}
+
+func TestInstrumentCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache := NewInstrumentCache(dir)
+	assert.NotNil(t, cache)
+
+	first := writeAnnotateTestPackage(t, cacheTestFiles)
+	defer os.RemoveAll(first.Dir)
+	decl, stats := annotateWithCache(t, first, cache)
+	assert.Equal(t, 0, stats.Cached)
+	annotated, err := ioutil.ReadFile(filepath.Join(first.Dir, "a.go"))
+	assert.NoError(t, err)
+
+	// a copy of the project somewhere else is served by the cache, byte for byte
+	second := writeAnnotateTestPackage(t, cacheTestFiles)
+	defer os.RemoveAll(second.Dir)
+	cachedDecl, stats := annotateWithCache(t, second, cache)
+	assert.Equal(t, 2, stats.Cached)
+	assert.Equal(t, decl, cachedDecl)
+	cached, err := ioutil.ReadFile(filepath.Join(second.Dir, "a.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(string(annotated), first.Dir, second.Dir, 1), string(cached))
+
+	// only the changed file is annotated again
+	changed := map[string]string{"a.go": cacheTestFiles["a.go"], "b.go": "package a\n\nfunc B() { println() }\n"}
+	third := writeAnnotateTestPackage(t, changed)
+	defer os.RemoveAll(third.Dir)
+	_, stats = annotateWithCache(t, third, cache)
+	assert.Equal(t, 1, stats.Cached)
+}
+
+func TestInstrumentCacheTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &InstrumentCache{Dir: dir}
+	assert.NoError(t, c.put("aa01", []byte("old"), "old"))
+	assert.NoError(t, c.put("aa02", []byte("new"), "new"))
+	old := time.Now().Add(-2 * cacheTrimLimit)
+	assert.NoError(t, os.Chtimes(c.path("aa01", "-decl"), old, old))
+
+	c.trim()
+	_, _, ok := c.get("aa01")
+	assert.False(t, ok)
+	_, _, ok = c.get("aa02")
+	assert.True(t, ok)
+}
+
+func TestDefaultCacheDir(t *testing.T) {
+	defer os.Setenv("GOC_CACHE", os.Getenv("GOC_CACHE"))
+
+	os.Setenv("GOC_CACHE", "off")
+	assert.Equal(t, "", DefaultCacheDir())
+	assert.Nil(t, NewInstrumentCache(DefaultCacheDir()))
+
+	os.Setenv("GOC_CACHE", "/tmp/goc-cache-dir")
+	assert.Equal(t, "/tmp/goc-cache-dir", DefaultCacheDir())
+}
@@ -0,0 +1,73 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// Carry is the coverage of the previous builds of a program, goc run --watch carries it
+// across the restarts for the files the new builds leave unchanged
+type Carry struct {
+	profiles []*cover.Profile
+	sums     map[string]string // the SHA256 of the files the profiles were collected with
+}
+
+// NewCarry carries the profiles collected from the build with manifest m
+func NewCarry(profiles []*cover.Profile, m *Manifest) *Carry {
+	return &Carry{profiles: profiles, sums: manifestSums(m)}
+}
+
+// Profile returns the carried profile of the files the build with manifest m has unchanged,
+// the blocks of a file only depend on its source. It is empty if there is none.
+func (c *Carry) Profile(m *Manifest) ([]byte, error) {
+	if c == nil {
+		return nil, nil
+	}
+	sums := manifestSums(m)
+	var kept []*cover.Profile
+	for _, p := range c.profiles {
+		if sum, ok := sums[p.FileName]; ok && sum == c.sums[p.FileName] {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := cov.DumpProfile(kept, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// manifestSums returns the SHA256 of the instrumented files of a build, by file name
+func manifestSums(m *Manifest) map[string]string {
+	sums := make(map[string]string)
+	if m == nil {
+		return sums
+	}
+	for _, mp := range m.Packages {
+		for _, f := range mp.Files {
+			sums[f.File] = f.SHA256
+		}
+	}
+	return sums
+}
@@ -0,0 +1,62 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/cover"
+)
+
+func TestCarryProfile(t *testing.T) {
+	manifest := func(sumA, sumB string) *Manifest {
+		return &Manifest{Packages: []ManifestPackage{{
+			ImportPath: "example.com/app",
+			Files: []ManifestFile{
+				{File: "example.com/app/a.go", SHA256: sumA},
+				{File: "example.com/app/b.go", SHA256: sumB},
+			},
+		}}}
+	}
+	profile := "mode: count\n" +
+		"example.com/app/a.go:3.14,5.2 1 4\n" +
+		"example.com/app/b.go:3.14,5.2 1 2\n"
+
+	profiles, err := cover.ParseProfilesFromReader(strings.NewReader(profile))
+	assert.NoError(t, err)
+	carry := NewCarry(profiles, manifest("a1", "b1"))
+
+	// b.go changed, its counts are dropped
+	got, err := carry.Profile(manifest("a1", "b2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mode: count\nexample.com/app/a.go:3.14,5.2 1 4\n", string(got))
+
+	got, err = carry.Profile(manifest("a1", "b1"))
+	assert.NoError(t, err)
+	assert.Equal(t, profile, string(got))
+
+	got, err = carry.Profile(manifest("a2", "b2"))
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	// nothing carried yet
+	got, err = (*Carry)(nil).Profile(manifest("a1", "b1"))
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,384 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+const (
+	coverListV2API   = "/v2/cover/list"
+	coverClearV2API  = "/v2/cover/clear"
+	coverRemoveV2API = "/v2/cover/remove"
+)
+
+// Service is a service registered into the center, with its addresses
+type Service struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+}
+
+// ProfileReport is the profile the center merged from the services built from the same sources
+type ProfileReport struct {
+	Profiles   []*cover.Profile
+	Branches   []*BranchProfile // with ProfileParam.Branch, of the services built with --mode=branch
+	Incoherent []string         // addresses of the services left out, built from other sources
+}
+
+// UploadResult tells where the center lists uploaded profiles
+type UploadResult struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Files   int    `json:"files"`
+}
+
+// CenterError is an error the center answered a request with
+type CenterError struct {
+	StatusCode int
+	Code       string // the code of a /v2 error
+	Message    string // the error of the json body, or the body itself
+}
+
+func (e *CenterError) Error() string {
+	return fmt.Sprintf("center responded %d: %s", e.StatusCode, e.Message)
+}
+
+// RetryPolicy tells how a CenterClient retries the requests failing on the network or on a center not ready
+type RetryPolicy struct {
+	Attempts int           // tries of a request in all, 1 if less
+	Backoff  time.Duration // wait before the first retry, doubled before each next one
+}
+
+// DefaultRetryPolicy retries a failed request once, right away, as the Action client does
+var DefaultRetryPolicy = RetryPolicy{Attempts: 2}
+
+// CenterOptions configure a CenterClient, the zero value is valid
+type CenterOptions struct {
+	HTTPClient *http.Client // http.DefaultClient if nil
+	Retry      *RetryPolicy // DefaultRetryPolicy if nil
+}
+
+// CenterClient is a typed client of the center, its calls stop once their context is done
+type CenterClient struct {
+	host   string
+	client *http.Client
+	retry  RetryPolicy
+}
+
+// NewCenterClient creates a client of the center at host
+func NewCenterClient(host string, opts CenterOptions) (*CenterClient, error) {
+	if _, err := url.ParseRequestURI(host); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCenterURL, host, err)
+	}
+	c := &CenterClient{
+		host:   strings.TrimSuffix(host, "/"),
+		client: opts.HTTPClient,
+		retry:  DefaultRetryPolicy,
+	}
+	if c.client == nil {
+		c.client = http.DefaultClient
+	}
+	if opts.Retry != nil {
+		c.retry = *opts.Retry
+	}
+	return c, nil
+}
+
+// ListServices returns the registered services and the uploaded profiles, sorted by name
+func (c *CenterClient) ListServices(ctx context.Context) ([]Service, error) {
+	list := make([]Service, 0)
+	for offset := 0; ; {
+		_, body, err := c.do(ctx, "GET", fmt.Sprintf("%s?offset=%d&limit=%d", coverListV2API, offset, maxListLimit), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Data []Service `json:"data"`
+			Page *APIPage  `json:"page"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, fmt.Errorf("invalid services list: %v", err)
+		}
+		list = append(list, res.Data...)
+		if res.Page == nil || res.Page.Next == nil || *res.Page.Next <= offset {
+			return list, nil
+		}
+		offset = *res.Page.Next
+	}
+}
+
+// Register registers the service into the center
+func (c *CenterClient) Register(ctx context.Context, srv ServiceUnderTest) error {
+	if _, err := url.ParseRequestURI(srv.Address); err != nil {
+		return err
+	}
+	if strings.TrimSpace(srv.Name) == "" {
+		return fmt.Errorf("invalid service name")
+	}
+	query := url.Values{"name": {srv.Name}, "address": {srv.Address}}
+	if srv.IPRevise != "" {
+		query.Set("ip_revise", srv.IPRevise)
+	}
+	_, _, err := c.do(ctx, "POST", CoverRegisterServiceAPI+"?"+query.Encode(), nil, nil)
+	return err
+}
+
+// Profile returns the profile of the services param selects, merged by the center
+func (c *CenterClient) Profile(ctx context.Context, param ProfileParam) ([]*cover.Profile, error) {
+	report, err := c.ProfileReport(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	return report.Profiles, nil
+}
+
+// ProfileReport returns the profile of the services param selects, with their branch coverage
+// and the services left out of the merge
+func (c *CenterClient) ProfileReport(ctx context.Context, param ProfileParam) (*ProfileReport, error) {
+	param.Groups = false
+	res, body, err := c.profile(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	report := &ProfileReport{}
+	if isCompactProfile(res.Header.Get("Content-Type")) {
+		report.Profiles, err = DecodeCompactProfile(bytes.NewReader(body))
+	} else {
+		report.Profiles, report.Branches, err = ParseProfileWithBranches(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if left := res.Header.Get(ProfileIncoherentHeader); left != "" {
+		report.Incoherent = strings.Split(left, ",")
+	}
+	return report, nil
+}
+
+// ProfileGroups returns the profile of every group of the services param selects built from the same sources
+func (c *CenterClient) ProfileGroups(ctx context.Context, param ProfileParam) ([]*ProfileGroup, error) {
+	param.Groups = true
+	_, body, err := c.profile(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*ProfileGroup
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("invalid profile groups: %v", err)
+	}
+	for _, g := range groups {
+		if g.Profiles, g.Branches, err = ParseProfileWithBranches([]byte(g.Profile)); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (c *CenterClient) profile(ctx context.Context, param ProfileParam) (*http.Response, []byte, error) {
+	if len(param.Service) != 0 && len(param.Address) != 0 {
+		return nil, nil, fmt.Errorf("use 'service' flag and 'address' flag at the same time may cause ambiguity, please use them separately")
+	}
+	body, err := json.Marshal(param)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the center answers in text if it does not support the compact encoding
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", ProfileCompactContentType+", "+ProfileTextContentType)
+	return c.do(ctx, "POST", CoverProfileAPI, header, body)
+}
+
+// Clear clears the counters of the services param selects, and returns the result of each of them,
+// along with a CenterError if any failed
+func (c *CenterClient) Clear(ctx context.Context, param ProfileParam) ([]ServiceResult, error) {
+	return c.selectServices(ctx, coverClearV2API, param)
+}
+
+// Remove removes the services param selects from the center, and returns the result of each of them,
+// along with a CenterError if any failed
+func (c *CenterClient) Remove(ctx context.Context, param ProfileParam) ([]ServiceResult, error) {
+	return c.selectServices(ctx, coverRemoveV2API, param)
+}
+
+func (c *CenterClient) selectServices(ctx context.Context, api string, param ProfileParam) ([]ServiceResult, error) {
+	if len(param.Service) != 0 && len(param.Address) != 0 {
+		return nil, fmt.Errorf("use 'service' flag and 'address' flag at the same time may cause ambiguity, please use them separately")
+	}
+	body, err := json.Marshal(param)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	_, res, err := c.do(ctx, "POST", api, header, body)
+	if res == nil {
+		return nil, err
+	}
+	var envelope struct {
+		Data []ServiceResult `json:"data"`
+	}
+	if jerr := json.Unmarshal(res, &envelope); jerr != nil && err == nil {
+		err = fmt.Errorf("invalid service results: %v", jerr)
+	}
+	return envelope.Data, err
+}
+
+// Init removes all the services and uploaded profiles from the center, for a new round of tests
+func (c *CenterClient) Init(ctx context.Context) error {
+	_, _, err := c.do(ctx, "POST", CoverInitSystemAPI, nil, nil)
+	return err
+}
+
+// Upload sends the files, native coverage data files or goc profiles, to the center under the name
+func (c *CenterClient) Upload(ctx context.Context, name string, files map[string][]byte) (*UploadResult, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("invalid name")
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for filename, data := range files {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", w.FormDataContentType())
+	_, res, err := c.do(ctx, "POST", CoverUploadAPI+"?name="+url.QueryEscape(name), header, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	result := &UploadResult{}
+	if err := json.Unmarshal(res, result); err != nil {
+		return nil, fmt.Errorf("invalid upload result: %v", err)
+	}
+	return result, nil
+}
+
+// do sends the request to the api, retrying it as the policy says, and returns the response if its
+// status is 200, a CenterError otherwise
+func (c *CenterClient) do(ctx context.Context, method, api string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	attempts := c.retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := c.retry.Backoff
+	var (
+		res  *http.Response
+		data []byte
+		err  error
+	)
+	for i := 0; i < attempts; i++ {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if i > 0 && backoff > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		res, data, err = c.send(ctx, method, c.host+api, header, body)
+		if !retryable(ctx, res, err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return res, data, centerError(res.StatusCode, data)
+	}
+	return res, data, nil
+}
+
+func (c *CenterClient) send(ctx context.Context, method, u string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	return res, data, err
+}
+
+// retryable tells whether a request failed on the network or on a center not ready, rather than on the request
+func retryable(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return isNetworkError(err)
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// centerError reads the error of the center from the json body, {"error": "..."} of /v1
+// or the APIError of /v2, or the body itself
+func centerError(status int, body []byte) error {
+	e := &CenterError{StatusCode: status, Message: strings.TrimSpace(string(body))}
+	var res struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &res) != nil || len(res.Error) == 0 {
+		return e
+	}
+	var message string
+	var apiErr APIError
+	if json.Unmarshal(res.Error, &message) == nil && message != "" {
+		e.Message = message
+	} else if json.Unmarshal(res.Error, &apiErr) == nil && apiErr.Message != "" {
+		e.Code, e.Message = apiErr.Code, apiErr.Message
+	}
+	return e
+}
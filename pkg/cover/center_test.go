@@ -0,0 +1,182 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCenterClient(t *testing.T) {
+	_, err := NewCenterClient("127.0.0.1:7777", CenterOptions{})
+	assert.True(t, errors.Is(err, ErrInvalidCenterURL))
+
+	ts := httptest.NewServer(NewMemoryBasedServer().Route(ioutil.Discard))
+	defer ts.Close()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mode: count\nexample.com/app/main.go:30.13,48.33 13 1\n"))
+	}))
+	defer agent.Close()
+
+	ctx := context.Background()
+	client, err := NewCenterClient(ts.URL+"/", CenterOptions{})
+	assert.NoError(t, err)
+
+	services, err := client.ListServices(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+
+	// the profile of no service is an error of the center
+	_, err = client.Profile(ctx, ProfileParam{Force: true})
+	var centerErr *CenterError
+	assert.True(t, errors.As(err, &centerErr))
+	assert.Equal(t, http.StatusExpectationFailed, centerErr.StatusCode)
+	assert.Contains(t, centerErr.Message, "no profiles")
+	// the errors of /v2 are read too
+	err = centerError(http.StatusNotFound, []byte(`{"data":null,"error":{"code":"not_found","message":"no profiles"}}`))
+	assert.Equal(t, &CenterError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "no profiles"}, err)
+
+	assert.NoError(t, client.Register(ctx, ServiceUnderTest{Name: "b", Address: agent.URL}))
+	assert.NoError(t, client.Register(ctx, ServiceUnderTest{Name: "a", Address: agent.URL}))
+	assert.Error(t, client.Register(ctx, ServiceUnderTest{Name: " ", Address: agent.URL}))
+	services, err = client.ListServices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Service{{Name: "a", Addresses: []string{agent.URL}}, {Name: "b", Addresses: []string{agent.URL}}}, services)
+
+	profiles, err := client.Profile(ctx, ProfileParam{Force: true, Service: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, "example.com/app/main.go", profiles[0].FileName)
+	assert.Equal(t, 1, profiles[0].Blocks[0].Count)
+
+	groups, err := client.ProfileGroups(ctx, ProfileParam{Force: true})
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Len(t, groups[0].Profiles, 1)
+
+	_, err = client.Clear(ctx, ProfileParam{Service: []string{"a"}, Address: []string{agent.URL}})
+	assert.Error(t, err)
+	results, err := client.Clear(ctx, ProfileParam{Service: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceResult{{Name: "a", Address: agent.URL, Result: "cleared"}}, results)
+	// every service gets its result, the ones which failed with an error
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	assert.NoError(t, client.Register(ctx, ServiceUnderTest{Name: "c", Address: down.URL}))
+	results, err = client.Clear(ctx, ProfileParam{Service: []string{"a", "c"}})
+	assert.True(t, errors.As(err, &centerErr))
+	assert.Equal(t, http.StatusBadGateway, centerErr.StatusCode)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "cleared", results[0].Result)
+		assert.Equal(t, "c", results[1].Name)
+		assert.Equal(t, "failed", results[1].Result)
+		assert.NotEmpty(t, results[1].Error)
+	}
+	_, err = client.Clear(ctx, ProfileParam{Service: []string{"x"}})
+	assert.True(t, errors.As(err, &centerErr))
+	assert.Equal(t, http.StatusNotFound, centerErr.StatusCode)
+	results, err = client.Clear(ctx, ProfileParam{Service: []string{"x"}, Force: true})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = client.Remove(ctx, ProfileParam{Service: []string{"a", "c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceResult{{Name: "a", Address: agent.URL, Result: "removed"}, {Name: "c", Address: down.URL, Result: "removed"}}, results)
+
+	result, err := client.Upload(ctx, "e2e", map[string][]byte{"a.cov": []byte("mode: count\nexample.com/app/main.go:30.13,48.33 13 2\n")})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e", result.Name)
+	assert.Equal(t, 1, result.Files)
+
+	assert.NoError(t, client.Init(ctx))
+	services, err = client.ListServices(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+// flakyTransport fails the first requests, then sends the next ones
+type flakyTransport struct {
+	failures int
+	requests int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	if t.requests <= t.failures {
+		return nil, errors.New("connection reset")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCenterClientRetry(t *testing.T) {
+	ts := httptest.NewServer(NewMemoryBasedServer().Route(ioutil.Discard))
+	defer ts.Close()
+	ctx := context.Background()
+
+	// the default policy retries once
+	transport := &flakyTransport{failures: 1}
+	client, err := NewCenterClient(ts.URL, CenterOptions{HTTPClient: &http.Client{Transport: transport}})
+	assert.NoError(t, err)
+	_, err = client.ListServices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transport.requests)
+
+	transport = &flakyTransport{failures: 3}
+	client, err = NewCenterClient(ts.URL, CenterOptions{
+		HTTPClient: &http.Client{Transport: transport},
+		Retry:      &RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	})
+	assert.NoError(t, err)
+	_, err = client.ListServices(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 3, transport.requests)
+
+	// a center not ready is retried, the errors of the request are not
+	status := []int{http.StatusServiceUnavailable, http.StatusOK}
+	calls := 0
+	unready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status[calls])
+		w.Write([]byte("{}"))
+		calls++
+	}))
+	defer unready.Close()
+	client, err = NewCenterClient(unready.URL, CenterOptions{})
+	assert.NoError(t, err)
+	_, err = client.ListServices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// a done context stops the retries
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	transport = &flakyTransport{failures: 1}
+	client, err = NewCenterClient(ts.URL, CenterOptions{
+		HTTPClient: &http.Client{Transport: transport},
+		Retry:      &RetryPolicy{Attempts: 3, Backoff: time.Hour},
+	})
+	assert.NoError(t, err)
+	_, err = client.ListServices(canceled)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, transport.requests)
+}
@@ -19,18 +19,22 @@ package cover
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
+	"k8s.io/test-infra/gopherage/pkg/cov"
 )
 
-// Action provides methods to contact with the covered service under test
+// Action provides methods to contact with the covered service under test, the center
+// contacts the services with the one NewWorker returns. The embedders calling the center
+// use CenterClient.
 type Action interface {
 	Profile(param ProfileParam) ([]byte, error)
 	Clear(param ProfileParam) ([]byte, error)
@@ -38,6 +42,7 @@ type Action interface {
 	InitSystem() ([]byte, error)
 	ListServices() ([]byte, error)
 	RegisterService(svr ServiceUnderTest) ([]byte, error)
+	Upload(name string, files map[string][]byte) ([]byte, error)
 }
 
 const (
@@ -45,6 +50,10 @@ const (
 	CoverInitSystemAPI = "/v1/cover/init"
 	//CoverProfileAPI is provided by the covered service to get profiles
 	CoverProfileAPI = "/v1/cover/profile"
+	//CoverBranchAPI is provided by the covered service to get the branch coverage section
+	CoverBranchAPI = "/v1/cover/branch"
+	//CoverUnlinkedAPI is provided by the covered service to get the zero count profile of the packages no binary links
+	CoverUnlinkedAPI = "/v1/cover/unlinked"
 	//CoverProfileClearAPI is provided by the covered service to clear profiles
 	CoverProfileClearAPI = "/v1/cover/clear"
 	//CoverServicesListAPI list all the registered services
@@ -53,23 +62,48 @@ const (
 	CoverRegisterServiceAPI = "/v1/cover/register"
 	//CoverServicesRemoveAPI remove one services from the service center
 	CoverServicesRemoveAPI = "/v1/cover/remove"
+	//CoverUploadAPI upload profiles, or native coverage data, into the service center
+	CoverUploadAPI = "/v1/cover/upload"
 )
 
+// ErrInvalidCenterURL represents the error that the url of the center cannot be parsed
+var ErrInvalidCenterURL = errors.New("invalid center url")
+
 type client struct {
 	Host   string
 	client *http.Client
+	err    error // why the client cannot contact the center, returned by every call
 }
 
-// NewWorker creates a worker to contact with service
-func NewWorker(host string) Action {
-	_, err := url.ParseRequestURI(host)
-	if err != nil {
-		log.Fatalf("Parse url %s failed, err: %v", host, err)
+// NewClient creates a client of the center at host sending its requests with httpClient,
+// http.DefaultClient if nil. It answers the raw responses of the /v1 API.
+//
+// Deprecated: use NewCenterClient with CenterOptions.HTTPClient, its calls take a context,
+// parse the answers and retry as told.
+func NewClient(host string, httpClient *http.Client) (Action, error) {
+	return newClient(host, httpClient)
+}
+
+func newClient(host string, httpClient *http.Client) (*client, error) {
+	if _, err := url.ParseRequestURI(host); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCenterURL, host, err)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
 	}
 	return &client{
 		Host:   host,
-		client: http.DefaultClient,
+		client: httpClient,
+	}, nil
+}
+
+// NewWorker creates a worker to contact with service, its calls fail if host is not a valid url
+func NewWorker(host string) Action {
+	c, err := newClient(host, nil)
+	if err != nil {
+		return &client{Host: host, client: http.DefaultClient, err: err}
 	}
+	return c
 }
 
 func (c *client) RegisterService(srv ServiceUnderTest) ([]byte, error) {
@@ -104,17 +138,69 @@ func (c *client) Profile(param ProfileParam) ([]byte, error) {
 	// so no need to check here
 	body, _ := json.Marshal(param)
 
-	res, profile, err := c.do("POST", u, "application/json", bytes.NewReader(body))
+	// ask for the compact encoding, the center answers in text if it does not support it.
+	// gzip is negotiated by the transport and decompressed transparently.
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", ProfileCompactContentType+", "+ProfileTextContentType)
+	res, profile, err := c.doWithHeader("POST", u, header, bytes.NewReader(body))
 	if err != nil && isNetworkError(err) {
-		res, profile, err = c.do("POST", u, "application/json", bytes.NewReader(body))
+		res, profile, err = c.doWithHeader("POST", u, header, bytes.NewReader(body))
 	}
 
 	if err == nil && res.StatusCode != 200 {
 		err = fmt.Errorf(string(profile))
 	}
+	if err == nil && res.Header.Get(ProfileIncoherentHeader) != "" {
+		log.Warnf("profiles of %s were built from different sources and are left out, use --groups to get them separately", res.Header.Get(ProfileIncoherentHeader))
+	}
+	if err == nil && isCompactProfile(res.Header.Get("Content-Type")) {
+		profile, err = compactToText(profile)
+	}
 	return profile, err
 }
 
+// agentProfile fetches the profile of a covered service. It asks for the
+// compact encoding and tells the agent which block table the caller already
+// holds, older agents simply answer in text.
+func (c *client) agentProfile(buildID string) (*http.Response, []byte, error) {
+	u := fmt.Sprintf("%s%s", c.Host, CoverProfileAPI)
+	header := http.Header{}
+	header.Set("Accept", ProfileCompactContentType+", "+ProfileTextContentType)
+	if buildID != "" {
+		header.Set(ProfileBuildIDHeader, buildID)
+	}
+	res, profile, err := c.doWithHeader("GET", u, header, nil)
+	if err != nil && isNetworkError(err) {
+		res, profile, err = c.doWithHeader("GET", u, header, nil)
+	}
+
+	if err == nil && res.StatusCode != 200 {
+		err = fmt.Errorf(string(profile))
+	}
+	return res, profile, err
+}
+
+// agentBranches fetches the branch coverage section of a covered service
+func (c *client) agentBranches() (*http.Response, []byte, error) {
+	u := fmt.Sprintf("%s%s", c.Host, CoverBranchAPI)
+	res, body, err := c.do("GET", u, "", nil)
+	if err != nil && isNetworkError(err) {
+		res, body, err = c.do("GET", u, "", nil)
+	}
+	return res, body, err
+}
+
+// agentUnlinked fetches the zero count profile of the packages no binary links
+func (c *client) agentUnlinked() (*http.Response, []byte, error) {
+	u := fmt.Sprintf("%s%s", c.Host, CoverUnlinkedAPI)
+	res, body, err := c.do("GET", u, "", nil)
+	if err != nil && isNetworkError(err) {
+		res, body, err = c.do("GET", u, "", nil)
+	}
+	return res, body, err
+}
+
 func (c *client) Clear(param ProfileParam) ([]byte, error) {
 	u := fmt.Sprintf("%s%s", c.Host, CoverProfileClearAPI)
 	if len(param.Service) != 0 && len(param.Address) != 0 {
@@ -150,6 +236,37 @@ func (c *client) Remove(param ProfileParam) ([]byte, error) {
 	return resp, err
 }
 
+// Upload sends the files, native coverage data files or goc profiles, to the center under the name
+func (c *client) Upload(name string, files map[string][]byte) ([]byte, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("invalid name")
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for filename, data := range files {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	u := fmt.Sprintf("%s%s?name=%s", c.Host, CoverUploadAPI, url.QueryEscape(name))
+	res, resp, err := c.do("POST", u, w.FormDataContentType(), bytes.NewReader(body.Bytes()))
+	if err != nil && isNetworkError(err) {
+		res, resp, err = c.do("POST", u, w.FormDataContentType(), bytes.NewReader(body.Bytes()))
+	}
+	if err == nil && res.StatusCode != 200 {
+		err = fmt.Errorf(string(resp))
+	}
+	return resp, err
+}
+
 func (c *client) InitSystem() ([]byte, error) {
 	u := fmt.Sprintf("%s%s", c.Host, CoverInitSystemAPI)
 	_, body, err := c.do("POST", u, "", nil)
@@ -157,13 +274,24 @@ func (c *client) InitSystem() ([]byte, error) {
 }
 
 func (c *client) do(method, url, contentType string, body io.Reader) (*http.Response, []byte, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return c.doWithHeader(method, url, header, body)
+}
+
+func (c *client) doWithHeader(method, url string, header http.Header, body io.Reader) (*http.Response, []byte, error) {
+	if c.err != nil {
+		return nil, nil, c.err
+	}
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header[k] = v
 	}
 
 	res, err := c.client.Do(req)
@@ -179,6 +307,19 @@ func (c *client) do(method, url, contentType string, body io.Reader) (*http.Resp
 	return res, responseBody, nil
 }
 
+// compactToText converts a compact profile back into the text format callers expect
+func compactToText(p []byte) ([]byte, error) {
+	profiles, err := DecodeCompactProfile(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := cov.DumpProfile(profiles, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func isNetworkError(err error) bool {
 	if err == io.EOF {
 		return true
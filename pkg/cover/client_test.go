@@ -17,7 +17,9 @@
 package cover
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http/httptest"
 	"os"
 	"testing"
@@ -255,3 +257,32 @@ func TestClientRemove(t *testing.T) {
 	_, err = c.Remove(p)
 	assert.Error(t, err)
 }
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient("127.0.0.1:7777", nil)
+	assert.True(t, errors.Is(err, ErrInvalidCenterURL))
+
+	// a worker of an invalid url fails its calls, it does not exit
+	_, err = NewWorker("127.0.0.1:7777").ListServices()
+	assert.True(t, errors.Is(err, ErrInvalidCenterURL))
+
+	server := NewMemoryBasedServer()
+	ts := httptest.NewServer(server.Route(ioutil.Discard))
+	defer ts.Close()
+	transport := &countingTransport{}
+	client, err := NewClient(ts.URL, &http.Client{Transport: transport})
+	assert.NoError(t, err)
+	res, err := client.ListServices()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(res))
+	assert.Equal(t, 1, transport.requests)
+}
@@ -0,0 +1,165 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// ProfileIncoherentHeader lists the sources left out of a merged profile
+// because they were built from different source revisions than the merged ones.
+const ProfileIncoherentHeader = "X-Goc-Incoherent"
+
+// ProfileSource is the profile of one covered service instance or one file
+type ProfileSource struct {
+	Name     string // service name or file path
+	Address  string // service address, empty for files
+	BuildID  string // build fingerprint reported by the agent, computed if empty
+	Profiles []*cover.Profile
+	Branches []*BranchProfile // branch coverage, if asked for
+}
+
+// ProfileGroup is a set of coherent sources merged into one profile.
+// Sources are coherent when every file they have in common has the same blocks.
+type ProfileGroup struct {
+	BuildIDs []string         `json:"build_ids"`
+	Names    []string         `json:"names"`
+	Address  []string         `json:"address,omitempty"`
+	Profiles []*cover.Profile `json:"-"`
+	Branches []*BranchProfile `json:"-"`
+	Profile  string           `json:"profile,omitempty"`
+}
+
+// ProfileFingerprint hashes the block layout of profiles, counts are ignored.
+// Profiles of the same build always have the same fingerprint.
+func ProfileFingerprint(profiles []*cover.Profile) string {
+	sorted := append([]*cover.Profile(nil), profiles...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+
+	h := sha256.New()
+	if len(sorted) > 0 {
+		fmt.Fprintf(h, "%s\n", sorted[0].Mode)
+	}
+	for _, p := range sorted {
+		fmt.Fprintf(h, "%s %d\n", p.FileName, len(p.Blocks))
+		for _, b := range p.Blocks {
+			fmt.Fprintf(h, "%d.%d,%d.%d %d\n", b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// MergeCoherentProfiles groups the sources by build fingerprint and merges
+// the groups whose common files agree, so instances built from different
+// source revisions no longer fail the whole merge.
+// The groups are ordered by the number of sources, largest first.
+func MergeCoherentProfiles(sources []ProfileSource) ([]*ProfileGroup, error) {
+	var (
+		builds []*ProfileGroup
+		byID   = make(map[string]*ProfileGroup)
+	)
+	for _, src := range sources {
+		id := src.BuildID
+		if id == "" {
+			id = ProfileFingerprint(src.Profiles)
+		}
+		b, ok := byID[id]
+		if !ok {
+			b = &ProfileGroup{BuildIDs: []string{id}, Profiles: src.Profiles, Branches: src.Branches}
+			byID[id] = b
+			builds = append(builds, b)
+		} else {
+			merged, err := cov.MergeProfiles(b.Profiles, src.Profiles)
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge %s into build %s: %v", src.Name, id, err)
+			}
+			b.Profiles = merged
+			b.Branches = mergeBranches(b.Branches, src.Branches)
+		}
+		b.Names = append(b.Names, src.Name)
+		if src.Address != "" {
+			b.Address = append(b.Address, src.Address)
+		}
+	}
+
+	var groups []*ProfileGroup
+	for _, b := range builds {
+		var target *ProfileGroup
+		for _, g := range groups {
+			if coherent(g.Profiles, b.Profiles) {
+				target = g
+				break
+			}
+		}
+		if target == nil {
+			groups = append(groups, b)
+			continue
+		}
+		merged, err := cov.MergeMultipleProfiles([][]*cover.Profile{target.Profiles, b.Profiles})
+		if err != nil {
+			return nil, err
+		}
+		target.Profiles = merged
+		target.Branches = mergeBranches(target.Branches, b.Branches)
+		target.BuildIDs = append(target.BuildIDs, b.BuildIDs...)
+		target.Names = append(target.Names, b.Names...)
+		target.Address = append(target.Address, b.Address...)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Names) > len(groups[j].Names) })
+	return groups, nil
+}
+
+// mergeBranches merges branch profiles, nil stays nil so callers can tell
+// branch coverage was not asked for
+func mergeBranches(a, b []*BranchProfile) []*BranchProfile {
+	if a == nil && b == nil {
+		return nil
+	}
+	return MergeBranchProfiles(a, b)
+}
+
+// coherent reports whether the files a and b have in common have the same blocks
+func coherent(a, b []*cover.Profile) bool {
+	files := make(map[string]*cover.Profile, len(a))
+	for _, p := range a {
+		files[p.FileName] = p
+	}
+	for _, p := range b {
+		q, ok := files[p.FileName]
+		if !ok {
+			continue
+		}
+		if p.Mode != q.Mode || len(p.Blocks) != len(q.Blocks) {
+			return false
+		}
+		for i := range p.Blocks {
+			x, y := p.Blocks[i], q.Blocks[i]
+			if x.StartLine != y.StartLine || x.StartCol != y.StartCol ||
+				x.EndLine != y.EndLine || x.EndCol != y.EndCol || x.NumStmt != y.NumStmt {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/cover"
+)
+
+func parseTestProfile(t *testing.T, s string) []*cover.Profile {
+	profiles, err := cover.ParseProfilesFromReader(strings.NewReader(s))
+	assert.NoError(t, err)
+	return profiles
+}
+
+func TestProfileFingerprint(t *testing.T) {
+	a := parseTestProfile(t, "mode: count\na/a.go:1.1,2.2 1 5\nb/b.go:1.1,2.2 1 0\n")
+	b := parseTestProfile(t, "mode: count\nb/b.go:1.1,2.2 1 7\na/a.go:1.1,2.2 1 0\n")
+	c := parseTestProfile(t, "mode: count\na/a.go:1.1,2.3 1 5\nb/b.go:1.1,2.2 1 0\n")
+	d := parseTestProfile(t, "mode: set\na/a.go:1.1,2.2 1 1\nb/b.go:1.1,2.2 1 0\n")
+
+	assert.Equal(t, ProfileFingerprint(a), ProfileFingerprint(b))
+	assert.NotEqual(t, ProfileFingerprint(a), ProfileFingerprint(c))
+	assert.NotEqual(t, ProfileFingerprint(a), ProfileFingerprint(d))
+}
+
+func TestMergeCoherentProfiles(t *testing.T) {
+	v1 := "mode: count\na/a.go:1.1,2.2 1 1\nlib/lib.go:1.1,2.2 1 1\n"
+	v2 := "mode: count\na/a.go:1.1,3.2 2 1\nlib/lib.go:1.1,2.2 1 1\n"
+	other := "mode: count\nb/b.go:1.1,2.2 1 1\nlib/lib.go:1.1,2.2 1 1\n"
+
+	groups, err := MergeCoherentProfiles([]ProfileSource{
+		{Name: "a", Address: "http://a1", BuildID: "v1", Profiles: parseTestProfile(t, v1)},
+		{Name: "a", Address: "http://a2", BuildID: "v2", Profiles: parseTestProfile(t, v2)},
+		{Name: "a", Address: "http://a3", BuildID: "v1", Profiles: parseTestProfile(t, v1)},
+		{Name: "b", Address: "http://b1", Profiles: parseTestProfile(t, other)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+
+	// the v1 instances and the unrelated service b are coherent
+	assert.Equal(t, []string{"http://a1", "http://a3", "http://b1"}, groups[0].Address)
+	assert.Equal(t, "v1", groups[0].BuildIDs[0])
+	assert.Len(t, groups[0].BuildIDs, 2)
+	assert.Len(t, groups[0].Profiles, 3)
+	for _, p := range groups[0].Profiles {
+		if p.FileName == "lib/lib.go" {
+			assert.Equal(t, 3, p.Blocks[0].Count)
+		}
+	}
+
+	assert.Equal(t, []string{"http://a2"}, groups[1].Address)
+	assert.Equal(t, []string{"v2"}, groups[1].BuildIDs)
+
+	// mode mismatch is not coherent either
+	groups, err = MergeCoherentProfiles([]ProfileSource{
+		{Name: "x.cov", Profiles: parseTestProfile(t, v1)},
+		{Name: "y.cov", Profiles: parseTestProfile(t, strings.Replace(v1, "count", "set", 1))},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, []string{"x.cov"}, groups[0].Names)
+}
+
+func TestProfileIncoherentServices(t *testing.T) {
+	agent := func(buildID, profile string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(ProfileBuildIDHeader, buildID)
+			w.Write([]byte(profile))
+		}))
+	}
+	a1 := agent("v1", "mode: count\na/a.go:1.1,2.2 1 1\n")
+	defer a1.Close()
+	a2 := agent("v1", "mode: count\na/a.go:1.1,2.2 1 2\n")
+	defer a2.Close()
+	a3 := agent("v2", "mode: count\na/a.go:1.1,3.2 2 1\n")
+	defer a3.Close()
+
+	server := &server{Store: NewMemoryStore()}
+	for _, a := range []*httptest.Server{a1, a2, a3} {
+		assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "a", Address: a.URL}))
+	}
+	router := server.Route(os.Stdout)
+
+	// the largest coherent group is merged, the other one is reported
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/cover/profile?force=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mode: count\na/a.go:1.1,2.2 1 3\n", w.Body.String())
+	assert.Equal(t, a3.URL, w.Header().Get(ProfileIncoherentHeader))
+
+	// every group on request
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/cover/profile?force=true&groups=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var groups []ProfileGroup
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &groups))
+	assert.Len(t, groups, 2)
+	assert.Equal(t, []string{"v1"}, groups[0].BuildIDs)
+	assert.Equal(t, "mode: count\na/a.go:1.1,3.2 2 1\n", groups[1].Profile)
+}
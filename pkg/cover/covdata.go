@@ -0,0 +1,737 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// Go 1.20 and later build binaries with -cover, which write their coverage to GOCOVERDIR
+// as a meta-data file, covmeta.<hash>, describing the blocks of every package and counter
+// data files, covcounters.<hash>.<pid>.<time>, one per run. The layout below follows
+// internal/coverage of the Go distribution, which other modules can't import.
+
+const (
+	covMetaPrefix     = "covmeta"
+	covCountersPrefix = "covcounters"
+	covFileVersion    = 1
+)
+
+var (
+	covMetaMagic    = [4]byte{0x00, 0x63, 0x76, 0x6d}
+	covCounterMagic = [4]byte{0x00, 0x63, 0x77, 0x6d}
+
+	// ErrInvalidCovData represents the error that a file is not valid go coverage data
+	ErrInvalidCovData = errors.New("invalid go coverage data")
+)
+
+// counter modes and granularities of the meta-data file
+const (
+	covModeSet    = 1
+	covModeCount  = 2
+	covModeAtomic = 3
+
+	covGranularityPerBlock = 1
+	covGranularityPerFunc  = 2
+)
+
+// counter flavors of the counter data files
+const (
+	covFlavorRaw     = 1
+	covFlavorULeb128 = 2
+)
+
+type covMetaFileHeader struct {
+	Magic        [4]byte
+	Version      uint32
+	TotalLength  uint64
+	Entries      uint64
+	MetaFileHash [16]byte
+	StrTabOffset uint32
+	StrTabLength uint32
+	CMode        uint8
+	CGranularity uint8
+	_            [6]byte
+}
+
+type covMetaSymbolHeader struct {
+	Length     uint32
+	PkgName    uint32
+	PkgPath    uint32
+	ModulePath uint32
+	MetaHash   [16]byte
+	_          byte
+	_          [3]byte
+	NumFiles   uint32
+	NumFuncs   uint32
+}
+
+type covCounterFileHeader struct {
+	Magic     [4]byte
+	Version   uint32
+	MetaHash  [16]byte
+	CFlavor   uint8
+	BigEndian bool
+	_         [6]byte
+}
+
+type covCounterSegmentHeader struct {
+	FcnEntries uint64
+	StrTabLen  uint32
+	ArgsLen    uint32
+}
+
+type covCounterFileFooter struct {
+	Magic       [4]byte
+	_           [4]byte
+	NumSegments uint32
+	_           [4]byte
+}
+
+// covFunc is a function of the meta-data, its units are the blocks of the profile
+type covFunc struct {
+	name  string
+	file  string
+	units []cover.ProfileBlock
+}
+
+type covPackage struct {
+	path  string
+	funcs []covFunc
+}
+
+type covMeta struct {
+	hash    [16]byte
+	mode    string
+	perFunc bool
+	pkgs    []covPackage
+}
+
+// IsCovDataFile reports whether the file is a go coverage meta-data or counter data file
+func IsCovDataFile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, covMetaPrefix+".") || strings.HasPrefix(base, covCountersPrefix+".")
+}
+
+// ReadCovDir reads the coverage data a binary built with go build -cover wrote to a GOCOVERDIR
+func ReadCovDir(dir string) ([]*cover.Profile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, info := range infos {
+		if info.IsDir() || !IsCovDataFile(info.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[info.Name()] = data
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go coverage data in %s", dir)
+	}
+	return ReadCovFiles(files)
+}
+
+// ReadCovFiles converts go coverage data files, keyed by name, to profiles.
+// The counters of every run are summed up, the blocks no run reached are kept with a zero count.
+func ReadCovFiles(files map[string][]byte) ([]*cover.Profile, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metas := make(map[[16]byte]*covMeta)
+	var hashes [][16]byte
+	for _, name := range names {
+		if !strings.HasPrefix(filepath.Base(name), covMetaPrefix+".") {
+			continue
+		}
+		m, err := decodeCovMeta(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if _, ok := metas[m.hash]; !ok {
+			metas[m.hash] = m
+			hashes = append(hashes, m.hash)
+		}
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(filepath.Base(name), covCountersPrefix+".") {
+			continue
+		}
+		if err := addCovCounters(files[name], metas); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("no go coverage meta-data file: %w", ErrInvalidCovData)
+	}
+	profiles := make([][]*cover.Profile, 0, len(hashes))
+	for _, h := range hashes {
+		profiles = append(profiles, metas[h].profiles())
+	}
+	return cov.MergeMultipleProfiles(profiles)
+}
+
+// profiles returns the blocks of the meta-data as profiles, one per file
+func (m *covMeta) profiles() []*cover.Profile {
+	files := make(map[string]*cover.Profile)
+	for _, pkg := range m.pkgs {
+		for _, fn := range pkg.funcs {
+			p, ok := files[fn.file]
+			if !ok {
+				p = &cover.Profile{FileName: fn.file, Mode: m.mode}
+				files[fn.file] = p
+			}
+			p.Blocks = append(p.Blocks, fn.units...)
+		}
+	}
+	profiles := make([]*cover.Profile, 0, len(files))
+	for _, p := range files {
+		sort.Slice(p.Blocks, func(i, j int) bool {
+			bi, bj := p.Blocks[i], p.Blocks[j]
+			return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+		})
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles
+}
+
+// covReader reads the little endian and ULEB128 encoded values of go coverage data
+type covReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *covReader) fail() {
+	if r.err == nil {
+		r.err = fmt.Errorf("truncated data at offset %d: %w", r.off, ErrInvalidCovData)
+	}
+}
+
+func (r *covReader) uleb() uint64 {
+	var v uint64
+	var shift uint
+	for {
+		if r.off >= len(r.b) || shift > 63 {
+			r.fail()
+			return 0
+		}
+		c := r.b[r.off]
+		r.off++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+		shift += 7
+	}
+}
+
+func (r *covReader) uint32(order binary.ByteOrder) uint32 {
+	if r.off+4 > len(r.b) {
+		r.fail()
+		return 0
+	}
+	v := order.Uint32(r.b[r.off:])
+	r.off += 4
+	return v
+}
+
+func (r *covReader) uint64() uint64 {
+	if r.off+8 > len(r.b) {
+		r.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.b[r.off:])
+	r.off += 8
+	return v
+}
+
+func (r *covReader) bytes(n uint64) []byte {
+	if r.off > len(r.b) || n > uint64(len(r.b)-r.off) {
+		r.fail()
+		return nil
+	}
+	b := r.b[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b
+}
+
+// header reads a fixed size header
+func (r *covReader) header(v interface{}) {
+	n := binary.Size(v)
+	if r.off+n > len(r.b) {
+		r.fail()
+		return
+	}
+	if err := binary.Read(bytes.NewReader(r.b[r.off:r.off+n]), binary.LittleEndian, v); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.off += n
+}
+
+func (r *covReader) strings() []string {
+	n := r.uleb()
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return nil
+	}
+	strs := make([]string, 0, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		strs = append(strs, string(r.bytes(r.uleb())))
+	}
+	return strs
+}
+
+func decodeCovMeta(data []byte) (*covMeta, error) {
+	r := &covReader{b: data}
+	var hdr covMetaFileHeader
+	r.header(&hdr)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if hdr.Magic != covMetaMagic || hdr.Version > covFileVersion {
+		return nil, fmt.Errorf("not a meta-data file: %w", ErrInvalidCovData)
+	}
+	m := &covMeta{hash: hdr.MetaFileHash, perFunc: hdr.CGranularity == covGranularityPerFunc}
+	switch hdr.CMode {
+	case covModeSet:
+		m.mode = "set"
+	case covModeCount:
+		m.mode = "count"
+	case covModeAtomic:
+		m.mode = "atomic"
+	default:
+		return nil, fmt.Errorf("unknown counter mode %d: %w", hdr.CMode, ErrInvalidCovData)
+	}
+	if hdr.Entries > uint64(len(data)) {
+		return nil, fmt.Errorf("%d packages: %w", hdr.Entries, ErrInvalidCovData)
+	}
+
+	offsets := make([]uint64, hdr.Entries)
+	for i := range offsets {
+		offsets[i] = r.uint64()
+	}
+	lengths := make([]uint64, hdr.Entries)
+	for i := range lengths {
+		lengths[i] = r.uint64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range offsets {
+		if offsets[i] > uint64(len(data)) || lengths[i] > uint64(len(data))-offsets[i] {
+			return nil, fmt.Errorf("package %d out of the file: %w", i, ErrInvalidCovData)
+		}
+		pkg, err := decodeCovPackage(data[offsets[i] : offsets[i]+lengths[i]])
+		if err != nil {
+			return nil, err
+		}
+		m.pkgs = append(m.pkgs, *pkg)
+	}
+	return m, nil
+}
+
+func decodeCovPackage(blob []byte) (*covPackage, error) {
+	r := &covReader{b: blob}
+	var hdr covMetaSymbolHeader
+	r.header(&hdr)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if uint64(hdr.NumFuncs)*4 > uint64(len(blob)) {
+		return nil, fmt.Errorf("%d functions: %w", hdr.NumFuncs, ErrInvalidCovData)
+	}
+	funcOffsets := make([]uint32, hdr.NumFuncs)
+	for i := range funcOffsets {
+		funcOffsets[i] = r.uint32(binary.LittleEndian)
+	}
+	strs := r.strings()
+	if r.err != nil {
+		return nil, r.err
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			r.err = fmt.Errorf("string %d out of the table: %w", i, ErrInvalidCovData)
+			return ""
+		}
+		return strs[i]
+	}
+
+	pkg := &covPackage{path: str(uint64(hdr.PkgPath))}
+	for _, off := range funcOffsets {
+		r.off = int(off)
+		numUnits := r.uleb()
+		if numUnits > uint64(len(blob)) {
+			r.fail()
+		}
+		fn := covFunc{name: str(r.uleb()), file: str(r.uleb())}
+		for k := uint64(0); k < numUnits && r.err == nil; k++ {
+			fn.units = append(fn.units, cover.ProfileBlock{
+				StartLine: int(r.uleb()),
+				StartCol:  int(r.uleb()),
+				EndLine:   int(r.uleb()),
+				EndCol:    int(r.uleb()),
+				NumStmt:   int(r.uleb()),
+			})
+		}
+		r.uleb() // function literal flag
+		if r.err != nil {
+			return nil, r.err
+		}
+		pkg.funcs = append(pkg.funcs, fn)
+	}
+	return pkg, nil
+}
+
+// addCovCounters adds the counters of a counter data file to the units of its meta-data
+func addCovCounters(data []byte, metas map[[16]byte]*covMeta) error {
+	r := &covReader{b: data}
+	var hdr covCounterFileHeader
+	r.header(&hdr)
+	var ftr covCounterFileFooter
+	footerSize := binary.Size(ftr)
+	if r.err == nil && len(data) >= footerSize {
+		(&covReader{b: data, off: len(data) - footerSize}).header(&ftr)
+	}
+	if r.err != nil || hdr.Magic != covCounterMagic || ftr.Magic != covCounterMagic || hdr.Version > covFileVersion {
+		return fmt.Errorf("not a counter data file: %w", ErrInvalidCovData)
+	}
+	m, ok := metas[hdr.MetaHash]
+	if !ok {
+		return fmt.Errorf("no meta-data file covmeta.%x for the counters: %w", hdr.MetaHash, ErrInvalidCovData)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if hdr.BigEndian {
+		order = binary.BigEndian
+	}
+	value := func() uint32 {
+		if hdr.CFlavor == covFlavorULeb128 {
+			return uint32(r.uleb())
+		}
+		return r.uint32(order)
+	}
+	if hdr.CFlavor != covFlavorRaw && hdr.CFlavor != covFlavorULeb128 {
+		return fmt.Errorf("unknown counter flavor %d: %w", hdr.CFlavor, ErrInvalidCovData)
+	}
+
+	for seg := uint32(0); seg < ftr.NumSegments; seg++ {
+		if seg > 0 {
+			r.off += footerSize
+		}
+		var shdr covCounterSegmentHeader
+		r.header(&shdr)
+		r.bytes(uint64(shdr.StrTabLen) + uint64(shdr.ArgsLen))
+		if rem := r.off % 4; rem != 0 {
+			r.bytes(uint64(4 - rem))
+		}
+		if r.err != nil {
+			return r.err
+		}
+		for i := uint64(0); i < shdr.FcnEntries; i++ {
+			n, pkgIdx, funcIdx := value(), value(), value()
+			if r.err != nil {
+				return r.err
+			}
+			if int(pkgIdx) >= len(m.pkgs) || int(funcIdx) >= len(m.pkgs[pkgIdx].funcs) || uint64(n) > uint64(len(data)) {
+				return fmt.Errorf("counters of an unknown function: %w", ErrInvalidCovData)
+			}
+			units := m.pkgs[pkgIdx].funcs[funcIdx].units
+			for k := uint32(0); k < n; k++ {
+				v := int(value())
+				if m.perFunc {
+					// one counter for all the units of the function
+					for u := range units {
+						addCovCount(&units[u], v, m.mode)
+					}
+				} else if int(k) < len(units) {
+					addCovCount(&units[k], v, m.mode)
+				}
+			}
+			if r.err != nil {
+				return r.err
+			}
+		}
+	}
+	return nil
+}
+
+func addCovCount(b *cover.ProfileBlock, v int, mode string) {
+	if mode == "set" {
+		if v > 0 {
+			b.Count = 1
+		}
+		return
+	}
+	b.Count += v
+}
+
+// WriteCovDir writes the profiles to dir as go coverage data, a meta-data file and
+// a counter data file, which go tool covdata reads. Profiles know nothing of functions,
+// every file is written as a single function named after it.
+func WriteCovDir(dir string, profiles []*cover.Profile) error {
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profiles to write")
+	}
+	var mode uint8
+	switch profiles[0].Mode {
+	case "set":
+		mode = covModeSet
+	case "count":
+		mode = covModeCount
+	default:
+		mode = covModeAtomic
+	}
+
+	sorted := append([]*cover.Profile{}, profiles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+	var pkgs [][]*cover.Profile
+	for i, p := range sorted {
+		if i == 0 || path.Dir(p.FileName) != path.Dir(sorted[i-1].FileName) {
+			pkgs = append(pkgs, nil)
+		}
+		pkgs[len(pkgs)-1] = append(pkgs[len(pkgs)-1], p)
+	}
+
+	var (
+		blobs     [][]byte
+		fileHash  = fnv.New128a()
+		counters  bytes.Buffer
+		numFuncs  uint64
+		pkgHashes [][16]byte
+	)
+	for pkgIdx, files := range pkgs {
+		blob, pkgHash := encodeCovPackage(files)
+		blobs = append(blobs, blob)
+		pkgHashes = append(pkgHashes, pkgHash)
+		for funcIdx, p := range files {
+			numFuncs++
+			writeUint32s(&counters, uint32(len(p.Blocks)), uint32(pkgIdx), uint32(funcIdx))
+			for _, b := range p.Blocks {
+				count := int64(b.Count)
+				if count > math.MaxUint32 {
+					count = math.MaxUint32
+				}
+				writeUint32s(&counters, uint32(count))
+			}
+		}
+	}
+	for _, h := range pkgHashes {
+		fileHash.Write(h[:])
+	}
+	io.WriteString(fileHash, profiles[0].Mode)
+	io.WriteString(fileHash, "perblock")
+	var hash [16]byte
+	copy(hash[:], fileHash.Sum(nil))
+
+	// the meta-data file: header, package offsets and lengths, an empty string table, packages
+	var meta bytes.Buffer
+	headerSize := uint64(binary.Size(covMetaFileHeader{}))
+	strtab := encodeCovStrings([]string{""})
+	preamble := headerSize + uint64(16*len(blobs)) + uint64(len(strtab))
+	total := preamble
+	for _, blob := range blobs {
+		total += uint64(len(blob))
+	}
+	binary.Write(&meta, binary.LittleEndian, covMetaFileHeader{
+		Magic:        covMetaMagic,
+		Version:      covFileVersion,
+		TotalLength:  total,
+		Entries:      uint64(len(blobs)),
+		MetaFileHash: hash,
+		StrTabOffset: uint32(headerSize + uint64(16*len(blobs))),
+		StrTabLength: uint32(len(strtab)),
+		CMode:        mode,
+		CGranularity: covGranularityPerBlock,
+	})
+	off := preamble
+	for _, blob := range blobs {
+		binary.Write(&meta, binary.LittleEndian, off)
+		off += uint64(len(blob))
+	}
+	for _, blob := range blobs {
+		binary.Write(&meta, binary.LittleEndian, uint64(len(blob)))
+	}
+	meta.Write(strtab)
+	for _, blob := range blobs {
+		meta.Write(blob)
+	}
+
+	// the counter data file: header, a segment without arguments, footer
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, covCounterFileHeader{
+		Magic:    covCounterMagic,
+		Version:  covFileVersion,
+		MetaHash: hash,
+		CFlavor:  covFlavorRaw,
+	})
+	segStrtab := encodeCovStrings([]string{""})
+	args := []byte{0} // no arguments
+	argsLen := len(args)
+	if pad := (len(segStrtab) + len(args)) % 4; pad != 0 {
+		argsLen += 4 - pad
+	}
+	binary.Write(&data, binary.LittleEndian, covCounterSegmentHeader{
+		FcnEntries: numFuncs,
+		StrTabLen:  uint32(len(segStrtab)),
+		ArgsLen:    uint32(argsLen),
+	})
+	data.Write(segStrtab)
+	data.Write(args)
+	data.Write(make([]byte, argsLen-len(args)))
+	data.Write(counters.Bytes())
+	binary.Write(&data, binary.LittleEndian, covCounterFileFooter{Magic: covCounterMagic, NumSegments: 1})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s.%x", covMetaPrefix, hash)), meta.Bytes(), 0644); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.%x.%d.%d", covCountersPrefix, hash, os.Getpid(), time.Now().UnixNano())
+	return ioutil.WriteFile(filepath.Join(dir, name), data.Bytes(), 0644)
+}
+
+// encodeCovPackage encodes the files of a package as its meta-data, with its hash
+func encodeCovPackage(files []*cover.Profile) ([]byte, [16]byte) {
+	pkgPath := path.Dir(files[0].FileName)
+	strs := []string{"", pkgPath, path.Base(pkgPath)}
+	index := map[string]uint64{"": 0, pkgPath: 1, path.Base(pkgPath): 2}
+	lookup := func(s string) uint64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+
+	h := fnv.New128a()
+	io.WriteString(h, pkgPath)
+	io.WriteString(h, path.Base(pkgPath))
+	io.WriteString(h, "") // module path
+	var funcs [][]byte
+	for _, p := range files {
+		name := path.Base(p.FileName)
+		io.WriteString(h, name)
+		io.WriteString(h, p.FileName)
+		fn := appendULEB(nil, uint64(len(p.Blocks)))
+		fn = appendULEB(fn, lookup(name))
+		fn = appendULEB(fn, lookup(p.FileName))
+		for _, b := range p.Blocks {
+			for _, v := range []int{b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt} {
+				fn = appendULEB(fn, uint64(v))
+				hashUint32(h, uint32(v))
+			}
+		}
+		fn = appendULEB(fn, 0) // not a function literal
+		hashUint32(h, 0)
+		funcs = append(funcs, fn)
+	}
+	var hash [16]byte
+	copy(hash[:], h.Sum(nil))
+
+	strtab := encodeCovStrings(strs)
+	headerSize := binary.Size(covMetaSymbolHeader{})
+	length := headerSize + 4*len(funcs) + len(strtab)
+	for _, fn := range funcs {
+		length += len(fn)
+	}
+	var blob bytes.Buffer
+	binary.Write(&blob, binary.LittleEndian, covMetaSymbolHeader{
+		Length:   uint32(length),
+		PkgName:  2,
+		PkgPath:  1,
+		MetaHash: hash,
+		NumFiles: uint32(len(strs)),
+		NumFuncs: uint32(len(funcs)),
+	})
+	off := headerSize + 4*len(funcs) + len(strtab)
+	for _, fn := range funcs {
+		writeUint32s(&blob, uint32(off))
+		off += len(fn)
+	}
+	blob.Write(strtab)
+	for _, fn := range funcs {
+		blob.Write(fn)
+	}
+	return blob.Bytes(), hash
+}
+
+func encodeCovStrings(strs []string) []byte {
+	b := appendULEB(nil, uint64(len(strs)))
+	for _, s := range strs {
+		b = appendULEB(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if c&0x80 == 0 {
+			return b
+		}
+	}
+}
+
+func writeUint32s(w *bytes.Buffer, vs ...uint32) {
+	var b [4]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint32(b[:], v)
+		w.Write(b[:])
+	}
+}
+
+func hashUint32(h hash.Hash, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	h.Write(b[:])
+}
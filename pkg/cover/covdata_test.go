@@ -0,0 +1,225 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+const covDataSamples = "../../tests/samples/covdata_samples"
+
+// the sample binary ran twice, the counters of both runs add up
+const covDataSamplesProfile = "mode: count\n" +
+	"example.com/cvd/lib/lib.go:7.2,7.11 1 1\n" +
+	"example.com/cvd/lib/lib.go:8.3,9.1 1 0\n" +
+	"example.com/cvd/lib/lib.go:10.2,10.14 1 1\n" +
+	"example.com/cvd/lib/lib.go:15.2,16.9 2 6\n" +
+	"example.com/cvd/lib/lib.go:17.3,18.1 1 6\n" +
+	"example.com/cvd/main.go:11.2,12.11 2 2\n" +
+	"example.com/cvd/main.go:13.3,14.1 1 1\n" +
+	"example.com/cvd/main.go:15.3,16.1 1 1\n" +
+	"example.com/cvd/main.go:17.2,17.25 1 2\n" +
+	"example.com/cvd/main.go:18.3,19.1 1 6\n"
+
+func TestReadCovDir(t *testing.T) {
+	profiles, err := ReadCovDir(covDataSamples)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, cov.DumpProfile(profiles, &buf))
+	assert.Equal(t, covDataSamplesProfile, buf.String())
+
+	dir, err := ioutil.TempDir("", "goc-covdata")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = ReadCovDir(dir)
+	assert.Error(t, err)
+}
+
+func TestReadCovFilesInvalid(t *testing.T) {
+	files := readCovDataSamples(t)
+
+	// counters can't be read without the meta-data of their binary
+	counters := make(map[string][]byte)
+	for name, data := range files {
+		if strings.HasPrefix(name, covCountersPrefix) {
+			counters[name] = data
+		}
+	}
+	_, err := ReadCovFiles(counters)
+	assert.True(t, errors.Is(err, ErrInvalidCovData))
+
+	for name, data := range files {
+		files[name] = data[:len(data)/2]
+	}
+	_, err = ReadCovFiles(files)
+	assert.True(t, errors.Is(err, ErrInvalidCovData))
+}
+
+func TestWriteCovDir(t *testing.T) {
+	profiles, err := ReadCovDir(covDataSamples)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "goc-covdata")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, WriteCovDir(dir, profiles))
+	written, err := ReadCovDir(dir)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, cov.DumpProfile(written, &buf))
+	assert.Equal(t, covDataSamplesProfile, buf.String())
+}
+
+func TestUpload(t *testing.T) {
+	server := &server{Store: NewMemoryStore()}
+	router := server.Route(os.Stdout)
+
+	upload := func(name string, files map[string][]byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for filename, data := range files {
+			part, _ := mw.CreateFormFile("file", filename)
+			part.Write(data)
+		}
+		mw.Close()
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", CoverUploadAPI+"?name="+name, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := upload("e2e", readCovDataSamples(t))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// a goc profile of the same sources merges with the native coverage data
+	w = upload("e2e", map[string][]byte{"a.cov": []byte("mode: count\n" +
+		"example.com/cvd/lib/lib.go:7.2,7.11 1 1\n" +
+		"example.com/cvd/lib/lib.go:8.3,9.1 1 4\n" +
+		"example.com/cvd/lib/lib.go:10.2,10.14 1 0\n" +
+		"example.com/cvd/lib/lib.go:15.2,16.9 2 0\n" +
+		"example.com/cvd/lib/lib.go:17.3,18.1 1 0\n")})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = upload("e2e", map[string][]byte{"b.cov": []byte("mode: count\nexample.com/cvd/lib/lib.go:8.3,9.2 1 4\n")})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = upload("", map[string][]byte{"a.cov": []byte("mode: count\n")})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// /v1 lists the agents only, /v2 the uploads too
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", CoverServicesListAPI, nil)
+	router.ServeHTTP(w, req)
+	assert.JSONEq(t, `{}`, w.Body.String())
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v2/cover/list", nil)
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `{"name":"e2e","addresses":["upload://e2e"]}`)
+
+	// and selects them if asked for
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", CoverProfileAPI+"?service=e2e", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", CoverProfileAPI+"?service=e2e&uploads=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "example.com/cvd/lib/lib.go:7.2,7.11 1 2\n")
+	assert.Contains(t, w.Body.String(), "example.com/cvd/lib/lib.go:8.3,9.1 1 4\n")
+	assert.Contains(t, w.Body.String(), "example.com/cvd/main.go:18.3,19.1 1 6\n")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", CoverProfileClearAPI, bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"e2e"}, server.uploads.names())
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", CoverProfileClearAPI, bytes.NewReader([]byte(`{"service":["e2e"],"uploads":true}`)))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, server.uploads.names())
+}
+
+func TestClientUpload(t *testing.T) {
+	server := &server{Store: NewMemoryStore()}
+	ts := httptest.NewServer(server.Route(os.Stdout))
+	defer ts.Close()
+
+	_, err := NewWorker(ts.URL).Upload("e2e", readCovDataSamples(t))
+	assert.NoError(t, err)
+	profiles, ok := server.uploads.get("e2e")
+	assert.True(t, ok)
+	assert.Len(t, profiles, 2)
+
+	_, err = NewWorker(ts.URL).Upload("e2e", map[string][]byte{"covmeta.x": []byte("garbage")})
+	assert.Error(t, err)
+	_, err = NewWorker(ts.URL).Upload(" ", nil)
+	assert.Error(t, err)
+}
+
+func TestUploadPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-uploads")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "_svrs_address.txt")
+
+	server, err := NewFileBasedServer(store)
+	assert.NoError(t, err)
+	profiles, err := ReadCovDir(covDataSamples)
+	assert.NoError(t, err)
+	assert.NoError(t, server.uploads.add("e2e/a", profiles))
+
+	// the uploads outlive a restart
+	server, err = NewFileBasedServer(store)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e2e/a"}, server.uploads.names())
+	loaded, ok := server.uploads.get("e2e/a")
+	assert.True(t, ok)
+	var buf bytes.Buffer
+	assert.NoError(t, cov.DumpProfile(loaded, &buf))
+	assert.Equal(t, covDataSamplesProfile, buf.String())
+
+	server.uploads.remove("e2e/a")
+	server, err = NewFileBasedServer(store)
+	assert.NoError(t, err)
+	assert.Empty(t, server.uploads.names())
+}
+
+func readCovDataSamples(t *testing.T) map[string][]byte {
+	infos, err := ioutil.ReadDir(covDataSamples)
+	assert.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(covDataSamples, info.Name()))
+		assert.NoError(t, err)
+		files[info.Name()] = data
+	}
+	return files
+}
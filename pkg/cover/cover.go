@@ -19,6 +19,7 @@ package cover
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
@@ -34,10 +35,7 @@ import (
 	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/qiniu/goc/pkg/cover/internal/tool"
-	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -53,10 +51,17 @@ type TestCover struct {
 	AgentPort                string
 	Center                   string // cover profile host center
 	Singleton                bool
+	Branch                   bool // branch counters are injected too
 	MainPkgCover             *PackageCover
 	DepsCover                []*PackageCover
 	CacheCover               map[string]*PackageCover
 	GlobalCoverVarImportPath string
+	UnlinkedProfile          string   // zero count profile of the packages no binary links
+	Native                   bool     // built with the toolchain's -cover, the counters are read with runtime/coverage
+	NativeSkip               []string // files the toolchain instruments but the profile leaves out
+	ManifestHash             string   // hash of the build manifest, reported by the agent
+	BuildMode                string   // the -buildmode of the build, empty for an executable
+	Library                  string   // the service name of a library build, loaded by processes of other names
 }
 
 // PackageCover holds all the generate coverage variables of a package
@@ -69,6 +74,10 @@ type PackageCover struct {
 type FileVar struct {
 	File string
 	Var  string
+
+	// set as the file is annotated, for the manifest
+	SHA256 string // of the source, before annotation
+	Blocks int
 }
 
 // Package map a package output by go list
@@ -141,10 +150,67 @@ type CoverInfo struct {
 	AgentPort                string
 	Center                   string
 	Singleton                bool
+	IncludeGenerated         bool     // instrument generated files too
+	SkipPackages             []string // patterns of the import paths of the packages left without counters
+	VendorPackages           []string // patterns of the import paths of the vendored packages instrumented all the same
+	Parallel                 int      // number of files instrumented at the same time, GOMAXPROCS if 0
+	CacheDir                 string   // directory of the instrument cache, no cache if empty
+	Unlinked                 bool     // report the packages no main package links with zero counts
+	Backend                  string   // BackendGoc, the default, or BackendNative
+	SourceDir                string   // the project Target is a copy of, for its VCS revision, Target if empty
+	GocVersion               string   // recorded in the manifest
+
+	Manifest   *Manifest        // filled by Execute
+	Stats      *InstrumentStats // filled by Execute
+	BuildFlags string           // filled by Execute, the flags the build of the target needs on top of Args
+	Written    []string         // filled by Execute, the files of the target it annotated or added, before writing them
+}
+
+// httpCoverApisFile is the file of the agent goc injects into every main package
+const httpCoverApisFile = "http_cover_apis_auto_generated.go"
+
+// httpCoverExportFile is the file exporting GocDumpProfile from a library
+const httpCoverExportFile = "http_cover_apis_auto_generated_export.go"
+
+// libraryBuildModes are the build modes whose output is loaded by other processes
+var libraryBuildModes = map[string]bool{"c-shared": true, "c-archive": true, "plugin": true}
+
+// libraryBuild sets the build mode of the agent of main package pkg, and names the library after it
+func (tc *TestCover) libraryBuild(flags []string, pkg *Package) {
+	tc.BuildMode, _ = BuildFlagValue(flags, "buildmode")
+	if libraryBuildModes[tc.BuildMode] {
+		tc.Library = path.Base(pkg.ImportPath)
+	}
+}
+
+// injectAgent writes the agent of main package pkg, and the export of a library
+func injectAgent(tc TestCover, pkg *Package) error {
+	if err := InjectCountersHandlers(tc, filepath.Join(pkg.Dir, httpCoverApisFile)); err != nil {
+		return err
+	}
+	if tc.Library == "" {
+		return nil
+	}
+	return InjectExport(tc, filepath.Join(pkg.Dir, httpCoverExportFile))
+}
+
+// agentFiles returns the files injectAgent writes
+func agentFiles(tc TestCover, pkg *Package) []string {
+	files := []string{filepath.Join(pkg.Dir, httpCoverApisFile)}
+	if tc.Library != "" {
+		files = append(files, filepath.Join(pkg.Dir, httpCoverExportFile))
+	}
+	return files
 }
 
 // Execute inject cover variables for all the .go files in the target folder
 func Execute(coverInfo *CoverInfo) error {
+	return ExecuteContext(context.Background(), coverInfo)
+}
+
+// ExecuteContext is Execute, it stops listing and instrumenting the packages once ctx is done
+// and returns the error of ctx
+func ExecuteContext(ctx context.Context, coverInfo *CoverInfo) error {
 	target := coverInfo.Target
 	newGopath := coverInfo.GoPath
 	// oneMainPackage := coverInfo.OneMainPackage
@@ -161,85 +227,216 @@ func Execute(coverInfo *CoverInfo) error {
 		globalCoverVarImportPath = filepath.Base(globalCoverVarImportPath)
 	}
 
+	if coverInfo.Backend != "" && coverInfo.Backend != BackendGoc && coverInfo.Backend != BackendNative {
+		return fmt.Errorf("%w: %s", ErrUnknownBackend, coverInfo.Backend)
+	}
 	if !isDirExist(target) {
-		log.Errorf("Target directory %s not exist", target)
-		return ErrCoverPkgFailed
+		return fmt.Errorf("%w: target directory %s not exist", ErrCoverPkgFailed, target)
 	}
-	listArgs := []string{"-json"}
-	if len(args) != 0 {
-		listArgs = append(listArgs, args)
+	flags, err := SplitBuildFlags(args)
+	if err != nil {
+		return err
 	}
-	listArgs = append(listArgs, "./...")
-	pkgs, err := ListPackages(target, strings.Join(listArgs, " "), newGopath)
+	listArgs := append(ListFlags(flags), "-json", "./...")
+	pkgs, err := ListPackagesContext(ctx, target, listArgs, newGopath)
 	if err != nil {
-		log.Errorf("Fail to list all packages, the error: %v", err)
 		return err
 	}
 
-	var seen = make(map[string]*PackageCover)
-	// var seenCache = make(map[string]*PackageCover)
-	allDecl := ""
-	for _, pkg := range pkgs {
-		if pkg.Name == "main" {
-			log.Printf("handle package: %v", pkg.ImportPath)
-			// inject the main package
-			mainCover, mainDecl := AddCounters(pkg, mode, globalCoverVarImportPath)
-			allDecl += mainDecl
-			// new a testcover for this service
-			tc := TestCover{
-				Mode:                     mode,
-				AgentPort:                agentPort,
-				Center:                   center,
-				Singleton:                singleton,
-				MainPkgCover:             mainCover,
-				GlobalCoverVarImportPath: globalCoverVarImportPath,
+	filter, err := newFileFilter(coverInfo.IncludeGenerated, coverInfo.SkipPackages, coverInfo.VendorPackages)
+	if err != nil {
+		return err
+	}
+	if err := addVendored(ctx, pkgs, target, flags, newGopath, filter); err != nil {
+		return err
+	}
+
+	mains, covers := selectCovers(pkgs, filter)
+
+	manifest, err := newManifest(coverInfo, covers, filter)
+	if err != nil {
+		return err
+	}
+	if coverInfo.Backend == BackendNative {
+		if err := manifest.scan(covers, false); err != nil {
+			return err
+		}
+		return executeNative(coverInfo, manifest, pkgs, mains, covers)
+	}
+
+	coverInfo.Written = annotatedFiles(covers)
+	allDecl, stats, err := annotatePackages(ctx, covers, mode, globalCoverVarImportPath, coverInfo.Parallel, NewInstrumentCache(coverInfo.CacheDir))
+	if err != nil {
+		return err
+	}
+	coverInfo.Stats = stats
+	log.Infoln(stats)
+
+	manifest.setVars(covers)
+	if coverInfo.Unlinked {
+		if err := addUnlinked(manifest, coverInfo, pkgs, covers); err != nil {
+			return err
+		}
+	}
+	if manifest.Hash, err = manifest.ComputeHash(); err != nil {
+		return err
+	}
+
+	for _, pkg := range mains {
+		// new a testcover for this service
+		tc := TestCover{
+			Mode:                     profileMode(mode),
+			Branch:                   mode == tool.BranchMode,
+			AgentPort:                agentPort,
+			Center:                   center,
+			Singleton:                singleton,
+			MainPkgCover:             covers[pkg.ImportPath],
+			GlobalCoverVarImportPath: globalCoverVarImportPath,
+			UnlinkedProfile:          manifest.UnlinkedProfile,
+			ManifestHash:             manifest.Hash,
+		}
+		tc.libraryBuild(flags, pkg)
+
+		// handle its dependency
+		tc.CacheCover = make(map[string]*PackageCover)
+		for _, dep := range pkg.Deps {
+			if packageCover, ok := covers[dep]; ok {
+				tc.DepsCover = append(tc.DepsCover, packageCover)
 			}
+		}
+
+		// inject Http Cover APIs
+		coverInfo.Written = append(coverInfo.Written, agentFiles(tc, pkg)...)
+		if err := injectAgent(tc, pkg); err != nil {
+			return fmt.Errorf("%w: failed to inject counters for package: %s, err: %v", ErrCoverPkgFailed, pkg.ImportPath, err)
+		}
+	}
 
-			// handle its dependency
-			// var internalPkgCache = make(map[string][]*PackageCover)
-			tc.CacheCover = make(map[string]*PackageCover)
-			for _, dep := range pkg.Deps {
-				if packageCover, ok := seen[dep]; ok {
-					tc.DepsCover = append(tc.DepsCover, packageCover)
-					continue
-				}
-
-				//only focus package neither standard Go library nor dependency library
-				if depPkg, ok := pkgs[dep]; ok {
-					packageCover, depDecl := AddCounters(depPkg, mode, globalCoverVarImportPath)
-					allDecl += depDecl
-					tc.DepsCover = append(tc.DepsCover, packageCover)
-					seen[dep] = packageCover
-				}
+	coverInfo.Manifest = manifest
+	if n := len(coverInfo.Manifest.Skipped); n > 0 {
+		log.Infof("%d generated, vendored or skipped files are not instrumented", n)
+	}
+	return injectGlobalCoverVarFile(coverInfo, allDecl)
+}
+
+// selectCovers returns the main packages and the packages of the project they depend on, each instrumented once
+func selectCovers(pkgs map[string]*Package, filter *fileFilter) ([]*Package, map[string]*PackageCover) {
+	var (
+		mains  []*Package
+		covers = make(map[string]*PackageCover)
+	)
+	for _, pkg := range pkgs {
+		if pkg.Name != "main" {
+			continue
+		}
+		log.Printf("handle package: %v", pkg.ImportPath)
+		mains = append(mains, pkg)
+		covers[pkg.ImportPath] = newPackageCover(filter.filter(pkg))
+		for _, dep := range pkg.Deps {
+			if _, ok := covers[dep]; ok {
+				continue
 			}
+			//only focus package neither standard Go library nor dependency library
+			if depPkg, ok := pkgs[dep]; ok {
+				covers[dep] = newPackageCover(filter.filter(depPkg))
+			}
+		}
+	}
+	return mains, covers
+}
 
-			// inject Http Cover APIs
-			var httpCoverApis = fmt.Sprintf("%s/http_cover_apis_auto_generated.go", pkg.Dir)
-			if err := InjectCountersHandlers(tc, httpCoverApis); err != nil {
-				log.Errorf("failed to inject counters for package: %s, err: %v", pkg.ImportPath, err)
-				return ErrCoverPkgFailed
+// addVendored adds to pkgs the vendored packages the main packages depend on and the filter instruments
+func addVendored(ctx context.Context, pkgs map[string]*Package, dir string, flags []string, gopath string, filter *fileFilter) error {
+	if len(filter.vendorPackages) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var deps []string
+	for _, pkg := range pkgs {
+		if pkg.Name != "main" {
+			continue
+		}
+		for _, dep := range pkg.Deps {
+			if _, ok := pkgs[dep]; ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if filter.instrumentVendored(dep) {
+				deps = append(deps, dep)
 			}
 		}
 	}
+	if len(deps) == 0 {
+		return nil
+	}
+	sort.Strings(deps)
+	listArgs := append(append(ListFlags(flags), "-json"), deps...)
+	vendored, err := ListPackagesContext(ctx, dir, listArgs, gopath)
+	if err != nil {
+		return err
+	}
+	// the standard library vendors packages too
+	for importPath, pkg := range vendored {
+		if isVendoredPackage(pkg) && !pkg.Standard {
+			log.Infof("instrument vendored package: %v", importPath)
+			pkgs[importPath] = pkg
+		}
+	}
+	return nil
+}
 
-	return injectGlobalCoverVarFile(coverInfo, allDecl)
+// addUnlinked records the packages no main package links, and their zero count profile
+func addUnlinked(manifest *Manifest, coverInfo *CoverInfo, pkgs map[string]*Package, covers map[string]*PackageCover) error {
+	// a filter of their own, the files skipped here are not reported as left without counters
+	filter, err := newFileFilter(coverInfo.IncludeGenerated, coverInfo.SkipPackages, coverInfo.VendorPackages)
+	if err != nil {
+		return err
+	}
+	var unlinked []*Package
+	for _, pkg := range unlinkedPackages(pkgs, covers) {
+		if pkg = filter.filter(pkg); len(pkg.GoFiles)+len(pkg.CgoFiles) > 0 {
+			unlinked = append(unlinked, pkg)
+			manifest.Unlinked = append(manifest.Unlinked, pkg.ImportPath)
+		}
+	}
+	zero, err := ZeroProfiles(unlinked, profileMode(coverInfo.Mode))
+	if err != nil {
+		return err
+	}
+	if manifest.UnlinkedProfile, err = dumpZeroProfiles(zero); err != nil {
+		return err
+	}
+	log.Infof("%d packages are not linked into any binary, reported with zero counts", len(unlinked))
+	return nil
 }
 
-// ListPackages list all packages under specific via go list command
+// profileMode returns the mode written in profiles, branch mode counts blocks atomically
+func profileMode(mode string) string {
+	if mode == tool.BranchMode {
+		return "atomic"
+	}
+	return mode
+}
+
+// ListPackages list all packages under specific via go list command, args are the
+// arguments of go list, see ListFlags for the build flags it takes.
 // The argument newgopath is if you need to go list in a different GOPATH
-func ListPackages(dir string, args string, newgopath string) (map[string]*Package, error) {
-	cmd := exec.Command("/bin/bash", "-c", "go list "+args)
+func ListPackages(dir string, args []string, newgopath string) (map[string]*Package, error) {
+	return ListPackagesContext(context.Background(), dir, args, newgopath)
+}
+
+// ListPackagesContext is ListPackages, go list is killed if ctx is done before it completes
+func ListPackagesContext(ctx context.Context, dir string, args []string, newgopath string) (map[string]*Package, error) {
+	cmd := GoCommandContext(ctx, dir, newgopath, append([]string{"list"}, args...)...)
 	log.Printf("go list cmd is: %v", cmd.Args)
-	cmd.Dir = dir
-	if newgopath != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%v", newgopath))
-	}
 	var errbuf bytes.Buffer
 	cmd.Stderr = &errbuf
 	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		log.Errorf("excute `go list -json ./...` command failed, err: %v, stdout: %v, stderr: %v", err, string(out), errbuf.String())
-		return nil, ErrCoverListFailed
+		return nil, fmt.Errorf("%w: %v: %s", ErrCoverListFailed, err, strings.TrimSpace(errbuf.String()))
 	}
 	log.Infof("\n%v", errbuf.String())
 	dec := json.NewDecoder(bytes.NewReader(out))
@@ -250,12 +447,10 @@ func ListPackages(dir string, args string, newgopath string) (map[string]*Packag
 			if err == io.EOF {
 				break
 			}
-			log.Errorf("reading go list output: %v", err)
-			return nil, ErrCoverListFailed
+			return nil, fmt.Errorf("%w: reading go list output: %v", ErrCoverListFailed, err)
 		}
 		if pkg.Error != nil {
-			log.Errorf("list package %s failed with output: %v", pkg.ImportPath, pkg.Error)
-			return nil, ErrCoverPkgFailed
+			return nil, fmt.Errorf("%w: list package %s failed: %s", ErrCoverPkgFailed, pkg.ImportPath, pkg.Error.Err)
 		}
 
 		// for _, err := range pkg.DepsErrors {
@@ -271,18 +466,17 @@ func ListPackages(dir string, args string, newgopath string) (map[string]*Packag
 // 1. only inject covervar++ into source file
 // 2. no declarartions for these covervars
 // 3. return the declarations as string
-func AddCounters(pkg *Package, mode string, globalCoverVarImportPath string) (*PackageCover, string) {
-	coverVarMap := declareCoverVars(pkg)
-
-	decl := ""
-	for file, coverVar := range coverVarMap {
-		decl += "\n" + tool.Annotate(path.Join(pkg.Dir, file), mode, coverVar.Var, globalCoverVarImportPath) + "\n"
-	}
+func AddCounters(pkg *Package, mode string, globalCoverVarImportPath string) (*PackageCover, string, error) {
+	pc := newPackageCover(pkg)
+	decl, _, err := annotatePackages(context.Background(), map[string]*PackageCover{pkg.ImportPath: pc}, mode, globalCoverVarImportPath, 0, nil)
+	return pc, decl, err
+}
 
+func newPackageCover(pkg *Package) *PackageCover {
 	return &PackageCover{
 		Package: pkg,
-		Vars:    coverVarMap,
-	}, decl
+		Vars:    declareCoverVars(pkg),
+	}
 }
 
 func isDirExist(path string) bool {
@@ -341,6 +535,30 @@ func buildCoverCmd(file string, coverVar *FileVar, pkg *Package, mode, newgopath
 	return cmd
 }
 
+// instrumentedFiles returns the files of the packages which get counters, sorted
+func instrumentedFiles(covers map[string]*PackageCover) []string {
+	var files []string
+	for _, pc := range covers {
+		for _, file := range append(append([]string{}, pc.Package.GoFiles...), pc.Package.CgoFiles...) {
+			files = append(files, path.Join(pc.Package.ImportPath, file))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// annotatedFiles returns the paths of the files annotatePackages annotates in place
+func annotatedFiles(covers map[string]*PackageCover) []string {
+	var files []string
+	for _, pc := range covers {
+		for file := range pc.Vars {
+			files = append(files, filepath.Join(pc.Package.Dir, file))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
 // declareCoverVars attaches the required cover variables names
 // to the files, to be used when annotating the files.
 func declareCoverVars(p *Package) map[string]*FileVar {
@@ -424,6 +642,10 @@ type Coverage struct {
 	NCoveredStmts int
 	NAllStmts     int
 	LineCovLink   string
+
+	// branch arms, only profiles built with --mode=branch have them
+	NCoveredBranches int
+	NAllBranches     int
 }
 
 type codeBlock struct {
@@ -440,6 +662,9 @@ func CovList(f io.Reader) (g CoverageList, err error) {
 
 	for scanner.Scan() {
 		row := scanner.Text()
+		if row == BranchSectionHeader {
+			return g, g.addBranches(scanner)
+		}
 		blk, err := toBlock(row)
 		if err != nil {
 			return nil, err
@@ -449,11 +674,35 @@ func CovList(f io.Reader) (g CoverageList, err error) {
 	return
 }
 
+// addBranches adds the arms of the rest of the branch section to the files
+func (g CoverageList) addBranches(scanner *bufio.Scanner) error {
+	var section bytes.Buffer
+	for scanner.Scan() {
+		section.WriteString(scanner.Text() + "\n")
+	}
+	branches, err := ParseBranchProfiles(&section)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(g))
+	for i := range g {
+		index[g[i].FileName] = i
+	}
+	for _, b := range branches {
+		i, ok := index[b.FileName]
+		if !ok {
+			continue
+		}
+		g[i].NCoveredBranches, g[i].NAllBranches = b.Covered()
+	}
+	return nil
+}
+
 // ReadFileToCoverList coverts profile file to CoverageList struct
 func ReadFileToCoverList(path string) (g CoverageList, err error) {
 	f, err := ioutil.ReadFile(path)
 	if err != nil {
-		logrus.Errorf("Open file %s failed!", path)
+		log.Errorf("Open file %s failed!", path)
 		return nil, err
 	}
 	g, err = CovList(bytes.NewReader(f))
@@ -467,7 +716,7 @@ func NewCoverageList() CoverageList {
 }
 
 func newCoverage(name string) *Coverage {
-	return &Coverage{name, 0, 0, ""}
+	return &Coverage{FileName: name}
 }
 
 // convert a line in profile file to a codeBlock struct
@@ -538,6 +787,26 @@ func (g CoverageList) TotalRatio() (ratio float32, err error) {
 	return total.Ratio()
 }
 
+// HasBranches reports whether the list carries branch coverage
+func (g CoverageList) HasBranches() bool {
+	for _, c := range g {
+		if c.NAllBranches > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TotalBranchPercentage returns the total percentage of branch arms taken
+func (g CoverageList) TotalBranchPercentage() string {
+	var total Coverage
+	for _, c := range g {
+		total.NCoveredBranches += c.NCoveredBranches
+		total.NAllBranches += c.NAllBranches
+	}
+	return total.BranchPercentage()
+}
+
 // Map returns maps the file name to its coverage for faster retrieval
 // & membership check
 func (g CoverageList) Map() map[string]Coverage {
@@ -572,6 +841,14 @@ func (c *Coverage) Ratio() (ratio float32, err error) {
 	return
 }
 
+// BranchPercentage returns the percentage of branch arms taken
+func (c *Coverage) BranchPercentage() string {
+	if c.NAllBranches == 0 {
+		return "N/A"
+	}
+	return PercentStr(float32(c.NCoveredBranches) / float32(c.NAllBranches))
+}
+
 // PercentStr converts a fraction number to percentage string representation
 func PercentStr(f float32) string {
 	return fmt.Sprintf("%.1f%%", f*100)
@@ -17,7 +17,11 @@
 package cover
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -25,7 +29,7 @@ import (
 	"strings"
 	"testing"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tongjingran/copy"
@@ -185,7 +189,7 @@ func TestBuildCoverCmd(t *testing.T) {
 func lookCmdPath(name string) string {
 	if filepath.Base(name) == name {
 		if lp, err := exec.LookPath(name); err != nil {
-			log.Fatalf("find exec %s err: %v", name, err)
+			logrus.Fatalf("find exec %s err: %v", name, err)
 		} else {
 			return lp
 		}
@@ -339,6 +343,90 @@ func TestExecuteForSimpleModProject(t *testing.T) {
 	}
 }
 
+func TestExecuteForLibraryBuildModes(t *testing.T) {
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+
+	for mode, export := range map[string]string{
+		"plugin":    "func GocDumpProfile(w io.Writer) error",
+		"c-shared":  "//export GocDumpProfile",
+		"c-archive": "//export GocDumpProfile",
+	} {
+		testDir := filepath.Join(os.TempDir(), "goc-build-test-"+mode)
+		os.RemoveAll(testDir)
+		copy.Copy("../../tests/samples/simple_project", testDir)
+
+		bi := &CoverInfo{
+			Args:   "-buildmode=" + mode,
+			Target: testDir,
+			Mode:   "count",
+			Center: "http://127.0.0.1:7777",
+		}
+		assert.NoError(t, Execute(bi), mode)
+
+		agent, err := ioutil.ReadFile(filepath.Join(testDir, httpCoverApisFile))
+		assert.NoError(t, err)
+		// named after the main package, not after the process loading it
+		assert.Contains(t, string(agent), `const libraryGoc = "simple-project"`, mode)
+		content, err := ioutil.ReadFile(filepath.Join(testDir, httpCoverExportFile))
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), export, mode)
+		os.RemoveAll(testDir)
+	}
+
+	// an executable exports nothing
+	testDir := filepath.Join(os.TempDir(), "goc-build-test-exe")
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+	copy.Copy("../../tests/samples/simple_project", testDir)
+	ci := &CoverInfo{Target: testDir, Mode: "count"}
+	assert.NoError(t, Execute(ci))
+	assert.Equal(t, []string{filepath.Join(testDir, "main.go"), filepath.Join(testDir, httpCoverApisFile)}, ci.Written)
+	agent, err := ioutil.ReadFile(filepath.Join(testDir, httpCoverApisFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(agent), `const libraryGoc = ""`)
+	_, err = os.Stat(filepath.Join(testDir, httpCoverExportFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExecuteContextCanceled(t *testing.T) {
+	os.Setenv("GOPATH", "")
+	os.Setenv("GO111MODULE", "on")
+	testDir := filepath.Join(os.TempDir(), "goc-build-test-canceled")
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+	copy.Copy("../../tests/samples/simple_project", testDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := ExecuteContext(ctx, &CoverInfo{Target: testDir, Mode: "count"})
+	assert.True(t, errors.Is(err, context.Canceled), err)
+	_, err = os.Stat(filepath.Join(testDir, httpCoverApisFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	SetLogger(l)
+	defer SetLogger(logrus.StandardLogger())
+
+	_, err := ListPackages("../../tests/samples/simple_project", []string{"-json", "./..."}, "")
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "go list cmd is")
+
+	// nil discards the logs
+	SetLogger(nil)
+	buf.Reset()
+	_, err = ListPackages("../../tests/samples/simple_project", []string{"-json", "./..."}, "")
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	_, err = ListPackages("../../tests/samples/not_a_project", []string{"-json", "./..."}, "")
+	assert.True(t, errors.Is(err, ErrCoverListFailed), err)
+}
+
 func TestListPackagesForSimpleModProject(t *testing.T) {
 	workingDir := "../../tests/samples/simple_project"
 	gopath := ""
@@ -346,7 +434,7 @@ func TestListPackagesForSimpleModProject(t *testing.T) {
 	os.Setenv("GOPATH", gopath)
 	os.Setenv("GO111MODULE", "on")
 
-	pkgs, _ := ListPackages(workingDir, "-json ./...", "")
+	pkgs, _ := ListPackages(workingDir, []string{"-json", "./..."}, "")
 	if !assert.Equal(t, len(pkgs), 1) {
 		assert.FailNow(t, "should only have one pkg")
 	}
@@ -25,6 +25,10 @@ type DeltaCov struct {
 	NewPer      string
 	DeltaPer    string
 	LineCovLink string
+
+	// branch coverage, empty unless one of the profiles has a branch section
+	BaseBranchPer string
+	NewBranchPer  string
 }
 
 // DeltaCovList is the list of DeltaCov
@@ -34,33 +38,46 @@ type DeltaCovList []DeltaCov
 func GetFullDeltaCov(newList CoverageList, baseList CoverageList) (delta DeltaCovList) {
 	newMap := newList.Map()
 	baseMap := baseList.Map()
+	branches := newList.HasBranches() || baseList.HasBranches()
 
 	for file, n := range newMap {
 		b, ok := baseMap[file]
 		//if the file not in base profile, set None
 		if !ok {
-			delta = append(delta, DeltaCov{
+			d := DeltaCov{
 				FileName: file,
 				BasePer:  "None",
 				NewPer:   n.Percentage(),
-				DeltaPer: PercentStr(Delta(n, b))})
+				DeltaPer: PercentStr(Delta(n, b))}
+			if branches {
+				d.BaseBranchPer, d.NewBranchPer = "None", n.BranchPercentage()
+			}
+			delta = append(delta, d)
 			continue
 		}
-		delta = append(delta, DeltaCov{
+		d := DeltaCov{
 			FileName: file,
 			BasePer:  b.Percentage(),
 			NewPer:   n.Percentage(),
-			DeltaPer: PercentStr(Delta(n, b))})
+			DeltaPer: PercentStr(Delta(n, b))}
+		if branches {
+			d.BaseBranchPer, d.NewBranchPer = b.BranchPercentage(), n.BranchPercentage()
+		}
+		delta = append(delta, d)
 	}
 
 	for file, b := range baseMap {
 		//if the file not in new profile, set None
 		if n, ok := newMap[file]; !ok {
-			delta = append(delta, DeltaCov{
+			d := DeltaCov{
 				FileName: file,
 				BasePer:  b.Percentage(),
 				NewPer:   "None",
-				DeltaPer: PercentStr(Delta(n, b))})
+				DeltaPer: PercentStr(Delta(n, b))}
+			if branches {
+				d.BaseBranchPer, d.NewBranchPer = b.BranchPercentage(), "None"
+			}
+			delta = append(delta, d)
 		}
 	}
 	return
@@ -70,7 +87,7 @@ func GetFullDeltaCov(newList CoverageList, baseList CoverageList) (delta DeltaCo
 func GetDeltaCov(newList CoverageList, baseList CoverageList) (delta DeltaCovList) {
 	d := GetFullDeltaCov(newList, baseList)
 	for _, v := range d {
-		if v.DeltaPer == "0.0%" {
+		if v.DeltaPer == "0.0%" && v.BaseBranchPer == v.NewBranchPer {
 			continue
 		}
 		delta = append(delta, v)
@@ -0,0 +1,440 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+const (
+	// ProfileTextContentType is the media type of the classic text profile,
+	// the same format `go test -coverprofile` writes. It is the default.
+	ProfileTextContentType = "text/plain"
+	// ProfileCompactContentType is the media type of the compact binary profile.
+	// Clients opt in by listing it in the Accept header.
+	ProfileCompactContentType = "application/x-goc-profile"
+	// ProfileBuildIDHeader carries the build ID of a profile, the fingerprint
+	// of its instrumented blocks. A client that already holds the block table
+	// of a build sends its ID in this header and the agent answers with
+	// counters only.
+	ProfileBuildIDHeader = "X-Goc-Build-Id"
+	// ProfileManifestHashHeader carries the hash of the manifest of the build
+	// the profile comes from, see Manifest.Hash
+	ProfileManifestHashHeader = "X-Goc-Manifest-Hash"
+)
+
+// compact encoding layout, all integers are unsigned varints
+// and all strings are a varint length followed by the bytes:
+//
+//	"GOCP" version flags buildID mode
+//	[nfiles {name nblocks {startLine startCol endLine endCol numStmt}}]  if flags&flagBlockTable
+//	ncounters {count}
+//
+// The counters follow the block table order: files sorted by name, blocks in
+// the order they appear in the table.
+const (
+	compactMagic   = "GOCP"
+	compactVersion = 1
+
+	flagBlockTable = 1 << 0
+)
+
+var (
+	// ErrUnknownBuildID represents a compact profile that refers to a block table
+	// the decoder has never seen
+	ErrUnknownBuildID = errors.New("unknown build id, block table required")
+	// ErrBadCompactProfile represents a malformed compact profile
+	ErrBadCompactProfile = errors.New("malformed compact profile")
+)
+
+// blockTable is the block layout of one build, the part of a profile that
+// never changes while the binary runs.
+type blockTable struct {
+	mode  string
+	files []*cover.Profile // Count of every block is left zero
+}
+
+func (t *blockTable) numBlocks() int {
+	n := 0
+	for _, f := range t.files {
+		n += len(f.Blocks)
+	}
+	return n
+}
+
+// blockTableCache keeps the block tables the center has received, keyed by
+// build ID, so later pulls from the same build only transfer counter vectors.
+// A table is kept as long as an address still reports its build ID.
+// The zero value is ready to use.
+type blockTableCache struct {
+	mu      sync.RWMutex
+	tables  map[string]*blockTable
+	buildID map[string]string // service address -> last seen build ID
+}
+
+func (c *blockTableCache) get(buildID string) *blockTable {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tables[buildID]
+}
+
+func (c *blockTableCache) put(address, buildID string, t *blockTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tables == nil {
+		c.tables = make(map[string]*blockTable)
+		c.buildID = make(map[string]string)
+	}
+	old, ok := c.buildID[address]
+	c.tables[buildID] = t
+	c.buildID[address] = buildID
+	if ok && old != buildID {
+		c.drop(old)
+	}
+}
+
+// forget drops the build IDs of the addresses, and the tables no other address uses
+func (c *blockTableCache) forget(addresses ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, address := range addresses {
+		id, ok := c.buildID[address]
+		if !ok {
+			continue
+		}
+		delete(c.buildID, address)
+		c.drop(id)
+	}
+}
+
+// clear drops every table
+func (c *blockTableCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables = nil
+	c.buildID = nil
+}
+
+// drop removes the table of buildID unless an address still uses it, c.mu held
+func (c *blockTableCache) drop(buildID string) {
+	for _, id := range c.buildID {
+		if id == buildID {
+			return
+		}
+	}
+	delete(c.tables, buildID)
+}
+
+// knownBuildID returns the build ID whose block table is cached for the address
+func (c *blockTableCache) knownBuildID(address string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id := c.buildID[address]
+	if _, ok := c.tables[id]; !ok {
+		return ""
+	}
+	return id
+}
+
+// EncodeCompactProfile writes profiles in the compact encoding, always including the block table
+func EncodeCompactProfile(w io.Writer, profiles []*cover.Profile) error {
+	bw := bufio.NewWriter(w)
+	mode := ""
+	if len(profiles) > 0 {
+		mode = profiles[0].Mode
+	}
+
+	sorted := append([]*cover.Profile(nil), profiles...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+
+	bw.WriteString(compactMagic)
+	putUvarint(bw, compactVersion)
+	putUvarint(bw, flagBlockTable)
+	putString(bw, "")
+	putString(bw, mode)
+
+	putUvarint(bw, uint64(len(sorted)))
+	var n int
+	for _, p := range sorted {
+		putString(bw, p.FileName)
+		putUvarint(bw, uint64(len(p.Blocks)))
+		for _, b := range p.Blocks {
+			putUvarint(bw, uint64(b.StartLine))
+			putUvarint(bw, uint64(b.StartCol))
+			putUvarint(bw, uint64(b.EndLine))
+			putUvarint(bw, uint64(b.EndCol))
+			putUvarint(bw, uint64(b.NumStmt))
+		}
+		n += len(p.Blocks)
+	}
+
+	putUvarint(bw, uint64(n))
+	for _, p := range sorted {
+		for _, b := range p.Blocks {
+			putUvarint(bw, uint64(b.Count))
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeCompactProfile parses a self-contained compact profile, one that carries its block table
+func DecodeCompactProfile(r io.Reader) ([]*cover.Profile, error) {
+	profiles, _, _, err := decodeCompactProfile(r, nil)
+	return profiles, err
+}
+
+// decodeCompactProfile parses a compact profile. If the payload only carries
+// counters, the block table is looked up in the cache by build ID.
+// It returns the build ID and the block table used for decoding as well, so
+// the caller can cache a freshly received table.
+func decodeCompactProfile(r io.Reader, cache *blockTableCache) ([]*cover.Profile, string, *blockTable, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(compactMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != compactMagic {
+		return nil, "", nil, fmt.Errorf("%w: bad magic", ErrBadCompactProfile)
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	if version != compactVersion {
+		return nil, "", nil, fmt.Errorf("%w: unsupported version %d", ErrBadCompactProfile, version)
+	}
+	flags, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	buildID, err := readString(br)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mode, err := readString(br)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var table *blockTable
+	if flags&flagBlockTable != 0 {
+		if table, err = readBlockTable(br, mode); err != nil {
+			return nil, "", nil, err
+		}
+	} else {
+		if cache != nil && buildID != "" {
+			table = cache.get(buildID)
+		}
+		if table == nil {
+			return nil, buildID, nil, ErrUnknownBuildID
+		}
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	if int(n) != table.numBlocks() {
+		return nil, "", nil, fmt.Errorf("%w: %d counters for %d blocks", ErrBadCompactProfile, n, table.numBlocks())
+	}
+
+	files := make(map[string]*cover.Profile, len(table.files))
+	for _, f := range table.files {
+		p := files[f.FileName]
+		if p == nil {
+			p = &cover.Profile{FileName: f.FileName, Mode: table.mode}
+			files[f.FileName] = p
+		}
+		for _, b := range f.Blocks {
+			count, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+			}
+			b.Count = int(count)
+			p.Blocks = append(p.Blocks, b)
+		}
+	}
+
+	profiles, err := normalizeProfiles(files)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return profiles, buildID, table, nil
+}
+
+func readBlockTable(br *bufio.Reader, mode string) (*blockTable, error) {
+	nfiles, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	t := &blockTable{mode: mode}
+	for i := uint64(0); i < nfiles; i++ {
+		name, err := readString(br)
+		if err != nil {
+			return nil, err
+		}
+		nblocks, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+		}
+		p := &cover.Profile{FileName: name, Mode: mode}
+		for j := uint64(0); j < nblocks; j++ {
+			var v [5]uint64
+			for k := range v {
+				if v[k], err = binary.ReadUvarint(br); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+				}
+			}
+			p.Blocks = append(p.Blocks, cover.ProfileBlock{
+				StartLine: int(v[0]),
+				StartCol:  int(v[1]),
+				EndLine:   int(v[2]),
+				EndCol:    int(v[3]),
+				NumStmt:   int(v[4]),
+			})
+		}
+		t.files = append(t.files, p)
+	}
+	return t, nil
+}
+
+// normalizeProfiles sorts the blocks and the files and merges samples from the same location,
+// exactly like cover.ParseProfiles does for the text format
+func normalizeProfiles(files map[string]*cover.Profile) ([]*cover.Profile, error) {
+	profiles := make([]*cover.Profile, 0, len(files))
+	for _, p := range files {
+		sort.Slice(p.Blocks, func(i, j int) bool {
+			bi, bj := p.Blocks[i], p.Blocks[j]
+			return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+		})
+		j := 1
+		for i := 1; i < len(p.Blocks); i++ {
+			b := p.Blocks[i]
+			last := p.Blocks[j-1]
+			if b.StartLine == last.StartLine && b.StartCol == last.StartCol &&
+				b.EndLine == last.EndLine && b.EndCol == last.EndCol {
+				if b.NumStmt != last.NumStmt {
+					return nil, fmt.Errorf("inconsistent NumStmt: changed from %d to %d", last.NumStmt, b.NumStmt)
+				}
+				if p.Mode == "set" {
+					p.Blocks[j-1].Count |= b.Count
+				} else {
+					p.Blocks[j-1].Count += b.Count
+				}
+				continue
+			}
+			p.Blocks[j] = b
+			j++
+		}
+		if len(p.Blocks) > 0 {
+			p.Blocks = p.Blocks[:j]
+		}
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles, nil
+}
+
+func putUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func putString(w *bufio.Writer, s string) {
+	putUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func readString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	if n > 1<<20 {
+		return "", fmt.Errorf("%w: string too long", ErrBadCompactProfile)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadCompactProfile, err)
+	}
+	return string(buf), nil
+}
+
+func isCompactProfile(contentType string) bool {
+	return strings.HasPrefix(contentType, ProfileCompactContentType)
+}
+
+// accepts reports whether the comma separated header value lists the token,
+// ignoring parameters such as q values
+func accepts(header, token string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if i := strings.Index(v, ";"); i >= 0 {
+			v = v[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeProfile writes profiles into the response in the format the request asks for:
+// the compact encoding if listed in Accept, the text format otherwise, gzip
+// compressed if the client accepts it.
+// Branch coverage is only carried by the text format, it is used whenever branches are given.
+func writeProfile(w http.ResponseWriter, r *http.Request, profiles []*cover.Profile, branches []*BranchProfile) error {
+	compact := branches == nil && accepts(r.Header.Get("Accept"), ProfileCompactContentType)
+	if compact {
+		w.Header().Set("Content-Type", ProfileCompactContentType)
+	} else {
+		w.Header().Set("Content-Type", ProfileTextContentType+"; charset=utf-8")
+	}
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+
+	var out io.Writer = w
+	if accepts(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	if compact {
+		return EncodeCompactProfile(out, profiles)
+	}
+	bw := bufio.NewWriter(out)
+	if err := cov.DumpProfile(profiles, bw); err != nil {
+		return err
+	}
+	if branches != nil {
+		if err := WriteBranchProfiles(bw, branches); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
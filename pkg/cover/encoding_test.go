@@ -0,0 +1,174 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/cover"
+)
+
+var compactTestProfiles = []*cover.Profile{
+	{
+		FileName: "b/b.go",
+		Mode:     "count",
+		Blocks: []cover.ProfileBlock{
+			{StartLine: 5, StartCol: 2, EndLine: 7, EndCol: 3, NumStmt: 2, Count: 0},
+		},
+	},
+	{
+		FileName: "a/a.go",
+		Mode:     "count",
+		Blocks: []cover.ProfileBlock{
+			{StartLine: 1, StartCol: 10, EndLine: 3, EndCol: 2, NumStmt: 1, Count: 300},
+			{StartLine: 4, StartCol: 1, EndLine: 4, EndCol: 20, NumStmt: 1, Count: 1},
+		},
+	},
+}
+
+func TestCompactProfileRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeCompactProfile(&buf, compactTestProfiles))
+
+	profiles, err := DecodeCompactProfile(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+	// files come back sorted, like cover.ParseProfiles does
+	assert.Equal(t, "a/a.go", profiles[0].FileName)
+	assert.Equal(t, compactTestProfiles[1].Blocks, profiles[0].Blocks)
+	assert.Equal(t, compactTestProfiles[0].Blocks, profiles[1].Blocks)
+	assert.Equal(t, "count", profiles[1].Mode)
+}
+
+func TestDecodeCompactProfileErrors(t *testing.T) {
+	_, err := DecodeCompactProfile(bytes.NewReader([]byte("mode: count\n")))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad magic")
+
+	// counters only, without a known block table
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	bw.WriteString(compactMagic)
+	putUvarint(bw, compactVersion)
+	putUvarint(bw, 0)
+	putString(bw, "abc")
+	putString(bw, "count")
+	putUvarint(bw, 0)
+	bw.Flush()
+	_, err = DecodeCompactProfile(&buf)
+	assert.Equal(t, ErrUnknownBuildID, err)
+}
+
+func TestDecodeCounterOnlyProfile(t *testing.T) {
+	var cache blockTableCache
+	cache.put("http://127.0.0.1:8080", "abc", &blockTable{
+		mode:  "set",
+		files: []*cover.Profile{{FileName: "a/a.go", Mode: "set", Blocks: compactTestProfiles[1].Blocks}},
+	})
+	assert.Equal(t, "abc", cache.knownBuildID("http://127.0.0.1:8080"))
+	assert.Equal(t, "", cache.knownBuildID("http://127.0.0.1:9090"))
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	bw.WriteString(compactMagic)
+	putUvarint(bw, compactVersion)
+	putUvarint(bw, 0)
+	putString(bw, "abc")
+	putString(bw, "set")
+	putUvarint(bw, 2)
+	putUvarint(bw, 1)
+	putUvarint(bw, 0)
+	bw.Flush()
+
+	profiles, buildID, _, err := decodeCompactProfile(&buf, &cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", buildID)
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, 1, profiles[0].Blocks[0].Count)
+	assert.Equal(t, 0, profiles[0].Blocks[1].Count)
+}
+
+func TestBlockTableCachePrune(t *testing.T) {
+	var cache blockTableCache
+	a, b := "http://127.0.0.1:8080", "http://127.0.0.1:9090"
+	cache.put(a, "v1", &blockTable{mode: "set"})
+	cache.put(b, "v1", &blockTable{mode: "set"})
+
+	// b still runs v1
+	cache.put(a, "v2", &blockTable{mode: "set"})
+	assert.NotNil(t, cache.get("v1"))
+	assert.Equal(t, "v1", cache.knownBuildID(b))
+
+	// nobody runs v1 any more
+	cache.put(b, "v2", &blockTable{mode: "set"})
+	assert.Nil(t, cache.get("v1"))
+	assert.Len(t, cache.tables, 1)
+
+	cache.forget(a)
+	assert.NotNil(t, cache.get("v2"))
+	cache.forget(b)
+	assert.Nil(t, cache.get("v2"))
+	assert.Equal(t, "", cache.knownBuildID(b))
+
+	cache.put(a, "v3", &blockTable{mode: "set"})
+	cache.clear()
+	assert.Nil(t, cache.get("v3"))
+	assert.Equal(t, "", cache.knownBuildID(a))
+
+	// a center removing a service or reset forgets its tables
+	s := &server{Store: NewMemoryStore()}
+	assert.NoError(t, s.Store.Add(ServiceUnderTest{Name: "a", Address: a}))
+	s.blockTables.put(a, "v4", &blockTable{mode: "set"})
+	s.blockTables.put(b, "v5", &blockTable{mode: "set"})
+	s.removeAddresses([]ServiceUnderTest{{Name: "a", Address: a}})
+	assert.Nil(t, s.blockTables.get("v4"))
+	assert.NotNil(t, s.blockTables.get("v5"))
+	assert.NoError(t, s.reset())
+	assert.Nil(t, s.blockTables.get("v5"))
+}
+
+func TestWriteProfileNegotiation(t *testing.T) {
+	// text by default
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/cover/profile", nil)
+	assert.NoError(t, writeProfile(w, r, compactTestProfiles, nil))
+	assert.Contains(t, w.Header().Get("Content-Type"), ProfileTextContentType)
+	assert.Contains(t, w.Body.String(), "mode: count\nb/b.go:5.2,7.3 2 0\n")
+
+	// compact and gzip on request
+	w = httptest.NewRecorder()
+	r.Header.Set("Accept", ProfileCompactContentType+";q=1, text/plain;q=0.5")
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	assert.NoError(t, writeProfile(w, r, compactTestProfiles, nil))
+	assert.Equal(t, ProfileCompactContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	profiles, err := DecodeCompactProfile(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+}
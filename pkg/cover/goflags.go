@@ -0,0 +1,169 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrInvalidBuildFlags represents the error that the build flags can't be split into arguments
+var ErrInvalidBuildFlags = errors.New("invalid build flags")
+
+// SplitBuildFlags splits the build flags into arguments as a shell would, without expanding
+// anything: words are separated by spaces, quotes group spaces into a word wherever they are,
+// -ldflags="-X a=b c" being the argument -ldflags=-X a=b c, and a backslash escapes outside
+// single quotes.
+func SplitBuildFlags(flags string) ([]string, error) {
+	var (
+		args  []string
+		word  strings.Builder
+		inArg bool
+		quote rune
+		esc   bool
+	)
+	for _, r := range flags {
+		switch {
+		case esc:
+			word.WriteRune(r)
+			esc = false
+		case r == '\\' && quote != '\'':
+			esc, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, word.String())
+				word.Reset()
+				inArg = false
+			}
+		default:
+			word.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || esc {
+		return nil, fmt.Errorf("%w: unterminated quote or escape in %q", ErrInvalidBuildFlags, flags)
+	}
+	if inArg {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// goValueFlags are the build flags of the go command which take a value
+var goValueFlags = map[string]bool{
+	"C": true, "o": true, "p": true, "asmflags": true, "buildmode": true, "compiler": true,
+	"gccgoflags": true, "gcflags": true, "installsuffix": true, "ldflags": true, "mod": true,
+	"modfile": true, "overlay": true, "pgo": true, "pkgdir": true, "tags": true, "toolexec": true,
+	"covermode": true, "coverpkg": true, "exec": true,
+}
+
+// goListFlags are the build flags which change the packages go list loads, their files or dependencies
+var goListFlags = map[string]bool{
+	"C": true, "compiler": true, "installsuffix": true, "mod": true, "modfile": true, "overlay": true,
+	"tags": true, "race": true, "msan": true, "asan": true, "linkshared": true, "buildvcs": true,
+}
+
+// ListFlags returns the build flags go list needs to load the packages as the build does,
+// the ones about compiling, linking or the output are dropped
+func ListFlags(args []string) []string {
+	var list []string
+	for i := 0; i < len(args); i++ {
+		name, hasValue := flagName(args[i])
+		if name == "" {
+			continue
+		}
+		n := 1
+		if goValueFlags[name] && !hasValue && i+1 < len(args) {
+			n = 2
+		}
+		if goListFlags[name] {
+			list = append(list, args[i:i+n]...)
+		}
+		i += n - 1
+	}
+	return list
+}
+
+// BuildFlagValue returns the value of a build flag, from the arguments or else GOFLAGS as the go command does
+func BuildFlagValue(args []string, name string) (string, bool) {
+	value, ok := flagValue(args, name)
+	if ok {
+		return value, true
+	}
+	return flagValue(strings.Fields(os.Getenv("GOFLAGS")), name)
+}
+
+func flagValue(args []string, name string) (value string, ok bool) {
+	for i := 0; i < len(args); i++ {
+		n, hasValue := flagName(args[i])
+		switch {
+		case n != name:
+			if goValueFlags[n] && !hasValue {
+				i++
+			}
+		case hasValue:
+			value, ok = args[i][strings.Index(args[i], "=")+1:], true
+		case goValueFlags[n] && i+1 < len(args):
+			value, ok = args[i+1], true
+			i++
+		default:
+			value, ok = "true", true
+		}
+	}
+	return
+}
+
+// flagName returns the name of the flag an argument is, and whether the argument holds its value
+func flagName(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+		return "", false
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], true
+	}
+	return name, false
+}
+
+// GoCommand returns the go command run in dir with the environment of goc, GOOS, GOARCH,
+// CGO_ENABLED, GOFLAGS and the like included, and GOPATH changed if gopath is set
+func GoCommand(dir, gopath string, args ...string) *exec.Cmd {
+	return GoCommandContext(context.Background(), dir, gopath, args...)
+}
+
+// GoCommandContext is GoCommand, the command is killed if ctx is done before it completes
+func GoCommandContext(ctx context.Context, dir, gopath string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	if gopath != "" {
+		cmd.Env = append(cmd.Env, "GOPATH="+gopath)
+	}
+	return cmd
+}
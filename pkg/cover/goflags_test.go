@@ -0,0 +1,69 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBuildFlags(t *testing.T) {
+	items := []struct {
+		flags string
+		args  []string
+	}{
+		{"", nil},
+		{"  -v   -race ", []string{"-v", "-race"}},
+		{`-ldflags="-X a=b c" -tags 'a b'`, []string{"-ldflags=-X a=b c", "-tags", "a b"}},
+		{`-ldflags '-extldflags -static' -tags='embed kodo'`, []string{"-ldflags", "-extldflags -static", "-tags=embed kodo"}},
+		{`-ldflags="-X 'main.v=a b'"`, []string{"-ldflags=-X 'main.v=a b'"}},
+		{`a\ b "" '$(rm -rf /)';ls`, []string{"a b", "", "$(rm -rf /);ls"}},
+	}
+	for _, item := range items {
+		args, err := SplitBuildFlags(item.flags)
+		assert.NoError(t, err, item.flags)
+		assert.Equal(t, item.args, args, item.flags)
+	}
+
+	for _, flags := range []string{`-tags="a`, `-tags='a`, `a\`} {
+		_, err := SplitBuildFlags(flags)
+		assert.True(t, errors.Is(err, ErrInvalidBuildFlags), flags)
+	}
+}
+
+func TestListFlags(t *testing.T) {
+	args := []string{"-o", "bin/app", "-ldflags", "-s -w", "-tags", "a b", "-race", "-v", "--mod=vendor", "-gcflags=all=-N", "-trimpath", "-modfile", "go.test.mod"}
+	assert.Equal(t, []string{"-tags", "a b", "-race", "--mod=vendor", "-modfile", "go.test.mod"}, ListFlags(args))
+}
+
+func TestBuildFlagValue(t *testing.T) {
+	defer os.Setenv("GOFLAGS", os.Getenv("GOFLAGS"))
+	os.Setenv("GOFLAGS", "-mod=vendor -tags=x")
+
+	value, ok := BuildFlagValue([]string{"-ldflags", "-mod=mod", "-race"}, "mod")
+	assert.Equal(t, "vendor", value, "from GOFLAGS, -mod=mod is the value of -ldflags")
+	assert.True(t, ok)
+	value, _ = BuildFlagValue([]string{"-mod", "readonly"}, "mod")
+	assert.Equal(t, "readonly", value)
+	value, _ = BuildFlagValue([]string{"-race"}, "race")
+	assert.Equal(t, "true", value)
+	_, ok = BuildFlagValue(nil, "modfile")
+	assert.False(t, ok)
+}
@@ -0,0 +1,240 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// ErrHotSetMode is returned when calls are counted from a profile which only records whether blocks ran
+var ErrHotSetMode = errors.New("function calls can't be counted from a set mode profile, build with --mode=count or --mode=atomic")
+
+// FuncCalls is the number of times a function was called, that is the count of the block its body starts with
+type FuncCalls struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Name    string `json:"name"` // (*T).M for methods
+	Calls   int    `json:"calls"`
+}
+
+// HotGroup lists the hottest and the never called functions of a service, or of one of its packages
+type HotGroup struct {
+	Service      string      `json:"service"`
+	Package      string      `json:"package,omitempty"`
+	Funcs        int         `json:"funcs"`
+	Calls        int         `json:"calls"`
+	Hot          []FuncCalls `json:"hot"`
+	NeverCalled  []FuncCalls `json:"never_called"`
+	NNeverCalled int         `json:"n_never_called"`
+}
+
+// CountFuncCalls attributes the block counts of the profiles to the functions
+// of their source files, found in srcDir like go tool cover -func does.
+func CountFuncCalls(profiles []*cover.Profile, srcDir string) ([]FuncCalls, error) {
+	if len(profiles) > 0 && profiles[0].Mode == "set" {
+		return nil, ErrHotSetMode
+	}
+
+	var funcs []FuncCalls
+	dirs := make(map[string]string)
+	for _, p := range profiles {
+		filename, err := findSourceFile(p.FileName, srcDir, dirs)
+		if err != nil {
+			return nil, err
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			funcs = append(funcs, FuncCalls{
+				Package: path.Dir(p.FileName),
+				File:    p.FileName,
+				Line:    fset.Position(fn.Pos()).Line,
+				Name:    funcName(fn),
+				Calls:   entryCount(p.Blocks, fset.Position(fn.Body.Lbrace), fset.Position(fn.Body.Rbrace)),
+			})
+		}
+	}
+	return funcs, nil
+}
+
+// entryCount returns the count of the first block of the body between the braces
+func entryCount(blocks []cover.ProfileBlock, lbrace, rbrace token.Position) int {
+	var entry *cover.ProfileBlock
+	for i := range blocks {
+		b := &blocks[i]
+		if before(b.StartLine, b.StartCol, lbrace) || !before(b.StartLine, b.StartCol, rbrace) {
+			continue
+		}
+		if entry == nil || b.StartLine < entry.StartLine || b.StartLine == entry.StartLine && b.StartCol < entry.StartCol {
+			entry = b
+		}
+	}
+	if entry == nil {
+		return 0
+	}
+	return entry.Count
+}
+
+func before(line, col int, pos token.Position) bool {
+	return line < pos.Line || line == pos.Line && col < pos.Column
+}
+
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	typ := fn.Recv.List[0].Type
+	ptr := false
+	if star, ok := typ.(*ast.StarExpr); ok {
+		ptr, typ = true, star.X
+	}
+	// drop the type parameter of generic receivers
+	if index, ok := typ.(*ast.IndexExpr); ok {
+		typ = index.X
+	}
+	name := "?"
+	if id, ok := typ.(*ast.Ident); ok {
+		name = id.Name
+	}
+	if ptr {
+		return fmt.Sprintf("(*%s).%s", name, fn.Name.Name)
+	}
+	return name + "." + fn.Name.Name
+}
+
+// findSourceFile finds the file of the profile, which is named after its import path,
+// dirs caches the directory of each package
+func findSourceFile(file, srcDir string, dirs map[string]string) (string, error) {
+	if filepath.IsAbs(file) {
+		return file, nil
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, file)); err == nil {
+		return filepath.Join(srcDir, file), nil
+	}
+	pkgPath, name := path.Split(file)
+	dir, ok := dirs[pkgPath]
+	if !ok {
+		// the go command runs in ctxt.Dir to resolve modules
+		ctxt := build.Default
+		ctxt.Dir = srcDir
+		pkg, err := ctxt.Import(pkgPath, srcDir, build.FindOnly)
+		if err != nil {
+			return "", fmt.Errorf("can't find %q: %v", file, err)
+		}
+		dir = pkg.Dir
+		dirs[pkgPath] = dir
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// HotGroups ranks the functions of a service, or of each of its packages if perPackage.
+// At most top functions are listed as hot and as never called, all of them if top <= 0.
+func HotGroups(service string, funcs []FuncCalls, top int, perPackage bool) []*HotGroup {
+	var groups []*HotGroup
+	byPkg := make(map[string]*HotGroup)
+	for _, f := range funcs {
+		key := ""
+		if perPackage {
+			key = f.Package
+		}
+		g, ok := byPkg[key]
+		if !ok {
+			g = &HotGroup{Service: service, Package: key}
+			byPkg[key] = g
+			groups = append(groups, g)
+		}
+		g.Funcs++
+		g.Calls += f.Calls
+		if f.Calls > 0 {
+			g.Hot = append(g.Hot, f)
+		} else {
+			g.NeverCalled = append(g.NeverCalled, f)
+		}
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g.Hot, func(i, j int) bool { return g.Hot[i].Calls > g.Hot[j].Calls })
+		sort.SliceStable(g.NeverCalled, func(i, j int) bool {
+			a, b := g.NeverCalled[i], g.NeverCalled[j]
+			if a.File != b.File {
+				return a.File < b.File
+			}
+			return a.Line < b.Line
+		})
+		g.NNeverCalled = len(g.NeverCalled)
+		if top > 0 && len(g.Hot) > top {
+			g.Hot = g.Hot[:top]
+		}
+		if top > 0 && len(g.NeverCalled) > top {
+			g.NeverCalled = g.NeverCalled[:top]
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Package < groups[j].Package })
+	return groups
+}
+
+// HotReport ranks the functions of every service of the sources, instances of the
+// same service are merged first. Sources are named after their service.
+func HotReport(sources []ProfileSource, srcDir string, top int, perPackage bool) ([]*HotGroup, error) {
+	var (
+		names     []string
+		byService = make(map[string][]ProfileSource)
+	)
+	for _, src := range sources {
+		if _, ok := byService[src.Name]; !ok {
+			names = append(names, src.Name)
+		}
+		byService[src.Name] = append(byService[src.Name], src)
+	}
+	sort.Strings(names)
+
+	var report = make([]*HotGroup, 0)
+	for _, name := range names {
+		merged, err := MergeCoherentProfiles(byService[name])
+		if err != nil {
+			return nil, err
+		}
+		if len(merged) > 1 {
+			log.Warnf("instances of %s were built from different sources, only %v are counted", name, merged[0].Address)
+		}
+		funcs, err := CountFuncCalls(merged[0].Profiles, srcDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count the function calls of %s: %v", name, err)
+		}
+		report = append(report, HotGroups(name, funcs, top, perPackage)...)
+	}
+	return report, nil
+}
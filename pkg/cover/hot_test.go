@@ -0,0 +1,159 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hotSource = `package a
+
+type T struct{}
+
+func (t *T) Get() int { return 1 }
+
+func (t T) Purge() {}
+
+func Used(i int) int {
+	if i > 0 {
+		return i
+	}
+	return 0
+}
+
+func Dead() {}
+`
+
+const hotProfile = `mode: count
+a/a.go:5.23,5.35 1 30
+a/a.go:7.20,7.21 0 0
+a/a.go:9.22,10.11 1 12
+a/a.go:10.11,12.3 1 10
+a/a.go:13.2,13.10 1 2
+a/a.go:16.13,16.14 0 0
+`
+
+func writeHotSource(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "goc-hot")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "a.go"), []byte(hotSource), 0644))
+	return dir
+}
+
+func TestCountFuncCalls(t *testing.T) {
+	dir := writeHotSource(t)
+	defer os.RemoveAll(dir)
+
+	funcs, err := CountFuncCalls(parseTestProfile(t, hotProfile), dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []FuncCalls{
+		{Package: "a", File: "a/a.go", Line: 5, Name: "(*T).Get", Calls: 30},
+		{Package: "a", File: "a/a.go", Line: 7, Name: "T.Purge", Calls: 0},
+		{Package: "a", File: "a/a.go", Line: 9, Name: "Used", Calls: 12},
+		{Package: "a", File: "a/a.go", Line: 16, Name: "Dead", Calls: 0},
+	}, funcs)
+
+	_, err = CountFuncCalls(parseTestProfile(t, "mode: set\na/a.go:5.23,5.35 1 1\n"), dir)
+	assert.Equal(t, ErrHotSetMode, err)
+
+	_, err = CountFuncCalls(parseTestProfile(t, "mode: count\nb/b.go:1.1,2.2 1 1\n"), dir)
+	assert.Error(t, err)
+}
+
+func TestHotGroups(t *testing.T) {
+	funcs := []FuncCalls{
+		{Package: "a", File: "a/a.go", Line: 1, Name: "A1", Calls: 3},
+		{Package: "a", File: "a/a.go", Line: 9, Name: "A2", Calls: 0},
+		{Package: "a", File: "a/a.go", Line: 5, Name: "A3", Calls: 0},
+		{Package: "b", File: "b/b.go", Line: 1, Name: "B1", Calls: 7},
+		{Package: "b", File: "b/b.go", Line: 3, Name: "B2", Calls: 1},
+	}
+
+	groups := HotGroups("svc", funcs, 1, false)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, 5, groups[0].Funcs)
+	assert.Equal(t, 11, groups[0].Calls)
+	assert.Equal(t, []FuncCalls{funcs[3]}, groups[0].Hot)
+	assert.Equal(t, []FuncCalls{funcs[2]}, groups[0].NeverCalled)
+	assert.Equal(t, 2, groups[0].NNeverCalled)
+
+	groups = HotGroups("svc", funcs, 0, true)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, "a", groups[0].Package)
+	assert.Equal(t, []FuncCalls{funcs[0]}, groups[0].Hot)
+	assert.Equal(t, []FuncCalls{funcs[2], funcs[1]}, groups[0].NeverCalled)
+	assert.Equal(t, "b", groups[1].Package)
+	assert.Equal(t, []FuncCalls{funcs[3], funcs[4]}, groups[1].Hot)
+	assert.Empty(t, groups[1].NeverCalled)
+}
+
+func TestHotService(t *testing.T) {
+	dir := writeHotSource(t)
+	defer os.RemoveAll(dir)
+
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(hotProfile))
+	}))
+	defer agent.Close()
+
+	// no report without a source tree
+	server := &server{Store: NewMemoryStore()}
+	assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "a", Address: agent.URL}))
+	router := server.Route(os.Stdout)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/cover/hot?top=1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Contains(t, w.Body.String(), "no source tree")
+
+	server.SourceDir = dir
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/cover/hot?top=1&source=/etc", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report []HotGroup
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Len(t, report, 1)
+	assert.Equal(t, "a", report[0].Service)
+	assert.Equal(t, "(*T).Get", report[0].Hot[0].Name)
+	assert.Equal(t, "T.Purge", report[0].NeverCalled[0].Name)
+	assert.Equal(t, 2, report[0].NNeverCalled)
+
+}
+
+func TestInsideSourceDir(t *testing.T) {
+	for file, inside := range map[string]bool{
+		"example.com/svc/cache.go": true,
+		"svc/cache.go":             true,
+		"/etc/hosts.go":            false,
+		"../svc/cache.go":          false,
+		"example.com/../../x/x.go": false,
+		`example.com\..\..\x\x.go`: false,
+	} {
+		assert.Equal(t, inside, insideSourceDir(file), file)
+	}
+}
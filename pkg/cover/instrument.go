@@ -22,6 +22,8 @@ import (
 	"path"
 	"path/filepath"
 	"text/template"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
 )
 
 // InjectCountersHandlers generate a file _cover_http_apis.go besides the main.go file
@@ -38,6 +40,66 @@ func InjectCountersHandlers(tc TestCover, dest string) error {
 
 var coverMainTmpl = template.Must(template.New("coverMain").Parse(coverMain))
 
+// InjectExport generates the file exporting GocDumpProfile from a library besides the agent:
+// a C function for c-shared and c-archive, a Go function plugin.Lookup finds for a plugin
+func InjectExport(tc TestCover, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coverExportTmpl.Execute(f, tc)
+}
+
+var coverExportTmpl = template.Must(template.New("coverExport").Parse(coverExport))
+
+const coverExport = `
+// Code generated by goc system. DO NOT EDIT.
+
+package main
+{{if eq .BuildMode "plugin"}}
+import "io"
+
+// GocDumpProfile writes the coverage profile of the plugin to w
+func GocDumpProfile(w io.Writer) error {
+	return writeProfileGoc(w)
+}
+{{else}}
+import "C"
+
+import (
+	"bufio"
+	"os"
+)
+
+// GocDumpProfile writes the coverage profile of the library to the file at path, it returns 0 on success
+//export GocDumpProfile
+func GocDumpProfile(path *C.char) C.int {
+	if err := dumpProfileGoc(C.GoString(path)); err != nil {
+		logfGoc(logErrorGoc, "failed to dump the coverage profile, err: %v", err)
+		return -1
+	}
+	return 0
+}
+
+func dumpProfileGoc(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeProfileGoc(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+{{end}}`
+
 const coverMain = `
 // Code generated by goc system. DO NOT EDIT.
 
@@ -46,29 +108,340 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	_log "log"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"testing"
+	"time"
 
-	_cover {{.GlobalCoverVarImportPath | printf "%q"}}
+	{{if .Native}}"runtime/coverage"{{else}}_cover {{.GlobalCoverVarImportPath | printf "%q"}}{{end}}
 
 )
 
 func init() {
+	if configGoc.disabled {
+		logfGoc(logInfoGoc, "coverage agent disabled by GOC_AGENT_DISABLED")
+		return
+	}
 	go registerHandlersGoc()
 }
 
+// agentConfigGoc is the runtime configuration of the agent. The defaults are
+// baked in by goc at build time, the environment variables override them so
+// one instrumented binary can be promoted across environments.
+type agentConfigGoc struct {
+	center        string        // GOC_CENTER, url of the goc server
+	listenAddr    string        // GOC_LISTEN_ADDR, address the agent listens on
+	advertiseAddr string        // GOC_ADVERTISE_ADDR, address registered into the center
+	stateDir      string        // GOC_STATE_DIR, directory of the listen address state file
+	singleton     bool          // GOC_SINGLETON, do not register into the center
+	disabled      bool          // GOC_AGENT_DISABLED, do not start the agent at all
+	reregister    bool          // GOC_REREGISTER, register again whenever the center no longer lists the agent, on by default
+	interval      time.Duration // GOC_REGISTER_INTERVAL, how often the agent checks the center still lists it
+}
+
+const (
+	logSilentGoc = iota
+	logErrorGoc
+	logWarnGoc
+	logInfoGoc
+	logDebugGoc
+)
+
+var (
+	configGoc   = loadConfigGoc()
+	logLevelGoc = logWarnGoc // GOC_LOG_LEVEL, one of silent, error, warn, info, debug
+)
+
+func loadConfigGoc() *agentConfigGoc {
+	c := &agentConfigGoc{
+		center:     {{.Center | printf "%q"}},
+		listenAddr: {{.AgentPort | printf "%q"}},
+		singleton:  {{.Singleton}},
+		interval:   time.Minute,
+	}
+	if v, ok := os.LookupEnv("GOC_LOG_LEVEL"); ok {
+		switch strings.ToLower(v) {
+		case "silent", "none", "off":
+			logLevelGoc = logSilentGoc
+		case "error":
+			logLevelGoc = logErrorGoc
+		case "warn", "warning":
+			logLevelGoc = logWarnGoc
+		case "info":
+			logLevelGoc = logInfoGoc
+		case "debug":
+			logLevelGoc = logDebugGoc
+		default:
+			logfGoc(logWarnGoc, "unknown GOC_LOG_LEVEL %q, keep warn", v)
+		}
+	}
+	if v := os.Getenv("GOC_CENTER"); v != "" {
+		c.center = strings.TrimSuffix(v, "/")
+	}
+	if v := os.Getenv("GOC_LISTEN_ADDR"); v != "" {
+		c.listenAddr = v
+	}
+	c.advertiseAddr = os.Getenv("GOC_ADVERTISE_ADDR")
+	c.stateDir = os.Getenv("GOC_STATE_DIR")
+	c.singleton = boolEnvGoc("GOC_SINGLETON", c.singleton)
+	c.disabled = boolEnvGoc("GOC_AGENT_DISABLED", false)
+	c.reregister = boolEnvGoc("GOC_REREGISTER", true)
+	if v := os.Getenv("GOC_REGISTER_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			c.interval = d
+		} else {
+			logfGoc(logWarnGoc, "invalid GOC_REGISTER_INTERVAL %q, keep %v", v, c.interval)
+		}
+	}
+	return c
+}
+
+func boolEnvGoc(name string, def bool) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logfGoc(logWarnGoc, "invalid %s %q, keep %v", name, v, def)
+		return def
+	}
+	return b
+}
+
+var logLevelNamesGoc = []string{"", "ERROR", "WARN", "INFO", "DEBUG"}
+
+// logfGoc logs with the [goc] prefix if the level is enabled by GOC_LOG_LEVEL
+func logfGoc(level int, format string, args ...interface{}) {
+	if level > logLevelGoc {
+		return
+	}
+	_log.Printf("[goc]["+logLevelNamesGoc[level]+"] "+format, args...)
+}
+
+{{if .Native}}
+func loadValuesGoc() (map[string][]uint32, map[string][]testing.CoverBlock) {
+	nativeMetaOnceGoc.Do(loadNativeMetaGoc)
+	coverCounters := make(map[string][]uint32, len(nativeBlocksGoc))
+	for name, block := range nativeBlocksGoc {
+		coverCounters[name] = make([]uint32, len(block))
+	}
+	if nativeMetaErrGoc != nil {
+		logfGoc(logErrorGoc, "read the coverage meta-data failed, err: %v", nativeMetaErrGoc)
+		return coverCounters, nativeBlocksGoc
+	}
+	var buf bytes.Buffer
+	err := coverage.WriteCounters(&buf)
+	if err == nil {
+		err = addNativeCountersGoc(coverCounters, buf.Bytes())
+	}
+	if err != nil {
+		logfGoc(logErrorGoc, "read the coverage counters failed, err: %v", err)
+	}
+	return coverCounters, nativeBlocksGoc
+}
+
+func clearValuesGoc() {
+	// the native backend always builds with -covermode=atomic, which ClearCounters requires
+	if err := coverage.ClearCounters(); err != nil {
+		logfGoc(logErrorGoc, "clear the coverage counters failed, err: %v", err)
+	}
+}
+
+// the toolchain instruments whole packages, the generated files goc leaves out and
+// the agent itself are dropped from the profile
+var nativeSkipGoc = map[string]bool{
+	{{range .NativeSkip}}{{printf "%q" .}}: true,
+	{{end}}
+}
+
+var (
+	nativeMetaOnceGoc sync.Once
+	nativeMetaErrGoc  error
+	nativeBlocksGoc   = make(map[string][]testing.CoverBlock)
+	nativeFuncsGoc    [][]nativeFuncGoc // functions of the meta-data, by package
+)
+
+// nativeFuncGoc is where the units of a function of the meta-data are in the blocks of its file
+type nativeFuncGoc struct {
+	file  string
+	start int
+	units int
+}
+
+// nativeReaderGoc reads the ULEB128 and little endian values of the go coverage data,
+// it panics on truncated data, the callers recover
+type nativeReaderGoc struct {
+	b   []byte
+	off int
+}
+
+func (r *nativeReaderGoc) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		c := r.b[r.off]
+		r.off++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (r *nativeReaderGoc) u32(order binary.ByteOrder) uint32 {
+	v := order.Uint32(r.b[r.off : r.off+4])
+	r.off += 4
+	return v
+}
+
+func (r *nativeReaderGoc) u64() uint64 {
+	v := binary.LittleEndian.Uint64(r.b[r.off : r.off+8])
+	r.off += 8
+	return v
+}
+
+// loadNativeMetaGoc lays the units of every function out as the blocks of their file,
+// the meta-data never changes while the process runs
+func loadNativeMetaGoc() {
+	defer func() {
+		if e := recover(); e != nil {
+			nativeMetaErrGoc = fmt.Errorf("invalid meta-data: %v", e)
+		}
+	}()
+	var buf bytes.Buffer
+	if nativeMetaErrGoc = coverage.WriteMeta(&buf); nativeMetaErrGoc != nil {
+		return
+	}
+	data := buf.Bytes()
+	// file header: magic, version, length, number of packages, hash, string table, mode, granularity
+	r := &nativeReaderGoc{b: data, off: 16}
+	entries := int(r.u64())
+	r.off = 56
+	offsets := make([]uint64, entries)
+	for i := range offsets {
+		offsets[i] = r.u64()
+	}
+	for i := range offsets {
+		r.u64() // length of the package
+		nativeFuncsGoc = append(nativeFuncsGoc, loadNativePackageGoc(data[offsets[i]:]))
+	}
+}
+
+func loadNativePackageGoc(blob []byte) []nativeFuncGoc {
+	// package header: length, name, path, module path, hash, padding, number of files and of functions
+	r := &nativeReaderGoc{b: blob, off: 40}
+	numFuncs := int(r.u32(binary.LittleEndian))
+	funcOffsets := make([]uint32, numFuncs)
+	for i := range funcOffsets {
+		funcOffsets[i] = r.u32(binary.LittleEndian)
+	}
+	strs := make([]string, r.uleb())
+	for i := range strs {
+		n := int(r.uleb())
+		strs[i] = string(r.b[r.off : r.off+n])
+		r.off += n
+	}
+
+	funcs := make([]nativeFuncGoc, 0, numFuncs)
+	for _, off := range funcOffsets {
+		r.off = int(off)
+		units := int(r.uleb())
+		r.uleb() // function name
+		file := strs[r.uleb()]
+		fn := nativeFuncGoc{file: file, start: len(nativeBlocksGoc[file]), units: units}
+		if nativeSkipGoc[file] {
+			fn.file = ""
+		}
+		for k := 0; k < units; k++ {
+			b := testing.CoverBlock{
+				Line0: uint32(r.uleb()),
+				Col0:  uint16(r.uleb()),
+				Line1: uint32(r.uleb()),
+				Col1:  uint16(r.uleb()),
+				Stmts: uint16(r.uleb()),
+			}
+			if fn.file != "" {
+				nativeBlocksGoc[file] = append(nativeBlocksGoc[file], b)
+			}
+		}
+		funcs = append(funcs, fn)
+	}
+	return funcs
+}
+
+// addNativeCountersGoc adds the counter data the runtime wrote to the counters of the files
+func addNativeCountersGoc(counters map[string][]uint32, data []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("invalid counter data: %v", e)
+		}
+	}()
+	// file header: magic, version, meta-data hash, flavor, big endian, padding
+	uleb, order := data[24] == 2, binary.ByteOrder(binary.LittleEndian)
+	if data[25] != 0 {
+		order = binary.BigEndian
+	}
+	// footer: magic, padding, number of segments, padding
+	segments := binary.LittleEndian.Uint32(data[len(data)-8:])
+
+	r := &nativeReaderGoc{b: data, off: 32}
+	value := func() uint32 {
+		if uleb {
+			return uint32(r.uleb())
+		}
+		return r.u32(order)
+	}
+	for seg := uint32(0); seg < segments; seg++ {
+		if seg > 0 {
+			r.off += 16 // footer of the previous segment
+		}
+		// segment header: number of functions, lengths of the string table and of the arguments
+		entries := r.u64()
+		strTabLen := int(r.u32(binary.LittleEndian))
+		argsLen := int(r.u32(binary.LittleEndian))
+		r.off += strTabLen + argsLen
+		if rem := r.off % 4; rem != 0 {
+			r.off += 4 - rem
+		}
+		for i := uint64(0); i < entries; i++ {
+			n, pkg, fnIdx := int(value()), int(value()), int(value())
+			fn := nativeFuncsGoc[pkg][fnIdx]
+			for k := 0; k < n; k++ {
+				v := value()
+				if fn.file == "" || k >= fn.units {
+					continue
+				}
+				{{if eq .Mode "set"}}if v > 1 {
+					v = 1
+				}
+				{{end}}counters[fn.file][fn.start+k] += v
+			}
+		}
+	}
+	return nil
+}
+
+{{else}}
 func loadValuesGoc() (map[string][]uint32, map[string][]testing.CoverBlock) {
 	var (
 		coverCounters = make(map[string][]uint32)
@@ -122,6 +495,73 @@ func clearValuesGoc() {
 	clearFileCoverGoc(_cover.{{$cover.Var}}.Count[:])
 	{{end}}
 
+	{{if .Branch}}
+	for _, b := range loadBranchesGoc() {
+		clearFileCoverGoc(b.counter)
+	}
+	{{end}}
+}
+
+{{end}}
+
+// branchFileGoc holds the branch counters of a file
+type branchFileGoc struct {
+	name    string
+	counter []uint32
+	pos     []uint32
+	arm     []uint16
+}
+
+func loadBranchesGoc() []branchFileGoc {
+	var files []branchFileGoc
+	{{if .Branch}}
+	{{range $i, $pkgCover := .DepsCover}}
+	{{range $file, $cover := $pkgCover.Vars}}
+	files = append(files, branchFileGoc{ {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Branch[:], _cover.{{$cover.Var}}.BranchPos[:], _cover.{{$cover.Var}}.BranchArm[:]})
+	{{end}}
+	{{end}}
+
+	{{range $file, $cover := .MainPkgCover.Vars}}
+	files = append(files, branchFileGoc{ {{printf "%q" $cover.File}}, _cover.{{$cover.Var}}.Branch[:], _cover.{{$cover.Var}}.BranchPos[:], _cover.{{$cover.Var}}.BranchArm[:]})
+	{{end}}
+	{{end}}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
+
+var branchKindsGoc = []string{"", "if", "for", "cond", "switch", "typeswitch", "select"}
+
+// writeBranchesGoc writes the branch coverage section, one line per arm:
+// file:startLine.startCol,endLine.endCol kind arm count
+func writeBranchesGoc(w *bufio.Writer) {
+	w.WriteString("# goc:branch\n")
+	seen := make(map[string]bool)
+	for _, f := range loadBranchesGoc() {
+		if seen[f.name] {
+			continue
+		}
+		seen[f.name] = true
+		for i := range f.counter {
+			kind, arm := int(f.arm[i]>>12), int(f.arm[i]&0xFFF)
+			label := strconv.Itoa(arm)
+			// if, for and cond are conditions with a true and a false arm
+			switch {
+			case kind <= 3 && arm == 0:
+				label = "T"
+			case kind <= 3:
+				label = "F"
+			case arm == 0xFFF:
+				label = "default"
+			}
+			kindName := "unknown"
+			if kind < len(branchKindsGoc) {
+				kindName = branchKindsGoc[kind]
+			}
+			fmt.Fprintf(w, "%s:%d.%d,%d.%d %s %s %d\n", f.name,
+				f.pos[3*i], uint16(f.pos[3*i+2]), f.pos[3*i+1], uint16(f.pos[3*i+2]>>16),
+				kindName, label, atomic.LoadUint32(&f.counter[i]))
+		}
+	}
 }
 
 func clearFileCoverGoc(counter []uint32) {
@@ -131,37 +571,37 @@ func clearFileCoverGoc(counter []uint32) {
 }
 
 func registerHandlersGoc() {
-	{{if .Singleton}}
-	ln, _, err := listenGoc()
-	{{else}}
 	ln, host, err := listenGoc()
-	{{end}}
 	if err != nil {
-		_log.Fatalf("listenGoc failed, err:%v", err)
-	}
-	{{if not .Singleton}}
-	profileAddr := "http://" + host
-	if resp, err := registerSelfGoc(profileAddr); err != nil {
-		_log.Fatalf("register address %v failed, err: %v, response: %v", profileAddr, err, string(resp))
-	}
-
-	fn := func() {
-		var (
-			err          error
-			profileAddrs []string
-			addresses    []string
-		)
-		if addresses, err = getAllHostsGoc(ln); err != nil {
-			_log.Fatalf("get all host failed, err: %v", err)
-			return
+		// never take the service down because of goc
+		logfGoc(logErrorGoc, "listenGoc failed, coverage agent disabled, err:%v", err)
+		return
+	}
+	logfGoc(logInfoGoc, "coverage agent listening on %s, advertised as %s", ln.Addr(), host)
+
+	if !configGoc.singleton {
+		profileAddr := "http://" + host
+		go keepRegisteredGoc(profileAddr)
+
+		fn := func() {
+			var (
+				err          error
+				profileAddrs = []string{profileAddr}
+				addresses    []string
+			)
+			if addresses, err = getAllHostsGoc(ln); err != nil {
+				logfGoc(logWarnGoc, "get all host failed, err: %v", err)
+			}
+			for _, addr := range addresses {
+				profileAddrs = append(profileAddrs, "http://"+addr)
+			}
+			deregisterSelfGoc(profileAddrs)
 		}
-		for _, addr := range addresses {
-			profileAddrs = append(profileAddrs, "http://"+addr)
+		// a library leaves the signals to the process loading it
+		if libraryGoc == "" {
+			go watchSignalGoc(fn)
 		}
-		deregisterSelfGoc(profileAddrs)
 	}
-	go watchSignalGoc(fn)
-	{{end}}
 
 	mux := http.NewServeMux()
 	// Coverage reports the current code coverage as a fraction in the range [0, 1].
@@ -185,31 +625,57 @@ func registerHandlersGoc() {
 	})
 
 	// coverprofile reports a coverage profile with the coverage percentage
+	// the text format is the default, the compact one is sent if asked for in Accept,
+	// and both are gzip compressed if the client accepts it.
 	mux.HandleFunc("/v1/cover/profile", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "mode: {{.Mode}}\n")
-		counters, blocks := loadValuesGoc()
-		var active, total int64
-		var count uint32
-		for name, counts := range counters {
-			block := blocks[name]
-			for i := range counts {
-				stmts := int64(block[i].Stmts)
-				total += stmts
-				count = atomic.LoadUint32(&counts[i]) // For -mode=atomic.
-				if count > 0 {
-					active += stmts
-				}
-				_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", name,
-					block[i].Line0, block[i].Col0,
-					block[i].Line1, block[i].Col1,
-					stmts,
-					count)
-				if err != nil {
-					fmt.Fprintf(w, "invalid block format, err: %v", err)
-					return
-				}
-			}
+		compact := acceptsGoc(r.Header.Get("Accept"), "application/x-goc-profile")
+		// the build id fingerprints the instrumented blocks, the center
+		// only merges profiles of coherent builds
+		profileTableOnceGoc.Do(loadProfileTableGoc)
+		w.Header().Set("X-Goc-Build-Id", profileBuildIDGoc)
+		// and the manifest hash ties the profile to the build manifest
+		if manifestHashGoc != "" {
+			w.Header().Set("X-Goc-Manifest-Hash", manifestHashGoc)
+		}
+		if compact {
+			w.Header().Set("Content-Type", "application/x-goc-profile")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		var out io.Writer = w
+		if acceptsGoc(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+		bw := bufio.NewWriter(out)
+		defer bw.Flush()
+
+		if compact {
+			writeCompactProfileGoc(bw, r.Header.Get("X-Goc-Build-Id") == profileBuildIDGoc)
+			return
 		}
+
+		if err := writeProfileGoc(bw); err != nil {
+			fmt.Fprintf(bw, "invalid block format, err: %v", err)
+		}
+	})
+
+	// branch reports the branch coverage section, it is empty unless built with --mode=branch
+	mux.HandleFunc("/v1/cover/branch", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeBranchesGoc(bw)
+		bw.Flush()
+	})
+
+	// unlinked reports the zero count profile of the packages of the project no binary links,
+	// it is empty unless built with --unlinked
+	mux.HandleFunc("/v1/cover/unlinked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, unlinkedProfileGoc)
 	})
 
 	mux.HandleFunc("/v1/cover/clear", func(w http.ResponseWriter, r *http.Request) {
@@ -218,28 +684,292 @@ func registerHandlersGoc() {
 		fmt.Fprintln(w, "clear call successfully")
 	})
 
-	_log.Fatal(http.Serve(ln, mux))
+	// status reports the registration state of the agent
+	mux.HandleFunc("/v1/cover/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registrationGoc.snapshot())
+	})
+
+	if err := http.Serve(ln, mux); err != nil {
+		logfGoc(logErrorGoc, "coverage agent stopped, err: %v", err)
+	}
+}
+
+// writeProfileGoc writes the text profile of the counters
+func writeProfileGoc(w io.Writer) error {
+	fmt.Fprint(w, "mode: {{.Mode}}\n")
+	counters, blocks := loadValuesGoc()
+	for name, counts := range counters {
+		block := blocks[name]
+		for i := range counts {
+			count := atomic.LoadUint32(&counts[i]) // For -mode=atomic.
+			_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", name,
+				block[i].Line0, block[i].Col0,
+				block[i].Line1, block[i].Col1,
+				block[i].Stmts,
+				count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unlinkedProfileGoc is the zero count profile of the packages no binary of the project links
+const unlinkedProfileGoc = {{.UnlinkedProfile | printf "%q"}}
+
+// libraryGoc names a library goc built, the services of its agent are named after it rather than
+// after the process loading it
+const libraryGoc = {{.Library | printf "%q"}}
+
+// manifestHashGoc is the hash of the manifest goc wrote next to the binary
+const manifestHashGoc = {{.ManifestHash | printf "%q"}}
+
+const (
+	registerMinBackoffGoc = time.Second
+	registerMaxBackoffGoc = time.Minute
+)
+
+// registrationStateGoc is the registration state exposed by the status API
+type registrationStateGoc struct {
+	mu          sync.Mutex
+	singleton   bool
+	center      string
+	address     string
+	registered  bool
+	attempts    int
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   string
+}
+
+var registrationGoc = &registrationStateGoc{
+	singleton: configGoc.singleton,
+	center:    configGoc.center,
+}
+
+func (s *registrationStateGoc) record(address string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.address = address
+	s.attempts++
+	s.lastAttempt = time.Now()
+	if err != nil {
+		s.registered = false
+		s.lastError = err.Error()
+		return
+	}
+	s.registered = true
+	s.lastSuccess = s.lastAttempt
+	s.lastError = ""
+}
+
+func (s *registrationStateGoc) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"singleton":    s.singleton,
+		"center":       s.center,
+		"address":      s.address,
+		"registered":   s.registered,
+		"attempts":     s.attempts,
+		"lastAttempt":  s.lastAttempt,
+		"lastSuccess":  s.lastSuccess,
+		"lastError":    s.lastError,
+		"manifestHash": manifestHashGoc,
+	}
+}
+
+// keepRegisteredGoc registers the agent into the center in the background, failures are
+// retried with exponential backoff and jitter until it succeeds. It never stops the service.
+// Then it registers again whenever the center no longer lists it, after the center restarted
+// without its store or goc init, unless GOC_REREGISTER=false leaves goc remove and goc init alone.
+func keepRegisteredGoc(address string) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		registerUntilDoneGoc(address, rnd)
+		if !configGoc.reregister {
+			return
+		}
+		for {
+			time.Sleep(configGoc.interval)
+			listed, err := listedGoc(address)
+			if err != nil {
+				logfGoc(logDebugGoc, "failed to check the registration of %v, err: %v", address, err)
+				continue
+			}
+			if !listed {
+				logfGoc(logInfoGoc, "the center does not list %v anymore, register again", address)
+				break
+			}
+		}
+	}
+}
+
+func registerUntilDoneGoc(address string, rnd *rand.Rand) {
+	backoff := registerMinBackoffGoc
+	for {
+		resp, err := registerSelfGoc(address)
+		registrationGoc.record(address, err)
+		if err == nil {
+			logfGoc(logDebugGoc, "registered %v into %v", address, configGoc.center)
+			return
+		}
+
+		logfGoc(logWarnGoc, "register address %v failed, retry in %v, err: %v, response: %v", address, backoff, err, string(resp))
+		time.Sleep(backoff/2 + time.Duration(rnd.Int63n(int64(backoff/2)+1)))
+		if backoff *= 2; backoff > registerMaxBackoffGoc {
+			backoff = registerMaxBackoffGoc
+		}
+	}
+}
+
+// listedGoc reports whether the center lists the service at the port of address,
+// the center may have revised its host
+func listedGoc(address string) (bool, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return false, err
+	}
+	resp, err := centerClientGoc.Get(configGoc.center + "/v1/cover/list")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("response code %d", resp.StatusCode)
+	}
+	var services map[string][]string
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return false, err
+	}
+	for _, addr := range services[selfNameGoc()] {
+		if listed, err := url.Parse(addr); err == nil && listed.Port() == u.Port() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var (
+	profileTableOnceGoc sync.Once
+	profileFilesGoc     []string // file names in the order of the block table
+	profileTableGoc     []byte   // the encoded block table, it never changes
+	profileBuildIDGoc   string   // hash of the block table
+)
+
+// loadProfileTableGoc encodes the block table once, the compact profile
+// reuses it and the center only needs it once per build id.
+func loadProfileTableGoc() {
+	counters, blocks := loadValuesGoc()
+	for name := range counters {
+		profileFilesGoc = append(profileFilesGoc, name)
+	}
+	sort.Strings(profileFilesGoc)
+
+	var buf bytes.Buffer
+	putUvarintGoc(&buf, uint64(len(profileFilesGoc)))
+	for _, name := range profileFilesGoc {
+		putStringGoc(&buf, name)
+		block := blocks[name]
+		putUvarintGoc(&buf, uint64(len(block)))
+		for _, b := range block {
+			putUvarintGoc(&buf, uint64(b.Line0))
+			putUvarintGoc(&buf, uint64(b.Col0))
+			putUvarintGoc(&buf, uint64(b.Line1))
+			putUvarintGoc(&buf, uint64(b.Col1))
+			putUvarintGoc(&buf, uint64(b.Stmts))
+		}
+	}
+	profileTableGoc = buf.Bytes()
+
+	sum := sha256.Sum256(append([]byte("{{.Mode}}\n"), profileTableGoc...))
+	profileBuildIDGoc = hex.EncodeToString(sum[:16])
+}
+
+// writeCompactProfileGoc writes the profile in the compact encoding,
+// the block table is left out if the client already holds it.
+func writeCompactProfileGoc(w *bufio.Writer, counterOnly bool) {
+	var flags uint64 = 1
+	if counterOnly {
+		flags = 0
+	}
+	w.WriteString("GOCP")
+	putUvarintGoc(w, 1)
+	putUvarintGoc(w, flags)
+	putStringGoc(w, profileBuildIDGoc)
+	putStringGoc(w, "{{.Mode}}")
+	if !counterOnly {
+		w.Write(profileTableGoc)
+	}
+
+	counters, _ := loadValuesGoc()
+	var n int
+	for _, name := range profileFilesGoc {
+		n += len(counters[name])
+	}
+	putUvarintGoc(w, uint64(n))
+	for _, name := range profileFilesGoc {
+		counts := counters[name]
+		for i := range counts {
+			putUvarintGoc(w, uint64(atomic.LoadUint32(&counts[i])))
+		}
+	}
+}
+
+func putUvarintGoc(w io.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func putStringGoc(w io.Writer, s string) {
+	putUvarintGoc(w, uint64(len(s)))
+	io.WriteString(w, s)
+}
+
+// acceptsGoc reports whether the comma separated header value lists the token
+func acceptsGoc(header, token string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if i := strings.Index(v, ";"); i >= 0 {
+			v = v[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// centerClientGoc talks to the center, a dead center must not hang the agent
+var centerClientGoc = &http.Client{Timeout: 10 * time.Second}
+
+// selfNameGoc is the name the agent registers under
+func selfNameGoc() string {
+	if name, ok := os.LookupEnv("GOC_SERVICE_NAME"); ok {
+		return name
+	}
+	if libraryGoc != "" {
+		return libraryGoc
+	}
+	return filepath.Base(os.Args[0])
 }
 
 func registerSelfGoc(address string) ([]byte, error) {
-	customServiceName, ok := os.LookupEnv("GOC_SERVICE_NAME")
-	var selfName string
-	if ok {
-		selfName = customServiceName
-	} else {
-		selfName = filepath.Base(os.Args[0])
+	query := url.Values{}
+	query.Set("name", selfNameGoc())
+	query.Set("address", address)
+	if configGoc.advertiseAddr != "" {
+		// the address is set explicitly, the center should not revise it
+		query.Set("ip_revise", "false")
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/register?name=%s&address=%s", {{.Center | printf "%q"}}, selfName, address), nil)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/register?%s", configGoc.center, query.Encode()), nil)
 	if err != nil {
-		_log.Fatalf("http.NewRequest failed: %v", err)
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil && isNetworkErrorGoc(err) {
-		_log.Printf("[goc][WARN]error occurred:%v, try again", err)
-		resp, err = http.DefaultClient.Do(req)
-	}
+	resp, err := centerClientGoc.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register into coverage center, err:%v", err)
 	}
@@ -265,17 +995,17 @@ func deregisterSelfGoc(address []string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/remove", {{.Center | printf "%q"}}), bytes.NewReader(jsonBody))
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/cover/remove", configGoc.center), bytes.NewReader(jsonBody))
 	if err != nil {
-		_log.Fatalf("http.NewRequest failed: %v", err)
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := centerClientGoc.Do(req)
 	if err != nil && isNetworkErrorGoc(err) {
-		_log.Printf("[goc][WARN]error occurred:%v, try again", err)
-		resp, err = http.DefaultClient.Do(req)
+		logfGoc(logWarnGoc, "error occurred:%v, try again", err)
+		req.Body = ioutil.NopCloser(bytes.NewReader(jsonBody))
+		resp, err = centerClientGoc.Do(req)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to deregister into coverage center, err:%v", err)
@@ -302,7 +1032,7 @@ func watchSignalGoc(fn CallbackGocFunc) {
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	for {
 		si := <-c
-		_log.Printf("get a signal %s", si.String())
+		logfGoc(logInfoGoc, "get a signal %s", si.String())
 		switch si {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
 			fn()
@@ -323,9 +1053,10 @@ func isNetworkErrorGoc(err error) bool {
 }
 
 func listenGoc() (ln net.Listener, host string, err error) {
-	agentPort := "{{.AgentPort }}"
+	// "tcp" listens on both IPv4 and IPv6 when the host supports it
+	agentPort := configGoc.listenAddr
 	if agentPort != "" {
-		if ln, err = net.Listen("tcp4", agentPort); err != nil {
+		if ln, err = net.Listen("tcp", agentPort); err != nil {
 			return
 		}
 		if host, err = getRealHostGoc(ln); err != nil {
@@ -334,15 +1065,19 @@ func listenGoc() (ln net.Listener, host string, err error) {
 	} else {
 		// 获取上次使用的监听地址
 		if previousAddr := getPreviousAddrGoc(); previousAddr != "" {
-			ss := strings.Split(previousAddr, ":")
-			// listenGoc on all network interface
-			ln, err = net.Listen("tcp4", ":"+ss[len(ss)-1])
-			if err == nil {
-				host = previousAddr
-				return
+			if _, port, perr := net.SplitHostPort(previousAddr); perr == nil {
+				// listenGoc on all network interface
+				ln, err = net.Listen("tcp", ":"+port)
+				if err == nil {
+					host = previousAddr
+					if configGoc.advertiseAddr != "" {
+						host = advertiseHostGoc(configGoc.advertiseAddr, ln, host)
+					}
+					return
+				}
 			}
 		}
-		if ln, err = net.Listen("tcp4", ":0"); err != nil {
+		if ln, err = net.Listen("tcp", ":0"); err != nil {
 			return
 		}
 		if host, err = getRealHostGoc(ln); err != nil {
@@ -350,33 +1085,107 @@ func listenGoc() (ln net.Listener, host string, err error) {
 		}
 	}
 	go genProfileAddrGoc(host)
+	if configGoc.advertiseAddr != "" {
+		host = advertiseHostGoc(configGoc.advertiseAddr, ln, host)
+	}
 	return
 }
 
-func getRealHostGoc(ln net.Listener) (host string, err error) {
-	adds, err := net.InterfaceAddrs()
-	if err != nil {
-		return
+// advertiseHostGoc returns the address to register given GOC_ADVERTISE_ADDR:
+//   iface:<name>   the first usable address of the network interface
+//   cidr:<prefix>  the first local address inside the prefix
+//   hostname       the host name of the machine
+//   <host>[:port]  the host, IPv4 or IPv6 address as is
+// the port of the listener is used if the address has none.
+// fallback is kept when the address cannot be resolved.
+func advertiseHostGoc(addr string, ln net.Listener, fallback string) string {
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	switch {
+	case strings.HasPrefix(addr, "iface:"):
+		name := strings.TrimPrefix(addr, "iface:")
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			logfGoc(logWarnGoc, "cannot find interface %s, advertise %s, err: %v", name, fallback, err)
+			return fallback
+		}
+		adds, err := iface.Addrs()
+		if ips := sortedIPsGoc(adds); err == nil && len(ips) > 0 {
+			return net.JoinHostPort(ips[0].String(), port)
+		}
+		logfGoc(logWarnGoc, "no usable address on interface %s, advertise %s", name, fallback)
+		return fallback
+	case strings.HasPrefix(addr, "cidr:"):
+		prefix := strings.TrimPrefix(addr, "cidr:")
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			logfGoc(logWarnGoc, "invalid cidr %s, advertise %s, err: %v", prefix, fallback, err)
+			return fallback
+		}
+		adds, _ := net.InterfaceAddrs()
+		for _, ip := range sortedIPsGoc(adds) {
+			if ipNet.Contains(ip) {
+				return net.JoinHostPort(ip.String(), port)
+			}
+		}
+		logfGoc(logWarnGoc, "no local address inside %s, advertise %s", prefix, fallback)
+		return fallback
+	case addr == "hostname":
+		name, err := os.Hostname()
+		if err != nil {
+			logfGoc(logWarnGoc, "cannot get hostname, advertise %s, err: %v", fallback, err)
+			return fallback
+		}
+		return net.JoinHostPort(name, port)
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
 	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
 
-	var localIPV4 string
-	var nonLocalIPV4 string
+// sortedIPsGoc returns the usable addresses in a stable order: non-loopback
+// IPv4, non-loopback IPv6, then loopback. Link-local addresses are skipped,
+// they cannot be reached without a zone.
+func sortedIPsGoc(adds []net.Addr) []net.IP {
+	rank := func(ip net.IP) int {
+		r := 0
+		if ip.IsLoopback() {
+			r += 2
+		}
+		if ip.To4() == nil {
+			r++
+		}
+		return r
+	}
+	var ips []net.IP
 	for _, addr := range adds {
-		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
-			if ipNet.IP.IsLoopback() {
-				localIPV4 = ipNet.IP.String()
-			} else {
-				nonLocalIPV4 = ipNet.IP.String()
-			}
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsLinkLocalMulticast() {
+			continue
 		}
+		ips = append(ips, ipNet.IP)
 	}
-	if nonLocalIPV4 != "" {
-		host = fmt.Sprintf("%s:%d", nonLocalIPV4, ln.Addr().(*net.TCPAddr).Port)
-	} else {
-		host = fmt.Sprintf("%s:%d", localIPV4, ln.Addr().(*net.TCPAddr).Port)
+	sort.SliceStable(ips, func(i, j int) bool { return rank(ips[i]) < rank(ips[j]) })
+	return ips
+}
+
+func getRealHostGoc(ln net.Listener) (host string, err error) {
+	tcpAddr := ln.Addr().(*net.TCPAddr)
+	port := strconv.Itoa(tcpAddr.Port)
+	// bound to one address, that is the only reachable one
+	if !tcpAddr.IP.IsUnspecified() {
+		return net.JoinHostPort(tcpAddr.IP.String(), port), nil
 	}
 
-	return
+	adds, err := net.InterfaceAddrs()
+	if err != nil {
+		return
+	}
+	ips := sortedIPsGoc(adds)
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no usable network address found")
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
 }
 
 func getAllHostsGoc(ln net.Listener) (hosts []string, err error) {
@@ -385,38 +1194,56 @@ func getAllHostsGoc(ln net.Listener) (hosts []string, err error) {
 		return
 	}
 
-	var host string
-	for _, addr := range adds {
-		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
-			host = fmt.Sprintf("%s:%d", ipNet.IP.String(), ln.Addr().(*net.TCPAddr).Port)
-			hosts = append(hosts, host)
-		}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	for _, ip := range sortedIPsGoc(adds) {
+		hosts = append(hosts, net.JoinHostPort(ip.String(), port))
 	}
 	return
 }
 
-func getPreviousAddrGoc() string {
-	file, err := os.Open(os.Args[0] + "_profile_listen_addr")
-	if err != nil {
-		return ""
+// stateFilesGoc lists where the listen address state file may live:
+// GOC_STATE_DIR if set, otherwise next to the binary, then the temp dir for read-only root filesystems
+// and named after the library for a library, not to take the one of the process loading it
+func stateFilesGoc() []string {
+	self := os.Args[0]
+	if libraryGoc != "" {
+		self = filepath.Join(filepath.Dir(self), libraryGoc)
 	}
-	defer file.Close()
+	name := filepath.Base(self) + "_profile_listen_addr"
+	if configGoc.stateDir != "" {
+		return []string{filepath.Join(configGoc.stateDir, name)}
+	}
+	return []string{self + "_profile_listen_addr", filepath.Join(os.TempDir(), name)}
+}
 
-	reader := bufio.NewReader(file)
-	addr, _, _ := reader.ReadLine()
-	return string(addr)
+func getPreviousAddrGoc() string {
+	for _, fn := range stateFilesGoc() {
+		file, err := os.Open(fn)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		reader := bufio.NewReader(file)
+		addr, _, _ := reader.ReadLine()
+		return string(addr)
+	}
+	return ""
 }
 
 func genProfileAddrGoc(profileAddr string) {
-	fn := os.Args[0] + "_profile_listen_addr"
-	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		_log.Println(err)
+	for _, fn := range stateFilesGoc() {
+		f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			logfGoc(logDebugGoc, "cannot write state file: %v", err)
+			continue
+		}
+		defer f.Close()
+
+		fmt.Fprint(f, strings.TrimPrefix(profileAddr, "http://"))
 		return
 	}
-	defer f.Close()
-
-	fmt.Fprintf(f, strings.TrimPrefix(profileAddr, "http://"))
+	logfGoc(logWarnGoc, "no writable place for the listen address state file, set GOC_STATE_DIR")
 }
 `
 
@@ -505,6 +1332,9 @@ func injectGlobalCoverVarFile(ci *CoverInfo, content string) error {
 	defer coverFile.Close()
 
 	packageName := "package " + filepath.Base(ci.GlobalCoverVarImportPath) + "\n\n"
+	if ci.Mode == tool.BranchMode {
+		packageName += "import \"sync/atomic\"\n" + tool.BranchHelperDecl
+	}
 
 	_, err = coverFile.WriteString(packageName)
 	if err != nil {
@@ -0,0 +1,71 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentReregister(t *testing.T) {
+	center := &server{Store: NewMemoryStore()}
+	ts := httptest.NewServer(center.Route(ioutil.Discard))
+	defer ts.Close()
+	dir := buildWithToolexec(t, &ToolexecConfig{Mode: "count", Center: ts.URL})
+	defer os.RemoveAll(dir)
+
+	listed := func() bool { return len(center.Store.Get("n")) > 0 }
+	waitListed := func() bool {
+		for i := 0; i < 100; i++ {
+			if listed() {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+	run := func(env ...string) *exec.Cmd {
+		run := exec.Command(filepath.Join(dir, "n"))
+		run.Env = append(os.Environ(), append([]string{"GOC_REGISTER_INTERVAL=100ms"}, env...)...)
+		assert.NoError(t, run.Start())
+		return run
+	}
+
+	// the center forgets the agent, goc init or a restart without its store, the agent registers again
+	agent := run()
+	assert.True(t, waitListed())
+	assert.NoError(t, center.Store.Init())
+	assert.True(t, waitListed())
+	agent.Process.Kill()
+	agent.Wait()
+	assert.NoError(t, center.Store.Init())
+
+	// unless told not to
+	agent = run("GOC_REREGISTER=false")
+	defer agent.Process.Kill()
+	assert.True(t, waitListed())
+	assert.NoError(t, center.Store.Init())
+	time.Sleep(time.Second)
+	assert.False(t, listed())
+}
@@ -0,0 +1,189 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package tool
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+)
+
+// Branch coverage records, besides the basic blocks, every outcome of the
+// conditions of if and for statements, of each operand of && and ||, and
+// every arm of switch, type switch and select statements.
+//
+// Each arm owns a counter in the Branch array of the file variable. Arms of
+// the same branch point share its position, BranchArm tells them apart.
+
+// BranchMode is the cover mode that adds branch counters to the atomic block counters
+const BranchMode = "branch"
+
+// BranchHelper is the function the instrumented conditions call. It is
+// declared once in the global cover variable package.
+const BranchHelper = "GoCoverBranch"
+
+// BranchHelperDecl is the declaration of BranchHelper, it needs sync/atomic imported.
+const BranchHelperDecl = `
+// GoCoverBranch counts the outcome of a condition into counters[i] if true, counters[i+1] otherwise
+func GoCoverBranch(cond bool, counters []uint32, i int) bool {
+	if !cond {
+		i++
+	}
+	atomic.AddUint32(&counters[i], 1)
+	return cond
+}
+`
+
+// branch point kinds, encoded in the high 4 bits of BranchArm
+const (
+	BranchKindIf = iota + 1
+	BranchKindFor
+	BranchKindCond
+	BranchKindSwitch
+	BranchKindTypeSwitch
+	BranchKindSelect
+)
+
+// arms of conditions, switch arms are the index of the clause
+const (
+	BranchArmTrue = iota
+	BranchArmFalse
+
+	// BranchArmDefault is the implicit default arm of a switch without one
+	BranchArmDefault = 0xFFF
+)
+
+// Branch is one arm of a branch point
+type Branch struct {
+	start token.Pos
+	end   token.Pos
+	kind  int
+	arm   int
+}
+
+func (f *File) newBranch(start, end token.Pos, kind, arm int) int {
+	f.branches = append(f.branches, Branch{start, end, kind, arm})
+	return len(f.branches) - 1
+}
+
+// branchCounter returns the statement counting the arm
+func (f *File) branchCounter(start, end token.Pos, kind, arm int) string {
+	return f.counterStmt(f, fmt.Sprintf("%s.Branch[%d]", f.varVar, f.newBranch(start, end, kind, arm)))
+}
+
+// addBranchCond wraps the condition so both of its outcomes are counted,
+// and each operand too if the condition is a chain of && and ||:
+//
+//	if a && b {
+//
+// becomes
+//
+//	if GoCoverBranch(bool(GoCoverBranch(bool(a), V.Branch[:], 2) && GoCoverBranch(bool(b), V.Branch[:], 4)), V.Branch[:], 0) {
+//
+// The bool conversions keep conditions of named boolean types compiling.
+func (f *File) addBranchCond(e ast.Expr, kind int) {
+	i := f.newBranch(e.Pos(), e.End(), kind, BranchArmTrue)
+	f.newBranch(e.Pos(), e.End(), kind, BranchArmFalse)
+
+	f.edit.Insert(f.offset(e.Pos()), BranchHelper+"(bool(")
+	if isShortCircuit(e) {
+		f.wrapOperands(e)
+	}
+	f.edit.Insert(f.offset(e.End()), fmt.Sprintf("), %s.Branch[:], %d)", f.varVar, i))
+}
+
+// wrapOperands wraps the leaves of a chain of && and ||
+func (f *File) wrapOperands(e ast.Expr) {
+	switch n := e.(type) {
+	case *ast.ParenExpr:
+		f.wrapOperands(n.X)
+	case *ast.UnaryExpr:
+		if n.Op == token.NOT {
+			f.wrapOperands(n.X)
+			return
+		}
+		f.addBranchCond(e, BranchKindCond)
+	case *ast.BinaryExpr:
+		if n.Op == token.LAND || n.Op == token.LOR {
+			f.wrapOperands(n.X)
+			f.wrapOperands(n.Y)
+			return
+		}
+		f.addBranchCond(e, BranchKindCond)
+	default:
+		f.addBranchCond(e, BranchKindCond)
+	}
+}
+
+// isShortCircuit reports whether e is a chain of && and ||, maybe negated or parenthesized
+func isShortCircuit(e ast.Expr) bool {
+	switch n := e.(type) {
+	case *ast.ParenExpr:
+		return isShortCircuit(n.X)
+	case *ast.UnaryExpr:
+		return n.Op == token.NOT && isShortCircuit(n.X)
+	case *ast.BinaryExpr:
+		return n.Op == token.LAND || n.Op == token.LOR
+	}
+	return false
+}
+
+// addBranchArms counts every clause of a switch, type switch or select.
+// A switch without default gets one, so the path where no case matches is counted.
+// Select is left alone, a default would make it non-blocking.
+func (f *File) addBranchArms(stmt ast.Stmt, body *ast.BlockStmt, kind int) {
+	start, end := stmt.Pos(), body.Lbrace
+	hasDefault := false
+	for i, s := range body.List {
+		switch clause := s.(type) {
+		case *ast.CaseClause:
+			hasDefault = hasDefault || clause.List == nil
+			f.edit.Insert(f.offset(clause.Colon+1), f.branchCounter(start, end, kind, i)+";")
+		case *ast.CommClause:
+			f.edit.Insert(f.offset(clause.Colon+1), f.branchCounter(start, end, kind, i)+";")
+		}
+	}
+	if kind != BranchKindSelect && !hasDefault {
+		f.edit.Insert(f.offset(body.Rbrace), "default: "+f.branchCounter(start, end, kind, BranchArmDefault)+";")
+	}
+}
+
+// addBranchFields adds the branch fields to the declaration of the file variable
+func (f *File) addBranchFields(w io.Writer) {
+	fmt.Fprintf(w, "\tBranch    [%d]uint32\n", len(f.branches))
+	fmt.Fprintf(w, "\tBranchPos [3 * %d]uint32\n", len(f.branches))
+	fmt.Fprintf(w, "\tBranchArm [%d]uint16\n", len(f.branches))
+}
+
+// addBranchValues initializes the branch fields, positions are encoded like Pos
+// and BranchArm holds the kind in the high 4 bits and the arm in the low 12 bits.
+func (f *File) addBranchValues(w io.Writer) {
+	fmt.Fprintf(w, "\tBranchPos: [3 * %d]uint32{\n", len(f.branches))
+	for i, b := range f.branches {
+		start := f.fset.Position(b.start)
+		end := f.fset.Position(b.end)
+		fmt.Fprintf(w, "\t\t%d, %d, %#x, // [%d]\n", start.Line, end.Line, (end.Column&0xFFFF)<<16|(start.Column&0xFFFF), i)
+	}
+	fmt.Fprintf(w, "\t},\n")
+
+	fmt.Fprintf(w, "\tBranchArm: [%d]uint16{\n", len(f.branches))
+	for i, b := range f.branches {
+		fmt.Fprintf(w, "\t\t%#x, // %d\n", b.kind<<12|b.arm&0xFFF, i)
+	}
+	fmt.Fprintf(w, "\t},\n")
+}
@@ -18,7 +18,7 @@ import (
 	"os"
 	"sort"
 
-	log "github.com/sirupsen/logrus" // QINIU
+	"github.com/sirupsen/logrus" // QINIU
 	// "cmd/internal/edit"
 	// "cmd/internal/objabi"
 )
@@ -60,7 +60,9 @@ import (
 
 // var profile string // The profile to read; the value of -html or -func
 
-var counterStmt func(*File, string) string
+// QINIU
+// counterStmt is a field of File, so that files can be annotated concurrently
+// var counterStmt func(*File, string) string
 
 const (
 	atomicPackagePath = "sync/atomic"
@@ -157,14 +159,19 @@ type Block struct {
 // File is a wrapper for the state of a file used in the parser.
 // The basic parse tree walker is a method of this type.
 type File struct {
-	fset    *token.FileSet
-	name    string // Name of file.
-	astFile *ast.File
-	blocks  []Block
-	content []byte
-	edit    *Buffer // QINIU
-	varVar  string  // QINIU
-	mode    string  // QINIU
+	fset     *token.FileSet
+	name     string // Name of file.
+	astFile  *ast.File
+	blocks   []Block
+	content  []byte
+	edit     *Buffer  // QINIU
+	varVar   string   // QINIU
+	mode     string   // QINIU
+	branch   bool     // QINIU, count branches too
+	branches []Branch // QINIU
+
+	counterStmt func(*File, string) string // QINIU
+	seenPos2    map[pos2]bool              // QINIU
 }
 
 // findText finds text in the original source, starting at pos.
@@ -229,6 +236,9 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 		if n.Init != nil {
 			ast.Walk(f, n.Init)
 		}
+		if f.branch { // QINIU
+			f.addBranchCond(n.Cond, BranchKindIf)
+		}
 		ast.Walk(f, n.Cond)
 		ast.Walk(f, n.Body)
 		if n.Else == nil {
@@ -272,11 +282,18 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 		}
 		ast.Walk(f, n.Else)
 		return nil
+	case *ast.ForStmt: // QINIU
+		if f.branch && n.Cond != nil {
+			f.addBranchCond(n.Cond, BranchKindFor)
+		}
 	case *ast.SelectStmt:
 		// Don't annotate an empty select - creates a syntax error.
 		if n.Body == nil || len(n.Body.List) == 0 {
 			return nil
 		}
+		if f.branch { // QINIU
+			f.addBranchArms(n, n.Body, BranchKindSelect)
+		}
 	case *ast.SwitchStmt:
 		// Don't annotate an empty switch - creates a syntax error.
 		if n.Body == nil || len(n.Body.List) == 0 {
@@ -288,6 +305,9 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			}
 			return nil
 		}
+		if f.branch { // QINIU
+			f.addBranchArms(n, n.Body, BranchKindSwitch)
+		}
 	case *ast.TypeSwitchStmt:
 		// Don't annotate an empty type switch - creates a syntax error.
 		if n.Body == nil || len(n.Body.List) == 0 {
@@ -297,23 +317,37 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			ast.Walk(f, n.Assign)
 			return nil
 		}
+		if f.branch { // QINIU
+			f.addBranchArms(n, n.Body, BranchKindTypeSwitch)
+		}
 	}
 	return f
 }
 
+// QINIU
+// Log is the logger of the annotator
+var Log logrus.FieldLogger = logrus.StandardLogger()
+
 // QINIU
 // Annotate do following
 // 1. add cover variables into the original file
 // 2. return the cover variables declarations as plain string
 // original dec: func annotate(name string) {
-func Annotate(name string, mode string, varVar string, globalCoverVarImportPath string) string {
+func Annotate(name string, mode string, varVar string, globalCoverVarImportPath string) (decl string, err error) {
 	// QINIU
+	// the annotator panics on the source it cannot handle, that must not take the caller down
+	defer func() {
+		if r := recover(); r != nil {
+			decl, err = "", fmt.Errorf("cover: %s: %v", name, r)
+		}
+	}()
+	var counterStmt func(*File, string) string
 	switch mode {
 	case "set":
 		counterStmt = setCounterStmt
 	case "count":
 		counterStmt = incCounterStmt
-	case "atomic":
+	case "atomic", BranchMode:
 		counterStmt = atomicCounterStmt
 	default:
 		counterStmt = incCounterStmt
@@ -322,39 +356,46 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 	fset := token.NewFileSet()
 	content, err := ioutil.ReadFile(name)
 	if err != nil {
-		log.Fatalf("cover: %s: %s", name, err)
+		return "", fmt.Errorf("cover: %v", err)
 	}
 	parsedFile, err := parser.ParseFile(fset, name, content, parser.ParseComments)
 	if err != nil {
-		log.Fatalf("cover: %s: %s", name, err)
+		// the error tells the position, file:line:column
+		return "", fmt.Errorf("cover: %v", err)
 	}
 
 	file := &File{
-		fset:    fset,
-		name:    name,
-		content: content,
-		edit:    NewBuffer(content), // QINIU
-		astFile: parsedFile,
-		varVar:  varVar,
-		mode:    mode,
+		fset:        fset,
+		name:        name,
+		content:     content,
+		edit:        NewBuffer(content), // QINIU
+		astFile:     parsedFile,
+		varVar:      varVar,
+		mode:        mode,
+		branch:      mode == BranchMode,
+		counterStmt: counterStmt,
+		seenPos2:    make(map[pos2]bool),
 	}
 
 	ast.Walk(file, file.astFile)
 	newContent := file.edit.Bytes()
 
 	if bytes.Equal(content, newContent) {
-		log.Info("no cover var injected for: ", name)
-	} else if strings.Contains(string(file.content), globalCoverVarImportPath) {
-		log.Info("global cover var already imported for: ", name)
+		Log.Info("no cover var injected for: ", name)
+	} else if globalCoverVarImportPath != "" && strings.Contains(string(file.content), globalCoverVarImportPath) {
+		Log.Info("global cover var already imported for: ", name)
 	} else {
 		// reback to the beginning
 		file.astFile, _ = parser.ParseFile(fset, name, content, parser.ParseComments)
 		file.edit = NewBuffer(newContent)
 		// add global cover variables import path
-		file.edit.Insert(file.offset(file.astFile.Name.End()),
-			fmt.Sprintf("; import %s %q", ".", globalCoverVarImportPath))
+		// QINIU, without one the counters are declared in the package of the file
+		if globalCoverVarImportPath != "" {
+			file.edit.Insert(file.offset(file.astFile.Name.End()),
+				fmt.Sprintf("; import %s %q", ".", globalCoverVarImportPath))
+		}
 
-		if mode == "atomic" {
+		if mode == "atomic" || mode == BranchMode {
 			// Add import of sync/atomic immediately after package clause.
 			// We do this even if there is an existing import, because the
 			// existing import may be shadowed at any given place we want
@@ -377,14 +418,14 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 	// }
 	fd, err := os.Create(name)
 	if err != nil {
-		log.Fatalf("cover: %s", err)
+		return "", fmt.Errorf("cover: %v", err)
 	}
 	defer fd.Close()
 
 	fmt.Fprintf(fd, "//line %s:1\n", name)
 	_, err = fd.Write(newContent)
 	if err != nil {
-		log.Fatalf("cover: %s", err)
+		return "", fmt.Errorf("cover: %v", err)
 	}
 
 	// After printing the source tree, add some declarations for the counters etc.
@@ -395,7 +436,53 @@ func Annotate(name string, mode string, varVar string, globalCoverVarImportPath
 	// we will write all declarations into a single file
 	declBuf := bytes.NewBufferString("")
 	file.addVariables(declBuf)
-	return declBuf.String()
+	return declBuf.String(), nil
+}
+
+// ProfileBlock is a basic block as written in coverage profiles. // QINIU
+type ProfileBlock struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	NumStmt             int
+}
+
+// Blocks returns the basic blocks Annotate would count in the file, without changing it. // QINIU
+func Blocks(name string) ([]ProfileBlock, error) {
+	fset := token.NewFileSet()
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cover: %v", err)
+	}
+	parsedFile, err := parser.ParseFile(fset, name, content, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("cover: %v", err)
+	}
+
+	file := &File{
+		fset:        fset,
+		name:        name,
+		content:     content,
+		edit:        NewBuffer(content),
+		astFile:     parsedFile,
+		varVar:      "GoCover",
+		mode:        "set",
+		counterStmt: setCounterStmt,
+		seenPos2:    make(map[pos2]bool),
+	}
+	ast.Walk(file, file.astFile)
+
+	blocks := make([]ProfileBlock, 0, len(file.blocks))
+	for _, block := range file.blocks {
+		start, end := file.position(block)
+		blocks = append(blocks, ProfileBlock{
+			StartLine: start.Line,
+			StartCol:  start.Column & 0xFFFF,
+			EndLine:   end.Line,
+			EndCol:    end.Column & 0xFFFF,
+			NumStmt:   clampStmts(block.numStmt),
+		})
+	}
+	return blocks, nil
 }
 
 // setCounterStmt returns the expression: __count[23] = 1.
@@ -416,7 +503,7 @@ func atomicCounterStmt(f *File, counter string) string {
 // QINIU
 // newCounter creates a new counter expression of the appropriate form.
 func (f *File) newCounter(start, end token.Pos, numStmt int) string {
-	stmt := counterStmt(f, fmt.Sprintf("%s.Count[%d]", f.varVar, len(f.blocks)))
+	stmt := f.counterStmt(f, fmt.Sprintf("%s.Count[%d]", f.varVar, len(f.blocks)))
 	f.blocks = append(f.blocks, Block{start, end, numStmt})
 	return stmt
 }
@@ -693,6 +780,9 @@ func (f *File) addVariables(w io.Writer) {
 	fmt.Fprintf(w, "\tCount     [%d]uint32\n", len(f.blocks))
 	fmt.Fprintf(w, "\tPos       [3 * %d]uint32\n", len(f.blocks))
 	fmt.Fprintf(w, "\tNumStmt   [%d]uint16\n", len(f.blocks))
+	if f.branch { // QINIU
+		f.addBranchFields(w)
+	}
 	fmt.Fprintf(w, "} {\n")
 
 	// Initialize the position array field.
@@ -703,11 +793,7 @@ func (f *File) addVariables(w io.Writer) {
 	// - 32-bit ending line number
 	// - (16 bit ending column number << 16) | (16-bit starting column number).
 	for i, block := range f.blocks {
-		start := f.fset.Position(block.startByte)
-		end := f.fset.Position(block.endByte)
-
-		start, end = dedup(start, end)
-
+		start, end := f.position(block)
 		fmt.Fprintf(w, "\t\t%d, %d, %#x, // [%d]\n", start.Line, end.Line, (end.Column&0xFFFF)<<16|(start.Column&0xFFFF), i)
 	}
 
@@ -721,16 +807,16 @@ func (f *File) addVariables(w io.Writer) {
 	// valuation of "percent covered". To save space, it's a 16-bit number, so we
 	// clamp it if it overflows - won't matter in practice.
 	for i, block := range f.blocks {
-		n := block.numStmt
-		if n > 1<<16-1 {
-			n = 1<<16 - 1
-		}
-		fmt.Fprintf(w, "\t\t%d, // %d\n", n, i)
+		fmt.Fprintf(w, "\t\t%d, // %d\n", clampStmts(block.numStmt), i)
 	}
 
 	// Close the statements-per-block array.
 	fmt.Fprintf(w, "\t},\n")
 
+	if f.branch { // QINIU
+		f.addBranchValues(w)
+	}
+
 	// Close the struct initialization.
 	fmt.Fprintf(w, "}\n")
 
@@ -741,6 +827,19 @@ func (f *File) addVariables(w io.Writer) {
 	// }
 }
 
+// position returns the deduplicated start and end of the block. // QINIU
+func (f *File) position(block Block) (start, end token.Position) {
+	return f.dedup(f.fset.Position(block.startByte), f.fset.Position(block.endByte))
+}
+
+// clampStmts clamps the statements of a block to 16 bits. // QINIU
+func clampStmts(n int) int {
+	if n > 1<<16-1 {
+		return 1<<16 - 1
+	}
+	return n
+}
+
 // It is possible for positions to repeat when there is a line
 // directive that does not specify column information and the input
 // has not been passed through gofmt.
@@ -753,13 +852,15 @@ type pos2 struct {
 	p1, p2 token.Position
 }
 
-// seenPos2 tracks whether we have seen a token.Position pair.
-var seenPos2 = make(map[pos2]bool)
+// QINIU
+// seenPos2 tracks whether we have seen a token.Position pair, it is a field of File
+// so that files can be annotated concurrently, and again in the same process.
+// var seenPos2 = make(map[pos2]bool)
 
 // dedup takes a token.Position pair and returns a pair that does not
 // duplicate any existing pair. The returned pair will have the Offset
 // fields cleared.
-func dedup(p1, p2 token.Position) (r1, r2 token.Position) {
+func (f *File) dedup(p1, p2 token.Position) (r1, r2 token.Position) { // QINIU
 	key := pos2{
 		p1: p1,
 		p2: p2,
@@ -770,10 +871,10 @@ func dedup(p1, p2 token.Position) (r1, r2 token.Position) {
 	key.p1.Offset = 0
 	key.p2.Offset = 0
 
-	for seenPos2[key] {
+	for f.seenPos2[key] {
 		key.p2.Column++
 	}
-	seenPos2[key] = true
+	f.seenPos2[key] = true
 
 	return key.p1, key.p2
 }
@@ -0,0 +1,218 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+)
+
+// ManifestSuffix is appended to the binary name to name its build manifest
+const ManifestSuffix = ".goc.json"
+
+// Manifest describes what an instrumented build covers
+type Manifest struct {
+	// Hash identifies the build, the binary embeds it and its agent reports it, see ComputeHash
+	Hash string `json:"hash,omitempty"`
+
+	GocVersion string `json:"gocVersion,omitempty"`
+	GoVersion  string `json:"goVersion,omitempty"`
+	Module     string `json:"module,omitempty"`
+	Revision   string `json:"revision,omitempty"` // VCS revision of the project, with a +dirty suffix if it has local changes
+
+	Mode      string            `json:"mode"`
+	Center    string            `json:"center,omitempty"`
+	AgentPort string            `json:"agentPort,omitempty"`
+	Packages  []ManifestPackage `json:"packages,omitempty"` // the instrumented packages
+	Skipped   []SkippedFile     `json:"skipped"`            // files left without counters
+
+	// with --unlinked, the packages no main package links and their zero count profile
+	Unlinked        []string `json:"unlinked,omitempty"`
+	UnlinkedProfile string   `json:"unlinkedProfile,omitempty"`
+
+	// native if built with the toolchain's -cover, see BackendNative
+	Backend string `json:"backend,omitempty"`
+
+	// the files with counters, named after the import path of their package
+	Instrumented []string `json:"instrumented,omitempty"`
+	// with goc cover --export, how to build the exported tree
+	Export *ExportInfo `json:"export,omitempty"`
+}
+
+// ExportManifestFile names the manifest at the root of a tree goc cover --export wrote
+const ExportManifestFile = "goc-export.json"
+
+// ExportInfo tells an external build system how to build an instrumented tree
+type ExportInfo struct {
+	WorkingDir               string   `json:"workingDir"`               // where to build, relative to the exported tree
+	GlobalCoverVarImportPath string   `json:"globalCoverVarImportPath"` // the package declaring the counters
+	MainPackages             []string `json:"mainPackages"`             // the packages the agent is injected into
+	BuildFlags               string   `json:"buildFlags,omitempty"`     // the flags the build needs, with the native backend
+	GOPATH                   string   `json:"gopath,omitempty"`         // the GOPATH the build needs, for a GOPATH project
+}
+
+// ManifestPackage is an instrumented package of a build
+type ManifestPackage struct {
+	ImportPath string         `json:"importPath"`
+	Files      []ManifestFile `json:"files"`
+}
+
+// ManifestFile is an instrumented file of a build
+type ManifestFile struct {
+	File   string `json:"file"`             // named after the import path of its package
+	SHA256 string `json:"sha256"`           // of the source, before instrumentation
+	Blocks int    `json:"blocks,omitempty"` // the counters, with the goc backend
+	Var    string `json:"var,omitempty"`    // the variable holding them, with the goc backend
+}
+
+// newManifest describes the build of the packages covers holds. The goc backend hashes
+// the files and counts their blocks as it annotates them, see setVars, scan does it otherwise.
+func newManifest(coverInfo *CoverInfo, covers map[string]*PackageCover, filter *fileFilter) (*Manifest, error) {
+	sourceDir := coverInfo.SourceDir
+	if sourceDir == "" {
+		sourceDir = coverInfo.Target
+	}
+	m := &Manifest{
+		GocVersion:   coverInfo.GocVersion,
+		GoVersion:    goVersion(coverInfo.Target, coverInfo.GoPath),
+		Module:       coverInfo.ModRootPath,
+		Revision:     vcsRevision(sourceDir),
+		Mode:         coverInfo.Mode,
+		Center:       coverInfo.Center,
+		AgentPort:    coverInfo.AgentPort,
+		Skipped:      filter.Skipped(),
+		Instrumented: instrumentedFiles(covers),
+	}
+
+	importPaths := make([]string, 0, len(covers))
+	for importPath := range covers {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	for _, importPath := range importPaths {
+		pkg := covers[importPath].Package
+		mp := ManifestPackage{ImportPath: importPath}
+		for _, file := range append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...) {
+			mp.Files = append(mp.Files, ManifestFile{File: path.Join(importPath, file)})
+		}
+		if len(mp.Files) > 0 {
+			m.Packages = append(m.Packages, mp)
+		}
+	}
+	return m, nil
+}
+
+// scan hashes the files of the packages covers holds, which must not be instrumented yet,
+// and counts the blocks the goc backend would give them if blocks
+func (m *Manifest) scan(covers map[string]*PackageCover, blocks bool) error {
+	for i, mp := range m.Packages {
+		pkg := covers[mp.ImportPath].Package
+		for j, f := range mp.Files {
+			file := filepath.Join(pkg.Dir, path.Base(f.File))
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(content)
+			m.Packages[i].Files[j].SHA256 = hex.EncodeToString(sum[:])
+			if !blocks {
+				continue
+			}
+			b, err := tool.Blocks(file)
+			if err != nil {
+				return &InstrumentError{Package: mp.ImportPath, File: path.Base(f.File), Err: err}
+			}
+			m.Packages[i].Files[j].Blocks = len(b)
+		}
+	}
+	return nil
+}
+
+// setVars records the counter variable of each file of the goc backend, with the hash
+// and the blocks the annotation found
+func (m *Manifest) setVars(covers map[string]*PackageCover) {
+	for i, mp := range m.Packages {
+		for j, f := range mp.Files {
+			if v := covers[mp.ImportPath].Vars[path.Base(f.File)]; v != nil {
+				m.Packages[i].Files[j].Var = v.Var
+				if v.SHA256 != "" {
+					m.Packages[i].Files[j].SHA256 = v.SHA256
+					m.Packages[i].Files[j].Blocks = v.Blocks
+				}
+			}
+		}
+	}
+}
+
+// goVersion returns the version of the go command building the project
+func goVersion(dir, gopath string) string {
+	// go version go1.21.3 linux/amd64
+	out, err := GoCommand(dir, gopath, "version").Output()
+	if fields := strings.Fields(string(out)); err == nil && len(fields) > 2 {
+		return fields[2]
+	}
+	return ""
+}
+
+// vcsRevision returns the git revision of the project in dir, empty if it is not in a repository
+func vcsRevision(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	revision := strings.TrimSpace(string(out))
+	cmd = exec.Command("git", "status", "--porcelain", "--untracked-files=no")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+		revision += "+dirty"
+	}
+	return revision
+}
+
+// ComputeHash hashes the manifest but its hash, and the export information
+// which goc cover --export adds once the project is instrumented
+func (m *Manifest) ComputeHash() (string, error) {
+	c := *m
+	c.Hash, c.Export = "", nil
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// Write writes the manifest as json to the file
+func (m *Manifest) Write(file string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,155 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+)
+
+// backends instrumenting the project
+const (
+	// BackendGoc rewrites the sources with the annotator goc carries, the default
+	BackendGoc = "goc"
+	// BackendNative builds with the toolchain's own -cover -coverpkg instrumentation,
+	// goc only injects its agent, which reads the counters with runtime/coverage
+	BackendNative = "native"
+)
+
+// nativeMinGoMinor is the first go 1.x whose go build supports -cover and runtime/coverage
+const nativeMinGoMinor = 20
+
+var (
+	// ErrUnknownBackend represents the error that the instrumentation backend is neither goc nor native
+	ErrUnknownBackend = errors.New("unknown backend, expected goc or native")
+	// ErrNativeCoverUnsupported represents the error that the build can't use the native backend
+	ErrNativeCoverUnsupported = errors.New("native backend unsupported")
+)
+
+// executeNative injects the agent into the main packages and leaves the instrumentation
+// of the packages covers holds to the toolchain, through the BuildFlags of coverInfo
+func executeNative(coverInfo *CoverInfo, manifest *Manifest, pkgs map[string]*Package, mains []*Package, covers map[string]*PackageCover) error {
+	if coverInfo.Mode == tool.BranchMode {
+		return fmt.Errorf("%w: branch mode needs the goc backend", ErrNativeCoverUnsupported)
+	}
+	flags, err := SplitBuildFlags(coverInfo.Args)
+	if err != nil {
+		return err
+	}
+	// the runtime of the process loading a plugin does not read its counters
+	if mode, _ := BuildFlagValue(flags, "buildmode"); mode == "plugin" {
+		return fmt.Errorf("%w: plugins need the goc backend", ErrNativeCoverUnsupported)
+	}
+	if err := checkNativeToolchain(coverInfo.Target, coverInfo.GoPath); err != nil {
+		return err
+	}
+
+	coverPkgs := nativeCoverPackages(covers)
+	if len(coverPkgs) == 0 {
+		return fmt.Errorf("%w: no package of %s to cover", ErrCoverPkgFailed, coverInfo.Target)
+	}
+
+	manifest.Backend = BackendNative
+	if coverInfo.Unlinked {
+		if err := addUnlinked(manifest, coverInfo, pkgs, covers); err != nil {
+			return err
+		}
+	}
+	if manifest.Hash, err = manifest.ComputeHash(); err != nil {
+		return err
+	}
+	// the toolchain instruments every file of a package, the agent drops the skipped ones
+	var skipped []string
+	for _, f := range manifest.Skipped {
+		skipped = append(skipped, f.File)
+	}
+
+	for _, pkg := range mains {
+		tc := TestCover{
+			Mode:            coverInfo.Mode,
+			AgentPort:       coverInfo.AgentPort,
+			Center:          coverInfo.Center,
+			Singleton:       coverInfo.Singleton,
+			MainPkgCover:    covers[pkg.ImportPath],
+			UnlinkedProfile: manifest.UnlinkedProfile,
+			Native:          true,
+			NativeSkip:      append(skipped, path.Join(pkg.ImportPath, httpCoverApisFile), path.Join(pkg.ImportPath, httpCoverExportFile)),
+			ManifestHash:    manifest.Hash,
+		}
+		tc.libraryBuild(flags, pkg)
+		coverInfo.Written = append(coverInfo.Written, agentFiles(tc, pkg)...)
+		if err := injectAgent(tc, pkg); err != nil {
+			return fmt.Errorf("%w: failed to inject the agent into package: %s, err: %v", ErrCoverPkgFailed, pkg.ImportPath, err)
+		}
+	}
+
+	coverInfo.BuildFlags = nativeBuildFlags(coverPkgs)
+	coverInfo.Manifest = manifest
+	log.Infof("%d packages left to the toolchain's instrumentation", len(coverPkgs))
+	return nil
+}
+
+// checkNativeToolchain fails if the go command is older than the native backend needs
+func checkNativeToolchain(dir, gopath string) error {
+	out, err := GoCommand(dir, gopath, "env", "GOVERSION").Output()
+	if err != nil {
+		// go env GOVERSION appeared in go 1.16
+		return fmt.Errorf("%w: go 1.%d or later required", ErrNativeCoverUnsupported, nativeMinGoMinor)
+	}
+	version := strings.TrimSpace(string(out))
+	if minor, ok := goMinorVersion(version); ok && minor < nativeMinGoMinor {
+		return fmt.Errorf("%w: go 1.%d or later required, found %s", ErrNativeCoverUnsupported, nativeMinGoMinor, version)
+	}
+	return nil
+}
+
+// goMinorVersion returns the minor version of a go1.x release, devel builds are not releases
+func goMinorVersion(version string) (int, bool) {
+	if !strings.HasPrefix(version, "go1.") {
+		return 0, false
+	}
+	v := strings.TrimPrefix(version, "go1.")
+	if i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		v = v[:i]
+	}
+	minor, err := strconv.Atoi(v)
+	return minor, err == nil
+}
+
+// nativeCoverPackages returns the sorted import paths of the packages the toolchain instruments
+func nativeCoverPackages(covers map[string]*PackageCover) []string {
+	var coverPkgs []string
+	for importPath, pc := range covers {
+		if len(pc.Package.GoFiles)+len(pc.Package.CgoFiles) > 0 {
+			coverPkgs = append(coverPkgs, importPath)
+		}
+	}
+	sort.Strings(coverPkgs)
+	return coverPkgs
+}
+
+// nativeBuildFlags returns the flags instrumenting coverPkgs with the toolchain:
+// clearing the counters at runtime needs atomic counters, the agent reports them in the mode asked for
+func nativeBuildFlags(coverPkgs []string) string {
+	return "-cover -covermode=atomic -coverpkg=" + strings.Join(coverPkgs, ",")
+}
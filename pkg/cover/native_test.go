@@ -0,0 +1,157 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoMinorVersion(t *testing.T) {
+	items := []struct {
+		version string
+		minor   int
+		ok      bool
+	}{
+		{"go1.20", 20, true},
+		{"go1.21.3", 21, true},
+		{"go1.22rc1", 22, true},
+		{"devel go1.23-8a3d1b4 Mon Jan 1 00:00:00 2024 +0000", 0, false},
+		{"", 0, false},
+	}
+	for _, item := range items {
+		minor, ok := goMinorVersion(item.version)
+		assert.Equal(t, item.minor, minor, item.version)
+		assert.Equal(t, item.ok, ok, item.version)
+	}
+}
+
+var nativeTestFiles = map[string]string{
+	"go.mod": "module example.com/n\n\ngo 1.20\n",
+	"main.go": `package main
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/n/lib"
+)
+
+func main() {
+	fmt.Println(lib.Max(1, 3, 2), lib.Gen())
+	time.Sleep(time.Minute)
+}
+`,
+	"lib/lib.go": `package lib
+
+// Max needs a toolchain which knows generics
+func Max[T int | float64](vs ...T) T {
+	var m T
+	for _, v := range vs {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+`,
+	"lib/gen.go": `// Code generated by hand. DO NOT EDIT.
+
+package lib
+
+func Gen() int { return 1 }
+`,
+}
+
+func TestExecuteNative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-native")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, content := range nativeTestFiles {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	if err := checkNativeToolchain(dir, ""); err != nil {
+		t.Skip(err)
+	}
+
+	err = Execute(&CoverInfo{Target: dir, Mode: "branch", Backend: BackendNative, IsMod: true, ModRootPath: "example.com/n"})
+	assert.True(t, errors.Is(err, ErrNativeCoverUnsupported))
+	err = Execute(&CoverInfo{Target: dir, Args: "-buildmode=plugin", Mode: "count", Backend: BackendNative, IsMod: true, ModRootPath: "example.com/n"})
+	assert.True(t, errors.Is(err, ErrNativeCoverUnsupported))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	ci := &CoverInfo{Target: dir, Mode: "count", AgentPort: addr, Singleton: true, Backend: BackendNative, IsMod: true, ModRootPath: "example.com/n"}
+	assert.NoError(t, Execute(ci))
+	assert.Equal(t, "-cover -covermode=atomic -coverpkg=example.com/n,example.com/n/lib", ci.BuildFlags)
+	assert.Equal(t, BackendNative, ci.Manifest.Backend)
+	assert.Equal(t, []SkippedFile{{File: "example.com/n/lib/gen.go", Reason: SkipGenerated}}, ci.Manifest.Skipped)
+	assert.Equal(t, []string{"example.com/n/lib/lib.go", "example.com/n/main.go"}, ci.Manifest.Instrumented)
+
+	build := exec.Command("go", append([]string{"build", "-o", "n"}, strings.Fields(ci.BuildFlags)...)...)
+	build.Dir = dir
+	out, err := build.CombinedOutput()
+	assert.NoError(t, err, string(out))
+	run := exec.Command(filepath.Join(dir, "n"))
+	assert.NoError(t, run.Start())
+	defer run.Process.Kill()
+
+	profile := func() string {
+		var body []byte
+		for i := 0; i < 50; i++ {
+			if res, err := http.Get("http://" + addr + "/v1/cover/profile"); err == nil {
+				body, _ = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return string(body)
+	}
+	// neither the generated file nor the agent shows up
+	p := profile()
+	assert.True(t, strings.HasPrefix(p, "mode: count\n"), p)
+	assert.Contains(t, p, "example.com/n/lib/lib.go:7.3,7.12 1 3\n")
+	assert.Contains(t, p, "example.com/n/main.go:")
+	assert.NotContains(t, p, "gen.go")
+	assert.NotContains(t, p, httpCoverApisFile)
+
+	// the agent ties its profiles to the manifest
+	assert.Len(t, ci.Manifest.Hash, 32)
+	res, err := http.Get("http://" + addr + "/v1/cover/profile")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, ci.Manifest.Hash, res.Header.Get(ProfileManifestHashHeader))
+
+	res, err = http.Post("http://"+addr+"/v1/cover/clear", "", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Contains(t, profile(), "example.com/n/lib/lib.go:7.3,7.12 1 0\n")
+}
@@ -0,0 +1,96 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+)
+
+// CoverPlan is what Execute would do to the packages of a project, computed without writing any file
+type CoverPlan struct {
+	Mains      []string  `json:"mains"`                // the packages the agent is injected into
+	Manifest   *Manifest `json:"manifest"`             // the manifest of the build, file and block counts included
+	BuildFlags string    `json:"buildFlags,omitempty"` // the flags the build needs on top of Args, with the native backend
+}
+
+// PlanCover plans what Execute would instrument, the packages matching patterns are listed
+// in coverInfo.Target, the project itself rather than its copy
+func PlanCover(coverInfo *CoverInfo, patterns []string) (*CoverPlan, error) {
+	if coverInfo.Backend != "" && coverInfo.Backend != BackendGoc && coverInfo.Backend != BackendNative {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, coverInfo.Backend)
+	}
+	flags, err := SplitBuildFlags(coverInfo.Args)
+	if err != nil {
+		return nil, err
+	}
+	listArgs := append(append(ListFlags(flags), "-json"), patterns...)
+	pkgs, err := ListPackages(coverInfo.Target, listArgs, coverInfo.GoPath)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newFileFilter(coverInfo.IncludeGenerated, coverInfo.SkipPackages, coverInfo.VendorPackages)
+	if err != nil {
+		return nil, err
+	}
+	if err := addVendored(context.Background(), pkgs, coverInfo.Target, flags, coverInfo.GoPath, filter); err != nil {
+		return nil, err
+	}
+	mains, covers := selectCovers(pkgs, filter)
+	manifest, err := newManifest(coverInfo, covers, filter)
+	if err != nil {
+		return nil, err
+	}
+	// nothing is annotated, the files are read to tell what would be
+	if err := manifest.scan(covers, coverInfo.Backend != BackendNative); err != nil {
+		return nil, err
+	}
+
+	plan := &CoverPlan{Manifest: manifest}
+	for _, pkg := range mains {
+		plan.Mains = append(plan.Mains, pkg.ImportPath)
+	}
+	sort.Strings(plan.Mains)
+	if coverInfo.Backend == BackendNative {
+		if coverInfo.Mode == tool.BranchMode {
+			return nil, fmt.Errorf("%w: branch mode needs the goc backend", ErrNativeCoverUnsupported)
+		}
+		if err := checkNativeToolchain(coverInfo.Target, coverInfo.GoPath); err != nil {
+			return nil, err
+		}
+		coverPkgs := nativeCoverPackages(covers)
+		if len(coverPkgs) == 0 {
+			return nil, ErrCoverPkgFailed
+		}
+		manifest.Backend = BackendNative
+		plan.BuildFlags = nativeBuildFlags(coverPkgs)
+	} else {
+		manifest.setVars(covers)
+	}
+	if coverInfo.Unlinked {
+		if err := addUnlinked(manifest, coverInfo, pkgs, covers); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Hash, err = manifest.ComputeHash(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
@@ -18,19 +18,21 @@ package cover
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/tools/cover"
 	"k8s.io/test-infra/gopherage/pkg/cov"
 )
@@ -38,10 +40,56 @@ import (
 // LogFile a file to save log.
 const LogFile = "goc.log"
 
+var errNoProfiles = errors.New("no profiles")
+
+// agentClient is the client the center calls the services with, a service which hangs
+// fails its call instead of hanging the request of the center
+var agentClient = &http.Client{Timeout: 30 * time.Second}
+
+// agent returns the client of the service at address
+func agent(address string) *client {
+	return &client{Host: address, client: agentClient}
+}
+
+// statusError is an error of a request, answered with status
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func (e *statusError) Unwrap() error { return e.err }
+
+func withStatus(status int, err error) error {
+	return &statusError{status: status, err: err}
+}
+
+// statusOf returns the status of a request failing with err, 500 unless told otherwise
+func statusOf(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return http.StatusInternalServerError
+}
+
+// legacyStatus returns the status /v1 answers err with, it fails with 417 all but the internal errors
+func legacyStatus(err error) int {
+	if status := statusOf(err); status >= http.StatusInternalServerError && status != http.StatusBadGateway {
+		return status
+	}
+	return http.StatusExpectationFailed
+}
+
 type server struct {
 	PersistenceFile string
-	IPRevise        bool // whether to do ip revise during registering
+	IPRevise        bool   // whether to do ip revise during registering
+	SourceDir       string // source tree the hot report looks the files up in, no report if empty
 	Store           Store
+
+	blockTables blockTableCache // block tables of compact profiles, keyed by build id
+	uploads     uploadStore     // profiles uploaded to the center
 }
 
 // NewFileBasedServer new a file based server with persistenceFile
@@ -50,10 +98,15 @@ func NewFileBasedServer(persistenceFile string) (*server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &server{
+	s := &server{
 		PersistenceFile: persistenceFile,
 		Store:           store,
-	}, nil
+		uploads:         uploadStore{dir: persistenceFile + ".uploads"},
+	}
+	if err := s.uploads.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // NewMemoryBasedServer new a memory based server without persistenceFile
@@ -63,17 +116,18 @@ func NewMemoryBasedServer() *server {
 	}
 }
 
-// Run starts coverage host center
-func (s *server) Run(port string) {
+// Run starts coverage host center, it returns when the center stops
+func (s *server) Run(port string) error {
 	f, err := os.Create(LogFile)
 	if err != nil {
-		log.Fatalf("failed to create log file %s, err: %v", LogFile, err)
+		return fmt.Errorf("failed to create log file %s, err: %w", LogFile, err)
 	}
+	defer f.Close()
 
 	// both log to stdout and file by default
 	mw := io.MultiWriter(f, os.Stdout)
 	r := s.Route(mw)
-	log.Fatal(r.Run(port))
+	return r.Run(port)
 }
 
 // Router init goc server engine
@@ -94,6 +148,23 @@ func (s *server) Route(w io.Writer) *gin.Engine {
 		v1.POST("/cover/init", s.initSystem)
 		v1.GET("/cover/list", s.listServices)
 		v1.POST("/cover/remove", s.removeServices)
+		v1.GET("/cover/hot", s.hot)
+		v1.POST("/cover/hot", s.hot)
+		v1.POST("/cover/upload", s.upload)
+	}
+
+	// v2 answers in json envelopes with proper status codes, see the openapi document
+	v2 := r.Group("/v2")
+	{
+		v2.GET("/openapi.json", s.openAPI)
+		v2.GET("/cover/list", s.listServicesV2)
+		v2.POST("/cover/register", s.registerServiceV2)
+		v2.POST("/cover/profile", s.profileV2)
+		v2.POST("/cover/clear", s.clearV2)
+		v2.POST("/cover/init", s.initSystemV2)
+		v2.POST("/cover/remove", s.removeServicesV2)
+		v2.POST("/cover/hot", s.hotV2)
+		v2.POST("/cover/upload", s.uploadV2)
 	}
 
 	return r
@@ -113,11 +184,22 @@ type ProfileParam struct {
 	Address           []string `form:"address" json:"address"`
 	CoverFilePatterns []string `form:"coverfile" json:"coverfile"`
 	SkipFilePatterns  []string `form:"skipfile" json:"skipfile"`
+	Groups            bool     `form:"groups" json:"groups"`     // return every coherent group as json
+	Branch            bool     `form:"branch" json:"branch"`     // append the branch coverage section
+	Unlinked          bool     `form:"unlinked" json:"unlinked"` // add zero counts for the packages no binary links
+	Uploads           bool     `form:"uploads" json:"uploads"`   // select the uploaded profiles too, /v2 always does
 }
 
-// listServices list all the registered services
+// HotParam is param of hot API
+type HotParam struct {
+	Service    []string `form:"service" json:"service"`
+	Top        int      `form:"top" json:"top"`
+	PerPackage bool     `form:"perpackage" json:"perpackage"`
+}
+
+// listServices list all the registered services, the uploaded profiles are listed by /v2 only
 func (s *server) listServices(c *gin.Context) {
-	services := s.Store.GetAll()
+	services := s.allServices(false)
 	c.JSON(http.StatusOK, services)
 }
 
@@ -127,29 +209,34 @@ func (s *server) registerService(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if _, _, err := s.register(service, c.ClientIP()); err != nil {
+		c.JSON(statusOf(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": "success"})
+}
 
+// register records the service connecting from clientIP, and returns it as recorded
+// and whether its address is a new one
+func (s *server) register(service ServiceUnderTest, clientIP string) (ServiceUnderTest, bool, error) {
 	u, err := url.Parse(service.Address)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("url.Parse %s failed: %s", service.Address, err.Error())})
-		return
+		return service, false, withStatus(http.StatusBadRequest, fmt.Errorf("url.Parse %s failed: %s", service.Address, err.Error()))
 	}
 	if u.Scheme != "https" && u.Scheme != "http" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupport schema"})
-		return
+		return service, false, withStatus(http.StatusBadRequest, errors.New("unsupport schema"))
 	}
 	if u.Host == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "empty host"})
-		return
+		return service, false, withStatus(http.StatusBadRequest, errors.New("empty host"))
 	}
-	host, port, err := net.SplitHostPort(u.Host)
-	if err != nil {
-		if strings.Contains(err.Error(), "missing port in address") {
-			// valid scenario, keep going
-			host = u.Host
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("net.SplitHostPort %s failed: %s", u.Host, err.Error())})
-			return
-		}
+	host, port := u.Hostname(), u.Port()
+	if strings.HasPrefix(u.Host, "[") && net.ParseIP(host).To16() == nil {
+		return service, false, withStatus(http.StatusBadRequest, fmt.Errorf("invalid IPv6 address %s", u.Host))
+	}
+	if strings.Contains(host, "%") {
+		// zoned link-local addresses are only reachable from the same link
+		return service, false, withStatus(http.StatusBadRequest, fmt.Errorf("zoned address %s is not supported", host))
 	}
 
 	var doIPRevise bool
@@ -157,37 +244,64 @@ func (s *server) registerService(c *gin.Context) {
 	if service.IPRevise != "" {
 		doIPRevise, err = strconv.ParseBool(service.IPRevise)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("strconv.ParseBool %s failed: %s", service.IPRevise, err.Error())})
-			return
+			return service, false, withStatus(http.StatusBadRequest, fmt.Errorf("strconv.ParseBool %s failed: %s", service.IPRevise, err.Error()))
 		}
 	} else {
 		doIPRevise = s.IPRevise
 	}
 
 	if doIPRevise {
-		realIP := c.ClientIP()
-		// only for IPV4
-		// refer: https://github.com/qiniu/goc/issues/177
-		if net.ParseIP(realIP).To4() != nil && host != realIP {
-			log.Printf("the registered host %s of service %s is different with the real one %s, here we choose the real one", service.Name, host, realIP)
+		if realIP := reviseHost(host, clientIP); realIP != host {
+			log.Printf("the registered host %s of service %s is different with the real one %s, here we choose the real one", host, service.Name, realIP)
 			host = realIP
 		}
 	}
 
 	service.Address = fmt.Sprintf("%s://%s", u.Scheme, host)
 	if port != "" {
-		service.Address = fmt.Sprintf("%s:%s", service.Address, port)
+		service.Address = fmt.Sprintf("%s://%s", u.Scheme, net.JoinHostPort(host, port))
+	} else if strings.Contains(host, ":") {
+		service.Address = fmt.Sprintf("%s://[%s]", u.Scheme, host)
 	}
 
-	address := s.Store.Get(service.Name)
-	if !contains(address, service.Address) {
-		if err := s.Store.Add(service); err != nil && err != ErrServiceAlreadyRegistered {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	if contains(s.Store.Get(service.Name), service.Address) {
+		return service, false, nil
+	}
+	if err := s.Store.Add(service); err != nil {
+		if err == ErrServiceAlreadyRegistered {
+			return service, false, nil
 		}
+		return service, false, err
 	}
+	return service, true, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"result": "success"})
+// reviseHost returns the host the center should record for a service which
+// registered host and was seen connecting from clientIP.
+// The client IP only replaces a host of the same family, an IPv4-mapped
+// IPv6 client is treated as IPv4. An address of the other family usually
+// comes from a dual-stack proxy and is not reachable the way the service
+// asked to be reached, refer: https://github.com/qiniu/goc/issues/177
+func reviseHost(host, clientIP string) string {
+	clientAddr := net.ParseIP(clientIP)
+	if clientAddr == nil {
+		return host
+	}
+	if client4 := clientAddr.To4(); client4 != nil {
+		clientAddr = client4
+	}
+	registered := net.ParseIP(host)
+	if registered != nil && (registered.To4() != nil) != (len(clientAddr) == net.IPv4len) {
+		return host
+	}
+	if registered == nil && len(clientAddr) != net.IPv4len {
+		// keep the old behaviour for hostnames, only IPv4 clients revise them
+		return host
+	}
+	if registered != nil && registered.Equal(clientAddr) {
+		return host
+	}
+	return clientAddr.String()
 }
 
 // profile API examples:
@@ -200,65 +314,290 @@ func (s *server) profile(c *gin.Context) {
 		return
 	}
 
-	allInfos := s.Store.GetAll()
-	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, body.Force, allInfos)
+	groups, err := s.profileGroups(body)
 	if err != nil {
-		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+		c.JSON(legacyStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if body.Groups {
+		if err := dumpGroups(groups); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, groups)
 		return
 	}
 
-	var mergedProfiles = make([][]*cover.Profile, 0)
-	for _, addrInfo := range filterAddrInfoList {
-		pp, err := NewWorker(addrInfo.Address).Profile(ProfileParam{})
+	// the largest coherent group wins, the others are reported instead of failing the request
+	if left := incoherentAddresses(groups); len(left) > 0 {
+		log.Warnf("profiles of %v were built from different sources than the others, left out of the merged profile", left)
+		c.Header(ProfileIncoherentHeader, strings.Join(left, ","))
+	}
+
+	if err := writeProfile(c.Writer, c.Request, groups[0].Profiles, groups[0].Branches); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+}
+
+// profileGroups pulls the profiles of the services body selects, and merges the ones built
+// from the same sources together, the largest group first
+func (s *server) profileGroups(body ProfileParam) ([]*ProfileGroup, error) {
+	allInfos := s.allServices(body.Uploads)
+	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, body.Force, allInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := s.collectSources(filterAddrInfoList, body.Force, body.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := MergeCoherentProfiles(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	if body.Unlinked {
+		zero := s.agentsUnlinked(filterAddrInfoList)
+		for _, g := range groups {
+			g.Profiles = AddZeroProfiles(g.Profiles, zero)
+		}
+	}
+
+	for _, g := range groups {
+		if err := filterAndSkipGroup(body, g); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// dumpGroups sets the text profile of every group
+func dumpGroups(groups []*ProfileGroup) error {
+	for _, g := range groups {
+		var buf bytes.Buffer
+		if err := cov.DumpProfile(g.Profiles, &buf); err != nil {
+			return err
+		}
+		if g.Branches != nil {
+			WriteBranchProfiles(&buf, g.Branches)
+		}
+		g.Profile = buf.String()
+	}
+	return nil
+}
+
+// incoherentAddresses returns the addresses of the groups but the largest one
+func incoherentAddresses(groups []*ProfileGroup) []string {
+	var left []string
+	for _, g := range groups[1:] {
+		left = append(left, g.Address...)
+	}
+	return left
+}
+
+// collectSources pulls the profiles of the services, unreachable ones are skipped if force
+func (s *server) collectSources(infos []ServiceUnderTest, force, branch bool) ([]ProfileSource, error) {
+	var sources = make([]ProfileSource, 0)
+	for _, addrInfo := range infos {
+		if name, ok := isUploadAddress(addrInfo.Address); ok {
+			if profiles, ok := s.uploads.get(name); ok {
+				sources = append(sources, ProfileSource{Name: name, Address: addrInfo.Address, Profiles: profiles})
+			}
+			continue
+		}
+		res, pp, err := agent(addrInfo.Address).agentProfile(s.blockTables.knownBuildID(addrInfo.Address))
 		if err != nil {
-			if body.Force {
+			if force {
 				log.Warnf("get profile from [%s] failed, error: %s", addrInfo, err.Error())
 				continue
 			}
 
-			c.JSON(http.StatusExpectationFailed, gin.H{"error": fmt.Sprintf("failed to get profile from %s, service %s, error %s", addrInfo.Address, addrInfo.Name, err.Error())})
-			return
+			return nil, withStatus(http.StatusBadGateway, fmt.Errorf("failed to get profile from %s, service %s, error %s", addrInfo.Address, addrInfo.Name, err.Error()))
 		}
 
-		profile, err := convertProfile(pp)
+		profile, buildID, err := s.decodeAgentProfile(addrInfo.Address, res.Header.Get("Content-Type"), pp)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, err
 		}
-		mergedProfiles = append(mergedProfiles, profile)
+		if buildID == "" {
+			buildID = res.Header.Get(ProfileBuildIDHeader)
+		}
+		src := ProfileSource{Name: addrInfo.Name, Address: addrInfo.Address, BuildID: buildID, Profiles: profile}
+		if branch {
+			if src.Branches, err = s.agentBranches(addrInfo); err != nil {
+				return nil, withStatus(http.StatusBadGateway, err)
+			}
+		}
+		sources = append(sources, src)
 	}
 
-	if len(mergedProfiles) == 0 {
-		c.JSON(http.StatusExpectationFailed, gin.H{"error": "no profiles"})
+	if len(sources) == 0 {
+		return nil, withStatus(http.StatusNotFound, errNoProfiles)
+	}
+	return sources, nil
+}
+
+// hot API examples:
+// GET /v1/cover/hot?service=a&top=10&source=/path/to/project
+// lists the most called and the never called functions of every service, from count mode profiles
+func (s *server) hot(c *gin.Context) {
+	var body HotParam
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
 
-	merged, err := cov.MergeMultipleProfiles(mergedProfiles)
+	report, err := s.hotReport(body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(legacyStatus(err), gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, report)
+}
 
+// hotReport ranks the functions of the services, the source files looked up in the source tree
+// the center was started with only, never wherever a request tells
+func (s *server) hotReport(body HotParam) ([]*HotGroup, error) {
+	if s.SourceDir == "" {
+		return nil, withStatus(http.StatusNotFound, errors.New("the center has no source tree, start it with --source-dir or run goc hot from the project"))
+	}
+	allInfos := s.allServices(true)
+	services := body.Service
+	if len(services) == 0 {
+		for name := range allInfos {
+			services = append(services, name)
+		}
+	}
+	infos, err := filterAddrInfo(services, nil, true, allInfos)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := s.collectSources(infos, true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, src := range sources {
+		for _, p := range src.Profiles {
+			if !insideSourceDir(p.FileName) {
+				return nil, withStatus(http.StatusBadRequest, fmt.Errorf("%s of %s is not in the source tree", p.FileName, src.Name))
+			}
+		}
+	}
+	report, err := HotReport(sources, s.SourceDir, body.Top, body.PerPackage)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	return report, nil
+}
+
+// insideSourceDir reports whether the file of a profile, an import path or a path relative
+// to the source tree, stays in it
+func insideSourceDir(file string) bool {
+	if filepath.IsAbs(file) || path.IsAbs(file) {
+		return false
+	}
+	for _, elem := range strings.FieldsFunc(file, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// filterAndSkipGroup applies the coverfile and skipfile patterns of the request to the group
+func filterAndSkipGroup(body ProfileParam, g *ProfileGroup) error {
+	var err error
+	if body.Branch && g.Branches == nil {
+		// asked for, so the section is written even if empty
+		g.Branches = make([]*BranchProfile, 0)
+	}
 	if len(body.CoverFilePatterns) > 0 {
-		merged, err = filterProfile(body.CoverFilePatterns, merged)
+		g.Profiles, err = filterProfile(body.CoverFilePatterns, g.Profiles)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to filter profile based on the patterns: %v, error: %v", body.CoverFilePatterns, err)})
-			return
+			return fmt.Errorf("failed to filter profile based on the patterns: %v, error: %v", body.CoverFilePatterns, err)
+		}
+		if g.Branches != nil {
+			if g.Branches, err = filterBranchProfiles(body.CoverFilePatterns, false, g.Branches); err != nil {
+				return err
+			}
 		}
 	}
 
 	if len(body.SkipFilePatterns) > 0 {
-		merged, err = skipProfile(body.SkipFilePatterns, merged)
+		g.Profiles, err = skipProfile(body.SkipFilePatterns, g.Profiles)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to skip profile based on the patterns: %v, error: %v", body.SkipFilePatterns, err)})
-			return
+			return fmt.Errorf("failed to skip profile based on the patterns: %v, error: %v", body.SkipFilePatterns, err)
+		}
+		if g.Branches != nil {
+			if g.Branches, err = filterBranchProfiles(body.SkipFilePatterns, true, g.Branches); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
 
-	if err := cov.DumpProfile(merged, c.Writer); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// agentBranches fetches the branch coverage of a service, services built
+// without --mode=branch or by older goc have none
+func (s *server) agentBranches(addrInfo ServiceUnderTest) ([]*BranchProfile, error) {
+	res, body, err := agent(addrInfo.Address).agentBranches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch coverage from %s, service %s, error %s", addrInfo.Address, addrInfo.Name, err.Error())
+	}
+	if res.StatusCode != http.StatusOK || !isBranchSection(body) {
+		return nil, nil
 	}
+	return ParseBranchProfiles(bytes.NewReader(body))
+}
+
+// agentsUnlinked gathers the zero count profiles of the packages no binary links from the services.
+// Services built without --unlinked, or by an older goc, have none.
+func (s *server) agentsUnlinked(infos []ServiceUnderTest) []*cover.Profile {
+	var zero []*cover.Profile
+	for _, addrInfo := range infos {
+		if _, ok := isUploadAddress(addrInfo.Address); ok {
+			continue
+		}
+		res, body, err := agent(addrInfo.Address).agentUnlinked()
+		if err != nil {
+			log.Warnf("get unlinked packages from [%s] failed, error: %s", addrInfo.Address, err.Error())
+			continue
+		}
+		if res.StatusCode != http.StatusOK || len(bytes.TrimSpace(body)) == 0 {
+			continue
+		}
+		profiles, err := convertProfile(body)
+		if err != nil {
+			log.Warnf("invalid unlinked packages profile from [%s], error: %s", addrInfo.Address, err.Error())
+			continue
+		}
+		zero = AddZeroProfiles(zero, profiles)
+	}
+	return zero
+}
+
+// decodeAgentProfile parses the profile got from the service at the address,
+// caching the block table of compact profiles for the next pull.
+// The build ID is only known for compact profiles.
+func (s *server) decodeAgentProfile(address, contentType string, p []byte) ([]*cover.Profile, string, error) {
+	if !isCompactProfile(contentType) {
+		profiles, err := convertProfile(p)
+		return profiles, "", err
+	}
+
+	profiles, buildID, table, err := decodeCompactProfile(bytes.NewReader(p), &s.blockTables)
+	if err != nil {
+		return nil, "", err
+	}
+	if buildID != "" {
+		s.blockTables.put(address, buildID, table)
+	}
+	return profiles, buildID, nil
 }
 
 // filterProfile filters profiles of the packages matching the coverFile pattern
@@ -311,25 +650,46 @@ func (s *server) clear(c *gin.Context) {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	svrsUnderTest := s.Store.GetAll()
+	svrsUnderTest := s.allServices(body.Uploads)
 	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, true, svrsUnderTest)
 	if err != nil {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	for _, addrInfo := range filterAddrInfoList {
-		pp, err := NewWorker(addrInfo.Address).Clear(ProfileParam{})
-		if err != nil {
-			c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+	for _, r := range s.clearServices(filterAddrInfoList) {
+		if r.Error != "" {
+			c.JSON(http.StatusExpectationFailed, gin.H{"error": r.Error})
 			return
 		}
-		fmt.Fprintf(c.Writer, "Register service %s coverage counter %s", addrInfo.Address, string(pp))
+		if _, ok := isUploadAddress(r.Address); ok {
+			fmt.Fprintf(c.Writer, "Uploaded profiles %s cleared.", r.Name)
+			continue
+		}
+		fmt.Fprintf(c.Writer, "Register service %s coverage counter %s", r.Address, r.message)
 	}
 
 }
 
+// clearServices clears the counters of the services, and drops the uploaded profiles among them
+func (s *server) clearServices(infos []ServiceUnderTest) []ServiceResult {
+	results := make([]ServiceResult, 0, len(infos))
+	for _, addrInfo := range infos {
+		r := ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: "cleared"}
+		if name, ok := isUploadAddress(addrInfo.Address); ok {
+			s.uploads.remove(name)
+			r.Name = name
+		} else if pp, err := agent(addrInfo.Address).Clear(ProfileParam{}); err != nil {
+			r.Result, r.Error = "failed", err.Error()
+		} else {
+			r.message = string(pp)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
 func (s *server) initSystem(c *gin.Context) {
-	if err := s.Store.Init(); err != nil {
+	if err := s.reset(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -337,43 +697,63 @@ func (s *server) initSystem(c *gin.Context) {
 	c.JSON(http.StatusOK, "")
 }
 
+// reset removes all the services and the uploaded profiles
+func (s *server) reset() error {
+	if err := s.Store.Init(); err != nil {
+		return err
+	}
+	s.blockTables.clear()
+	for _, name := range s.uploads.names() {
+		s.uploads.remove(name)
+	}
+	return nil
+}
+
 func (s *server) removeServices(c *gin.Context) {
 	var body ProfileParam
 	if err := c.ShouldBind(&body); err != nil {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	svrsUnderTest := s.Store.GetAll()
+	svrsUnderTest := s.allServices(body.Uploads)
 	filterAddrInfoList, err := filterAddrInfo(body.Service, body.Address, true, svrsUnderTest)
 	if err != nil {
 		c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
 		return
 	}
-	for _, addrInfo := range filterAddrInfoList {
-		err := s.Store.Remove(addrInfo.Address)
-		if err != nil {
-			c.JSON(http.StatusExpectationFailed, gin.H{"error": err.Error()})
+	for _, r := range s.removeAddresses(filterAddrInfoList) {
+		if r.Error != "" {
+			c.JSON(http.StatusExpectationFailed, gin.H{"error": r.Error})
 			return
 		}
-		fmt.Fprintf(c.Writer, "Register service %s removed from the center.", addrInfo.Address)
+		if _, ok := isUploadAddress(r.Address); ok {
+			fmt.Fprintf(c.Writer, "Uploaded profiles %s removed from the center.", r.Name)
+			continue
+		}
+		fmt.Fprintf(c.Writer, "Register service %s removed from the center.", r.Address)
 	}
 }
 
-func convertProfile(p []byte) ([]*cover.Profile, error) {
-	// Annoyingly, ParseProfiles only accepts a filename, so we have to write the bytes to disk
-	// so it can read them back.
-	// We could probably also just give it /dev/stdin, but that'll break on Windows.
-	tf, err := ioutil.TempFile("", "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file, err: %v", err)
-	}
-	defer tf.Close()
-	defer os.Remove(tf.Name())
-	if _, err := io.Copy(tf, bytes.NewReader(p)); err != nil {
-		return nil, fmt.Errorf("failed to copy data to temp file, err: %v", err)
+// removeAddresses removes the services from the center, and drops the uploaded profiles among them
+func (s *server) removeAddresses(infos []ServiceUnderTest) []ServiceResult {
+	results := make([]ServiceResult, 0, len(infos))
+	for _, addrInfo := range infos {
+		r := ServiceResult{Name: addrInfo.Name, Address: addrInfo.Address, Result: "removed"}
+		if name, ok := isUploadAddress(addrInfo.Address); ok {
+			s.uploads.remove(name)
+			r.Name = name
+		} else if err := s.Store.Remove(addrInfo.Address); err != nil {
+			r.Result, r.Error = "failed", err.Error()
+		} else {
+			s.blockTables.forget(addrInfo.Address)
+		}
+		results = append(results, r)
 	}
+	return results
+}
 
-	return cover.ParseProfiles(tf.Name())
+func convertProfile(p []byte) ([]*cover.Profile, error) {
+	return cover.ParseProfilesFromReader(bytes.NewReader(p))
 }
 
 func contains(arr []string, str string) bool {
@@ -393,7 +773,7 @@ func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[
 	}
 
 	if len(serviceList) != 0 && len(addressList) != 0 {
-		return nil, fmt.Errorf("use 'service' flag and 'address' flag at the same time may cause ambiguity, please use them separately")
+		return nil, withStatus(http.StatusBadRequest, fmt.Errorf("use 'service' flag and 'address' flag at the same time may cause ambiguity, please use them separately"))
 	}
 
 	// Add matched services to map
@@ -405,7 +785,7 @@ func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[
 			continue // jump to match the next service
 		}
 		if !force {
-			return nil, fmt.Errorf("service [%s] not found", name)
+			return nil, withStatus(http.StatusNotFound, fmt.Errorf("service [%s] not found", name))
 		}
 		log.Warnf("service [%s] not found", name)
 	}
@@ -417,7 +797,7 @@ func filterAddrInfo(serviceList, addressList []string, force bool, allInfos map[
 			continue
 		}
 		if !force {
-			return nil, fmt.Errorf("address [%s] not found", addr)
+			return nil, withStatus(http.StatusNotFound, fmt.Errorf("address [%s] not found", addr))
 		}
 		log.Warnf("address [%s] not found", addr)
 	}
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -255,6 +256,71 @@ func TestRegisterService(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "lala error")
 }
 
+func TestReviseHost(t *testing.T) {
+	items := []struct {
+		host     string
+		clientIP string
+		expected string
+	}{
+		{host: "10.0.0.1", clientIP: "10.0.0.2", expected: "10.0.0.2"},
+		{host: "10.0.0.1", clientIP: "10.0.0.1", expected: "10.0.0.1"},
+		{host: "", clientIP: "10.0.0.2", expected: "10.0.0.2"},
+		{host: "10.0.0.1", clientIP: "::ffff:10.0.0.2", expected: "10.0.0.2"},
+		{host: "10.0.0.1", clientIP: "fd00::2", expected: "10.0.0.1"},
+		{host: "fd00::1", clientIP: "fd00::2", expected: "fd00::2"},
+		{host: "fd00::1", clientIP: "FD00:0::1", expected: "fd00::1"},
+		{host: "fd00::1", clientIP: "10.0.0.2", expected: "fd00::1"},
+		{host: "svc.local", clientIP: "fd00::2", expected: "svc.local"},
+		{host: "svc.local", clientIP: "10.0.0.2", expected: "10.0.0.2"},
+		{host: "10.0.0.1", clientIP: "", expected: "10.0.0.1"},
+	}
+	for _, item := range items {
+		assert.Equal(t, item.expected, reviseHost(item.host, item.clientIP), item)
+	}
+}
+
+func TestRegisterIPv6Service(t *testing.T) {
+	server, err := NewFileBasedServer("_svrs_address.txt")
+	assert.NoError(t, err)
+	server.IPRevise = true
+	router := server.Route(os.Stdout)
+
+	items := []struct {
+		address    string
+		remoteAddr string
+		ipRevise   string
+		code       int
+		stored     string
+	}{
+		{address: "http://[fd00::1]:8080", remoteAddr: "[fd00::1]:4000", code: http.StatusOK, stored: "http://[fd00::1]:8080"},
+		{address: "http://[fd00::1]:8080", remoteAddr: "[fd00::2]:4000", code: http.StatusOK, stored: "http://[fd00::2]:8080"},
+		{address: "http://[fd00::1]:8080", remoteAddr: "[fd00::2]:4000", ipRevise: "false", code: http.StatusOK, stored: "http://[fd00::1]:8080"},
+		{address: "http://[fd00::1]:8080", remoteAddr: "10.0.0.2:4000", code: http.StatusOK, stored: "http://[fd00::1]:8080"},
+		{address: "http://[fd00::1]", remoteAddr: "[fd00::2]:4000", code: http.StatusOK, stored: "http://[fd00::2]"},
+		{address: "http://:8080", remoteAddr: "[fd00::2]:4000", ipRevise: "false", code: http.StatusOK, stored: "http://:8080"},
+		{address: "http://[fe80::1%25eth0]:8080", remoteAddr: "[fd00::2]:4000", code: http.StatusBadRequest},
+		{address: "http://[zz::1]:8080", remoteAddr: "[fd00::2]:4000", code: http.StatusBadRequest},
+	}
+	for _, item := range items {
+		assert.NoError(t, server.Store.Init())
+		data := url.Values{}
+		data.Set("name", "ipv6")
+		data.Set("address", item.address)
+		if item.ipRevise != "" {
+			data.Set("ip_revise", item.ipRevise)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/cover/register", strings.NewReader(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = item.remoteAddr
+		router.ServeHTTP(w, req)
+		assert.Equal(t, item.code, w.Code, item.address)
+		if item.code == http.StatusOK {
+			assert.Equal(t, []string{item.stored}, server.Store.Get("ipv6"), item.address)
+		}
+	}
+}
+
 func TestProfileService(t *testing.T) {
 	server, err := NewFileBasedServer("_svrs_address.txt")
 	assert.NoError(t, err)
@@ -269,6 +335,35 @@ func TestProfileService(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "invalid syntax")
 }
 
+func TestProfileHungService(t *testing.T) {
+	done := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer hung.Close()
+	defer close(done)
+	defer func(c *http.Client) { agentClient = c }(agentClient)
+	agentClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	testObj := new(MockStore)
+	testObj.On("GetAll").Return(map[string][]string{"hung": {hung.URL}})
+	router := (&server{Store: testObj}).Route(os.Stdout)
+
+	// the service is given up on, the request does not hang with it
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/cover/clear", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Contains(t, w.Body.String(), "Client.Timeout")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/cover/profile?force=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Contains(t, w.Body.String(), "no profiles")
+}
+
 func TestClearService(t *testing.T) {
 	testObj := new(MockStore)
 	testObj.On("GetAll").Return(map[string][]string{"foo": {"http://127.0.0.1:66666"}})
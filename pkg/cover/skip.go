@@ -0,0 +1,168 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reasons a file is not instrumented, recorded in the build manifest
+const (
+	SkipGenerated = "generated" // has the // Code generated ... DO NOT EDIT. header
+	SkipVendored  = "vendored"  // belongs to a package under a vendor directory
+	SkipPattern   = "pattern"   // its package matches one of the skipped package patterns
+)
+
+// generatedRe matches the comment of generated files, see https://golang.org/s/generatedcode
+var generatedRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// SkippedFile is a file left without counters
+type SkippedFile struct {
+	File   string `json:"file"` // import path of the package joined with the file name
+	Reason string `json:"reason"`
+}
+
+// fileFilter decides which files of a package are instrumented and remembers the others
+type fileFilter struct {
+	includeGenerated bool
+	skipPackages     []*regexp.Regexp
+	vendorPackages   []*regexp.Regexp // the vendored packages instrumented all the same
+	skipped          []SkippedFile
+}
+
+func newFileFilter(includeGenerated bool, skipPackages []string, vendorPackages []string) (*fileFilter, error) {
+	f := &fileFilter{includeGenerated: includeGenerated}
+	var err error
+	if f.skipPackages, err = compilePatterns(skipPackages); err != nil {
+		return nil, err
+	}
+	if f.vendorPackages, err = compilePatterns(vendorPackages); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package pattern %s: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// instrumentVendored reports whether the vendored package is instrumented, the patterns
+// match its import path without the vendor directory, as the project imports it
+func (f *fileFilter) instrumentVendored(importPath string) bool {
+	if i := strings.LastIndex(importPath, "/vendor/"); i >= 0 {
+		importPath = importPath[i+len("/vendor/"):]
+	}
+	importPath = strings.TrimPrefix(importPath, "vendor/")
+	for _, re := range f.vendorPackages {
+		if re.MatchString(importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns a copy of the package without the files which must not be instrumented
+func (f *fileFilter) filter(pkg *Package) *Package {
+	reason := ""
+	if isVendoredPackage(pkg) && !f.instrumentVendored(pkg.ImportPath) {
+		reason = SkipVendored
+	} else {
+		for _, re := range f.skipPackages {
+			if re.MatchString(pkg.ImportPath) {
+				reason = SkipPattern
+				break
+			}
+		}
+	}
+
+	p := *pkg
+	p.GoFiles = f.keep(pkg, pkg.GoFiles, reason)
+	p.CgoFiles = f.keep(pkg, pkg.CgoFiles, reason)
+	return &p
+}
+
+func (f *fileFilter) keep(pkg *Package, files []string, reason string) []string {
+	var kept []string
+	for _, file := range files {
+		why := reason
+		if why == "" && !f.includeGenerated && isGeneratedFile(filepath.Join(pkg.Dir, file)) {
+			why = SkipGenerated
+		}
+		if why == "" {
+			kept = append(kept, file)
+			continue
+		}
+		f.skipped = append(f.skipped, SkippedFile{File: path.Join(pkg.ImportPath, file), Reason: why})
+	}
+	return kept
+}
+
+// Skipped returns the files left without counters, sorted by name
+func (f *fileFilter) Skipped() []SkippedFile {
+	if f.skipped == nil {
+		return []SkippedFile{}
+	}
+	sort.Slice(f.skipped, func(i, j int) bool { return f.skipped[i].File < f.skipped[j].File })
+	return f.skipped
+}
+
+// isVendored reports whether the import path is the one of a vendored package
+func isVendored(importPath string) bool {
+	return strings.HasPrefix(importPath, "vendor/") || strings.Contains(importPath, "/vendor/")
+}
+
+// isVendoredPackage reports whether the package is a vendored one, of a GOPATH project or
+// of a module built in vendor mode, whose dependencies have no module directory
+func isVendoredPackage(pkg *Package) bool {
+	return isVendored(pkg.ImportPath) || pkg.Module != nil && !pkg.Module.Main && pkg.Module.Dir == ""
+}
+
+// isGeneratedFile reports whether the file has the generated code comment before its package clause
+func isGeneratedFile(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if generatedRe.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
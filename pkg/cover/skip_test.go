@@ -0,0 +1,205 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSkipTestPackage(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "goc-skip")
+	assert.NoError(t, err)
+	files := map[string]string{
+		"a.go":       "package a\n",
+		"a.pb.go":    "// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: a.proto\n\npackage a\n",
+		"mock.go":    "// Copyright 2020\n\n// Code generated by MockGen. DO NOT EDIT.\npackage a\n",
+		"comment.go": "package a\n\n// Code generated by hand. DO NOT EDIT.\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestIsGeneratedFile(t *testing.T) {
+	dir := writeSkipTestPackage(t)
+	defer os.RemoveAll(dir)
+
+	assert.False(t, isGeneratedFile(filepath.Join(dir, "a.go")))
+	assert.True(t, isGeneratedFile(filepath.Join(dir, "a.pb.go")))
+	assert.True(t, isGeneratedFile(filepath.Join(dir, "mock.go")))
+	// the comment only counts before the package clause
+	assert.False(t, isGeneratedFile(filepath.Join(dir, "comment.go")))
+	assert.False(t, isGeneratedFile(filepath.Join(dir, "missing.go")))
+}
+
+func TestIsVendored(t *testing.T) {
+	assert.True(t, isVendored("example.com/m/vendor/github.com/x/y"))
+	assert.True(t, isVendored("vendor/golang.org/x/net"))
+	assert.False(t, isVendored("example.com/vendors/x"))
+}
+
+func TestFileFilter(t *testing.T) {
+	dir := writeSkipTestPackage(t)
+	defer os.RemoveAll(dir)
+	pkg := &Package{Dir: dir, ImportPath: "example.com/a", GoFiles: []string{"a.go", "a.pb.go", "comment.go", "mock.go"}}
+
+	f, err := newFileFilter(false, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.go", "comment.go"}, f.filter(pkg).GoFiles)
+	assert.Len(t, pkg.GoFiles, 4, "the listed package is left untouched")
+	assert.Equal(t, []SkippedFile{
+		{File: "example.com/a/a.pb.go", Reason: SkipGenerated},
+		{File: "example.com/a/mock.go", Reason: SkipGenerated},
+	}, f.Skipped())
+
+	f, err = newFileFilter(true, []string{"^example.com/b$"}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, f.filter(pkg).GoFiles, 4)
+	assert.Empty(t, f.Skipped())
+
+	f, err = newFileFilter(true, []string{"^example.com/a$"}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, f.filter(pkg).GoFiles)
+	assert.Len(t, f.Skipped(), 4)
+	assert.Equal(t, SkipPattern, f.Skipped()[0].Reason)
+
+	_, err = newFileFilter(false, []string{"("}, nil)
+	assert.Error(t, err)
+}
+
+func TestFileFilterVendored(t *testing.T) {
+	dir := writeSkipTestPackage(t)
+	defer os.RemoveAll(dir)
+	gopath := &Package{Dir: dir, ImportPath: "example.com/p/vendor/example.com/v", GoFiles: []string{"a.go"}}
+	// the vendored package of a module built in vendor mode has no module directory
+	module := &Package{Dir: dir, ImportPath: "example.com/v", GoFiles: []string{"a.go"}, Module: &ModulePublic{Path: "example.com/v"}}
+	local := &Package{Dir: dir, ImportPath: "example.com/v", GoFiles: []string{"a.go"}, Module: &ModulePublic{Path: "example.com/v", Dir: dir}}
+
+	f, err := newFileFilter(false, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, f.filter(gopath).GoFiles)
+	assert.Empty(t, f.filter(module).GoFiles)
+	assert.Equal(t, []string{"a.go"}, f.filter(local).GoFiles)
+	assert.Equal(t, SkipVendored, f.Skipped()[0].Reason)
+
+	f, err = newFileFilter(false, nil, []string{"^example.com/v$"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.go"}, f.filter(gopath).GoFiles)
+	assert.Equal(t, []string{"a.go"}, f.filter(module).GoFiles)
+	assert.Empty(t, f.Skipped())
+	assert.False(t, f.instrumentVendored("vendor/golang.org/x/net/http"))
+
+	_, err = newFileFilter(false, nil, []string{"("})
+	assert.Error(t, err)
+}
+
+func TestManifestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-manifest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app"+ManifestSuffix)
+	m := &Manifest{Mode: "count", Skipped: []SkippedFile{{File: "example.com/a/a.pb.go", Reason: SkipGenerated}}}
+	assert.NoError(t, m.Write(file))
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	var got Manifest
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, *m, got)
+}
+
+func TestNewManifest(t *testing.T) {
+	dir := writeSkipTestPackage(t)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\nfunc A(b bool) int {\n\tif b {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"), 0644))
+	pkg := &Package{Dir: dir, ImportPath: "example.com/a", GoFiles: []string{"a.go", "a.pb.go"}}
+
+	f, err := newFileFilter(false, nil, nil)
+	assert.NoError(t, err)
+	covers := map[string]*PackageCover{pkg.ImportPath: newPackageCover(f.filter(pkg))}
+	ci := &CoverInfo{Target: dir, Mode: "count", Center: "http://127.0.0.1:7777", ModRootPath: "example.com/a", GocVersion: "v1.0.0"}
+	m, err := newManifest(ci, covers, f)
+	assert.NoError(t, err)
+	assert.NoError(t, m.scan(covers, true))
+	assert.Equal(t, "v1.0.0", m.GocVersion)
+	assert.Equal(t, "example.com/a", m.Module)
+	assert.Equal(t, "http://127.0.0.1:7777", m.Center)
+	assert.Equal(t, []SkippedFile{{File: "example.com/a/a.pb.go", Reason: SkipGenerated}}, m.Skipped)
+	assert.Equal(t, []ManifestPackage{{ImportPath: "example.com/a", Files: []ManifestFile{{
+		File:   "example.com/a/a.go",
+		SHA256: "25991016da24f689af2e42a8925b4878d459bc4ef413f05b3926b6997442aca4",
+		Blocks: 3,
+	}}}}, m.Packages)
+
+	scanned := m.Packages
+
+	// the native backend's blocks are the toolchain's
+	ci.Backend = BackendNative
+	m, err = newManifest(ci, covers, f)
+	assert.NoError(t, err)
+	assert.NoError(t, m.scan(covers, false))
+	assert.Zero(t, m.Packages[0].Files[0].Blocks)
+
+	// the goc backend gets them from the annotation, whether the cache serves the files or not
+	cacheDir, err := ioutil.TempDir("", "goc-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+	cache := NewInstrumentCache(cacheDir)
+	ci.Backend = ""
+	for _, cached := range []int{0, 1} {
+		copied := writeAnnotateTestPackage(t, map[string]string{"a.go": "package a\n\nfunc A(b bool) int {\n\tif b {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"})
+		defer os.RemoveAll(copied.Dir)
+		covers := map[string]*PackageCover{copied.ImportPath: newPackageCover(copied)}
+		m, err := newManifest(ci, covers, f)
+		assert.NoError(t, err)
+		_, stats, err := annotatePackages(context.Background(), covers, "count", "example.com/a/globalcover", 0, cache)
+		assert.NoError(t, err)
+		assert.Equal(t, cached, stats.Cached)
+		m.setVars(covers)
+		got := m.Packages[0].Files[0]
+		assert.Equal(t, scanned[0].Files[0], ManifestFile{File: got.File, SHA256: got.SHA256, Blocks: got.Blocks})
+		assert.NotEmpty(t, got.Var)
+	}
+}
+
+func TestManifestHash(t *testing.T) {
+	m := &Manifest{Mode: "count", Module: "example.com/a"}
+	hash, err := m.ComputeHash()
+	assert.NoError(t, err)
+	assert.Len(t, hash, 32)
+
+	// neither the hash nor the export information count
+	m.Hash = hash
+	m.Export = &ExportInfo{WorkingDir: "."}
+	again, err := m.ComputeHash()
+	assert.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	m.Mode = "atomic"
+	other, err := m.ComputeHash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
@@ -24,8 +24,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-
-	log "github.com/sirupsen/logrus"
 )
 
 var ErrServiceAlreadyRegistered = errors.New("service already registered")
@@ -75,7 +73,7 @@ func NewFileStore(persistenceFile string) (store Store, err error) {
 	}
 
 	if err := l.load(); err != nil {
-		log.Fatalf("load failed, file: %s, err: %v", l.persistentFile, err)
+		return nil, fmt.Errorf("load failed, file: %s, err: %w", l.persistentFile, err)
 	}
 
 	return l, nil
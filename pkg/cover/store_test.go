@@ -19,6 +19,7 @@ package cover
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
@@ -159,3 +160,15 @@ func TestConcurrentRemoval(t *testing.T) {
 
 	assert.Equal(t, 0, len(store.GetAll()))
 }
+
+func TestFileStoreLoadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goc-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a directory cannot be loaded, the error is returned
+	_, err = NewFileStore(dir)
+	assert.Error(t, err)
+	_, err = NewFileBasedServer(dir)
+	assert.Error(t, err)
+}
@@ -0,0 +1,654 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+)
+
+// go build -toolexec runs every tool of the build through goc toolexec. The files of the
+// compiled packages are instrumented in a temporary copy, their counters are declared in the
+// package itself as the go command only gives a package the imports it has. The counters of a
+// package and of the packages it depends on are recorded under the action id of its build, so
+// that the compile of a main package, which may only find their archives in the build cache,
+// knows them all. The agent of the main package reads them through a package goc compiles,
+// which pulls every counter with go:linkname, and the link gets the packages the agent imports.
+
+// toolexecFormat changes whenever the instrumentation does, it invalidates the build cache
+const toolexecFormat = "1"
+
+// ToolexecConfig is the configuration of goc toolexec, the same for every tool a build runs
+type ToolexecConfig struct {
+	Mode             string   `json:"mode"`
+	AgentPort        string   `json:"agentPort"`
+	Center           string   `json:"center"`
+	Singleton        bool     `json:"singleton"`
+	IncludeGenerated bool     `json:"includeGenerated"`
+	SkipPackages     []string `json:"skipPackages"`
+	VendorPackages   []string `json:"vendorPackages"`
+	StateDir         string   `json:"-"` // where the counters of the compiled packages are recorded
+}
+
+// atomic reports whether the counters are updated with sync/atomic
+func (cfg *ToolexecConfig) atomic() bool {
+	return cfg.Mode == "atomic" || cfg.Mode == tool.BranchMode
+}
+
+// DefaultToolexecStateDir returns where goc toolexec records the counters of the compiled packages,
+// the records must live as long as the archives of the build cache
+func DefaultToolexecStateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "goc", "toolexec")
+}
+
+// hash fingerprints the configuration, the build cache tells apart the archives of different ones
+func (cfg *ToolexecConfig) hash() string {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(append(data, toolexecFormat...))
+	return fmt.Sprintf("%x", sum[:6])
+}
+
+// Toolexec runs the tool of a go build with its args, instrumenting the packages it compiles and
+// injecting the agent into their main packages. It returns the exit code of the tool.
+func Toolexec(cfg *ToolexecConfig, tool string, args []string) (int, error) {
+	if len(args) > 0 && args[len(args)-1] == "-V=full" {
+		return toolexecVersion(cfg, tool, args)
+	}
+	switch strings.TrimSuffix(filepath.Base(tool), ".exe") {
+	case "compile":
+		return toolexecCompile(cfg, tool, args)
+	case "link":
+		return toolexecLink(cfg, tool, args)
+	}
+	return runTool(tool, args, os.Stdout)
+}
+
+// runTool runs the tool with the stdio of goc, it returns the exit code of the tool
+func runTool(tool string, args []string, stdout io.Writer) (int, error) {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// toolexecVersion adds the fingerprint of the configuration to the version the go command
+// keys the build cache with, a release uses the whole line, a devel toolchain its last field
+func toolexecVersion(cfg *ToolexecConfig, tool string, args []string) (int, error) {
+	var out bytes.Buffer
+	code, err := runTool(tool, args, &out)
+	if code != 0 || err != nil {
+		os.Stdout.Write(out.Bytes())
+		return code, err
+	}
+	fields := strings.Fields(out.String())
+	mark := "goc:" + cfg.hash()
+	if len(fields) > 2 && fields[2] == "devel" {
+		fields = append(fields[:len(fields)-1], mark, fields[len(fields)-1])
+	} else {
+		fields = append(fields, mark)
+	}
+	fmt.Println(strings.Join(fields, " "))
+	return 0, nil
+}
+
+// compileArgs are the arguments of a compile goc needs
+type compileArgs struct {
+	pkgPath   string // -p, main for the main packages
+	importcfg int    // index of the value of -importcfg
+	actionID  string // from -buildid
+	workDir   string // the work directory of the go command, from -trimpath
+	std       bool
+	codegen   []string // flags the packages goc compiles need as well
+	files     int      // index of the first go file, the go files end the arguments
+}
+
+func parseCompileArgs(args []string) *compileArgs {
+	ca := &compileArgs{importcfg: -1, files: len(args)}
+	for ca.files > 0 && strings.HasSuffix(args[ca.files-1], ".go") {
+		ca.files--
+	}
+	for i := 0; i < ca.files; i++ {
+		value := ""
+		if i+1 < ca.files {
+			value = args[i+1]
+		}
+		switch args[i] {
+		case "-p":
+			ca.pkgPath = value
+		case "-importcfg":
+			ca.importcfg = i + 1
+		case "-buildid":
+			ca.actionID = strings.Split(value, "/")[0]
+		case "-trimpath":
+			// $WORK/b001=>, the first rewrite is the object directory of the package
+			rule := strings.Split(strings.Split(value, ";")[0], "=>")[0]
+			ca.workDir = filepath.Dir(rule)
+		case "-std":
+			ca.std = true
+		case "-race", "-msan", "-asan":
+			ca.codegen = append(ca.codegen, args[i])
+		}
+	}
+	return ca
+}
+
+// toolexecVar is a counter variable of an instrumented file
+type toolexecVar struct {
+	File string `json:"file"` // import path of the package joined with the file name
+	Var  string `json:"var"`
+	Type string `json:"type"` // struct type of the variable
+}
+
+// toolexecRecord is what a compile leaves for the compiles and links depending on its archive
+type toolexecRecord struct {
+	// the counters of the package and of the packages it depends on, by package path
+	Packages map[string][]toolexecVar `json:"packages,omitempty"`
+	// for a main package with the agent, the packages its link needs
+	VarsPackage string   `json:"varsPackage,omitempty"`
+	VarsArchive string   `json:"varsArchive,omitempty"`
+	Imports     []string `json:"imports,omitempty"`
+}
+
+func toolexecCompile(cfg *ToolexecConfig, tool string, args []string) (int, error) {
+	ca := parseCompileArgs(args)
+	if ca.std || ca.importcfg < 0 || ca.actionID == "" || ca.files == len(args) {
+		return runTool(tool, args, os.Stdout)
+	}
+	tmp, err := ioutil.TempDir("", "goc-toolexec")
+	if err != nil {
+		return 1, err
+	}
+	defer os.RemoveAll(tmp)
+
+	args = append([]string{}, args...)
+	importcfg, err := ioutil.ReadFile(args[ca.importcfg])
+	if err != nil {
+		return 1, err
+	}
+	record := &toolexecRecord{Packages: make(map[string][]toolexecVar)}
+	// the counters of the dependencies, recorded by their own compiles
+	for _, archive := range importcfgPackages(importcfg) {
+		if dep, err := loadToolexecRecord(cfg.StateDir, archiveActionID(archive)); err == nil {
+			for pkg, vars := range dep.Packages {
+				record.Packages[pkg] = vars
+			}
+		}
+	}
+
+	// the import path of a main package is main for the compiler, the go command tells the real one
+	importPath := ca.pkgPath
+	if v := os.Getenv("TOOLEXEC_IMPORTPATH"); v != "" {
+		importPath = strings.Split(v, " ")[0]
+	}
+	vars, pkgName, declFile, err := instrumentCompile(cfg, tmp, importPath, ca, args)
+	if err != nil {
+		return 1, err
+	}
+	var imports []string
+	if len(vars) > 0 {
+		args = append(args, declFile)
+		record.Packages[ca.pkgPath] = vars
+		if cfg.atomic() {
+			imports = append(imports, "sync/atomic")
+		}
+	}
+
+	if ca.pkgPath == "main" && len(vars) > 0 && pkgName == "main" {
+		agentImports, err := injectToolexecAgent(cfg, tmp, tool, importPath, ca, record)
+		if err != nil {
+			return 1, err
+		}
+		args = append(args, filepath.Join(tmp, httpCoverApisFile))
+		imports = append(imports, agentImports...)
+		importcfg = append(importcfg, fmt.Sprintf("\npackagefile %s=%s\n", record.VarsPackage, record.VarsArchive)...)
+	}
+	if len(imports) > 0 {
+		if importcfg, err = addImportcfgPackages(importcfg, imports, ca.codegen, false); err != nil {
+			return 1, err
+		}
+	}
+	args[ca.importcfg] = filepath.Join(tmp, "importcfg")
+	if err := ioutil.WriteFile(args[ca.importcfg], importcfg, 0644); err != nil {
+		return 1, err
+	}
+
+	code, err := runTool(tool, args, os.Stdout)
+	if code != 0 || err != nil {
+		return code, err
+	}
+	if len(record.Packages) > 0 {
+		if err := record.save(cfg.StateDir, ca.actionID); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// instrumentCompile replaces the files of the project in args by their instrumented copy. It returns
+// the counters, the name of the package and the file declaring the counters, to compile as well.
+func instrumentCompile(cfg *ToolexecConfig, tmp, importPath string, ca *compileArgs, args []string) ([]toolexecVar, string, string, error) {
+	filter, err := newFileFilter(cfg.IncludeGenerated, cfg.SkipPackages, cfg.VendorPackages)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if isVendored(importPath) && !filter.instrumentVendored(importPath) {
+		return nil, "", "", nil
+	}
+	modCache, err := goEnv("GOMODCACHE")
+	if err != nil {
+		return nil, "", "", err
+	}
+	// the files of the project are in the package directory, the generated ones, by cgo
+	// for example, in the work directory, the ones of the dependencies in the module cache
+	pkg := &Package{ImportPath: importPath}
+	index := make(map[string]int)
+	for i := ca.files; i < len(args); i++ {
+		file, err := filepath.Abs(args[i])
+		if err != nil {
+			return nil, "", "", err
+		}
+		if strings.HasSuffix(file, "_test.go") || isUnder(file, ca.workDir) || isUnder(file, modCache) {
+			continue
+		}
+		// the vendored packages of a module have the import path of their module
+		if strings.Contains(file, string(filepath.Separator)+"vendor"+string(filepath.Separator)) && !filter.instrumentVendored(importPath) {
+			continue
+		}
+		if pkg.Dir == "" {
+			pkg.Dir = filepath.Dir(file)
+		}
+		if filepath.Dir(file) != pkg.Dir {
+			continue
+		}
+		pkg.GoFiles = append(pkg.GoFiles, filepath.Base(file))
+		index[filepath.Base(file)] = i
+	}
+	if len(pkg.GoFiles) == 0 {
+		return nil, "", "", nil
+	}
+	if pkg = filter.filter(pkg); len(pkg.GoFiles) == 0 {
+		return nil, "", "", nil
+	}
+
+	var (
+		vars    []toolexecVar
+		decls   strings.Builder
+		pkgName string
+	)
+	coverVars := declareCoverVars(pkg)
+	for _, name := range pkg.GoFiles {
+		orig := filepath.Join(pkg.Dir, name)
+		if pkgName == "" {
+			f, err := parser.ParseFile(token.NewFileSet(), orig, nil, parser.PackageClauseOnly)
+			if err != nil {
+				return nil, "", "", err
+			}
+			pkgName = f.Name.Name
+		}
+		content, err := ioutil.ReadFile(orig)
+		if err != nil {
+			return nil, "", "", err
+		}
+		copied := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(copied, content, 0644); err != nil {
+			return nil, "", "", err
+		}
+		cv := coverVars[name]
+		decl, err := tool.Annotate(copied, cfg.Mode, cv.Var, "")
+		if err != nil {
+			return nil, "", "", &InstrumentError{Package: importPath, File: name, Err: err}
+		}
+		// positions keep pointing at the original file
+		annotated, err := ioutil.ReadFile(copied)
+		if err != nil {
+			return nil, "", "", err
+		}
+		annotated = bytes.Replace(annotated, []byte("//line "+copied+":1\n"), []byte("//line "+orig+":1\n"), 1)
+		if err := ioutil.WriteFile(copied, annotated, 0644); err != nil {
+			return nil, "", "", err
+		}
+		args[index[name]] = copied
+		decls.WriteString(decl)
+		vars = append(vars, toolexecVar{File: cv.File, Var: cv.Var, Type: declType(decl)})
+	}
+
+	declFile := filepath.Join(tmp, "cover_vars_auto_generated.go")
+	header := fmt.Sprintf("// Code generated by goc system. DO NOT EDIT.\n\npackage %s\n", pkgName)
+	if cfg.Mode == tool.BranchMode {
+		// the annotated files call the helper unqualified, every package declares its own
+		header += "\nimport \"sync/atomic\"\n" + tool.BranchHelperDecl
+	}
+	src := header + decls.String()
+	if err := ioutil.WriteFile(declFile, []byte(src), 0644); err != nil {
+		return nil, "", "", err
+	}
+	return vars, pkgName, declFile, nil
+}
+
+// declType returns the struct type of the counter variable the annotator declared
+func declType(decl string) string {
+	start := strings.Index(decl, "struct {")
+	end := strings.Index(decl, "\n} {")
+	if start < 0 || end < start {
+		return ""
+	}
+	return decl[start : end+2]
+}
+
+// injectToolexecAgent compiles the package exposing the counters of the main package and its
+// dependencies, and writes the agent using it. It returns the packages the agent imports.
+func injectToolexecAgent(cfg *ToolexecConfig, tmp, compiler, importPath string, ca *compileArgs, record *toolexecRecord) ([]string, error) {
+	pkgs := make([]string, 0, len(record.Packages))
+	for pkg := range record.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	var src strings.Builder
+	src.WriteString("// Code generated by goc system. DO NOT EDIT.\n\npackage vars\n\nimport _ \"unsafe\"\n")
+	tc := TestCover{
+		Mode:      profileMode(cfg.Mode),
+		Branch:    cfg.Mode == tool.BranchMode,
+		AgentPort: cfg.AgentPort,
+		Center:    cfg.Center,
+		Singleton: cfg.Singleton,
+	}
+	for _, pkg := range pkgs {
+		pc := &PackageCover{Package: &Package{ImportPath: pkg}, Vars: make(map[string]*FileVar)}
+		for _, v := range record.Packages[pkg] {
+			fmt.Fprintf(&src, "\n//go:linkname %s %s.%s\nvar %s %s\n", v.Var, pkg, v.Var, v.Var, v.Type)
+			pc.Vars[v.File] = &FileVar{File: v.File, Var: v.Var}
+		}
+		if pkg == ca.pkgPath {
+			tc.MainPkgCover = pc
+		} else {
+			tc.DepsCover = append(tc.DepsCover, pc)
+		}
+	}
+	sum := sha256.Sum256([]byte(src.String() + strings.Join(ca.codegen, " ")))
+	record.VarsPackage = fmt.Sprintf("goc.vars/v%x", sum[:8])
+	record.VarsArchive = filepath.Join(cfg.StateDir, fmt.Sprintf("vars-%x.a", sum[:8]))
+	tc.GlobalCoverVarImportPath = record.VarsPackage
+
+	if _, err := os.Stat(record.VarsArchive); err != nil {
+		if err := compileVarsPackage(compiler, tmp, src.String(), record.VarsPackage, record.VarsArchive, ca.codegen); err != nil {
+			return nil, err
+		}
+	}
+
+	agent := filepath.Join(tmp, httpCoverApisFile)
+	if err := InjectCountersHandlers(tc, agent); err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), agent, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range f.Imports {
+		if p, _ := strconv.Unquote(spec.Path.Value); p != record.VarsPackage {
+			record.Imports = append(record.Imports, p)
+		}
+	}
+	return record.Imports, nil
+}
+
+// compileVarsPackage compiles the package pulling the counters into the state directory
+func compileVarsPackage(compiler, tmp, src, importPath, archive string, codegen []string) error {
+	dir := filepath.Join(tmp, "vars")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "vars.go")
+	importcfg := filepath.Join(dir, "importcfg")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(importcfg, nil, 0644); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+	// compiled aside and renamed, concurrent builds may compile the same package
+	out := filepath.Join(dir, "vars.a")
+	args := append([]string{"-o", out, "-p", importPath, "-importcfg", importcfg, "-pack"}, codegen...)
+	cmd := exec.Command(compiler, append(args, file)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to compile the counters package: %v, %s", err, output)
+	}
+	return renameFile(out, archive)
+}
+
+func toolexecLink(cfg *ToolexecConfig, tool string, args []string) (int, error) {
+	importcfgIndex := -1
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "-importcfg" {
+			importcfgIndex = i + 1
+		}
+	}
+	if importcfgIndex < 0 || len(args) == 0 {
+		return runTool(tool, args, os.Stdout)
+	}
+	record, err := loadToolexecRecord(cfg.StateDir, archiveActionID(args[len(args)-1]))
+	if err != nil || record.VarsPackage == "" {
+		return runTool(tool, args, os.Stdout)
+	}
+
+	importcfg, err := ioutil.ReadFile(args[importcfgIndex])
+	if err != nil {
+		return 1, err
+	}
+	importcfg = append(importcfg, fmt.Sprintf("\npackagefile %s=%s\n", record.VarsPackage, record.VarsArchive)...)
+	var codegen []string
+	for _, arg := range args {
+		if arg == "-race" || arg == "-msan" || arg == "-asan" {
+			codegen = append(codegen, arg)
+		}
+	}
+	imports := record.Imports
+	if cfg.atomic() {
+		imports = append(imports, "sync/atomic")
+	}
+	if importcfg, err = addImportcfgPackages(importcfg, imports, codegen, true); err != nil {
+		return 1, err
+	}
+
+	tmp, err := ioutil.TempDir("", "goc-toolexec")
+	if err != nil {
+		return 1, err
+	}
+	defer os.RemoveAll(tmp)
+	args = append([]string{}, args...)
+	args[importcfgIndex] = filepath.Join(tmp, "importcfg.link")
+	if err := ioutil.WriteFile(args[importcfgIndex], importcfg, 0644); err != nil {
+		return 1, err
+	}
+	return runTool(tool, args, os.Stdout)
+}
+
+// importcfgPackages returns the archives of the packages of an importcfg, by import path
+func importcfgPackages(importcfg []byte) map[string]string {
+	pkgs := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(importcfg))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "packagefile ") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "packagefile "), "=", 2)
+		if len(kv) == 2 {
+			pkgs[kv[0]] = kv[1]
+		}
+	}
+	return pkgs
+}
+
+// addImportcfgPackages adds the packages the importcfg misses, with the ones they depend on for a link
+func addImportcfgPackages(importcfg []byte, imports, codegen []string, deps bool) ([]byte, error) {
+	have := importcfgPackages(importcfg)
+	var missing []string
+	for _, p := range imports {
+		if _, ok := have[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 && !deps {
+		return importcfg, nil
+	}
+	args := append([]string{"list", "-export", "-f", "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}, codegen...)
+	if deps {
+		args = append(args, "-deps")
+		missing = imports
+	}
+	if len(missing) == 0 {
+		return importcfg, nil
+	}
+	cmd := exec.Command("go", append(args, missing...)...)
+	// the flags of the build may run goc toolexec again
+	cmd.Env = append(os.Environ(), "GOFLAGS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the packages the agent imports: %v, %s", err, stderr.String())
+	}
+	var buf bytes.Buffer
+	buf.Write(importcfg)
+	buf.WriteString("\n")
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if _, ok := have[kv[0]]; !ok {
+			fmt.Fprintf(&buf, "packagefile %s=%s\n", kv[0], kv[1])
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// archiveActionID returns the action id of the build id an archive starts with
+func archiveActionID(archive string) string {
+	f, err := os.Open(archive)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	const marker = "\nbuild id \""
+	i := bytes.Index(head, []byte(marker))
+	if i < 0 {
+		return ""
+	}
+	id := head[i+len(marker):]
+	if j := bytes.IndexByte(id, '"'); j >= 0 {
+		id = id[:j]
+	}
+	return strings.Split(string(id), "/")[0]
+}
+
+func loadToolexecRecord(dir, actionID string) (*toolexecRecord, error) {
+	if actionID == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, actionID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var r toolexecRecord
+	return &r, json.Unmarshal(data, &r)
+}
+
+func (r *toolexecRecord) save(dir, actionID string) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, actionID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return renameFile(tmp.Name(), filepath.Join(dir, actionID+".json"))
+}
+
+// renameFile moves the file in place, whoever of concurrent writers comes last wins
+func renameFile(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		os.Remove(from)
+		return err
+	}
+	return nil
+}
+
+// isUnder reports whether the file is in the directory or below
+func isUnder(file, dir string) bool {
+	if dir == "" || dir == "." {
+		return false
+	}
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// goEnv returns the value of a variable of go env
+func goEnv(name string) (string, error) {
+	out, err := exec.Command("go", "env", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("go env %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,142 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// toolexecTestEnv makes the test binary the -toolexec program of the builds of the tests
+const toolexecTestEnv = "GOC_TOOLEXEC_TEST_CONFIG"
+
+func TestMain(m *testing.M) {
+	if data := os.Getenv(toolexecTestEnv); data != "" {
+		var cfg ToolexecConfig
+		if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cfg.StateDir = os.Getenv(toolexecTestEnv + "_STATE")
+		code, err := Toolexec(&cfg, os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseCompileArgs(t *testing.T) {
+	args := []string{"-o", "$WORK/b001/_pkg_.a", "-trimpath", "/tmp/go-build1/b001=>", "-p", "main", "-race",
+		"-complete", "-buildid", "abc/abc", "-importcfg", "/tmp/go-build1/b001/importcfg", "-pack", "./main.go", "./lib.go"}
+	ca := parseCompileArgs(args)
+	assert.Equal(t, "main", ca.pkgPath)
+	assert.Equal(t, 11, ca.importcfg)
+	assert.Equal(t, "abc", ca.actionID)
+	assert.Equal(t, "/tmp/go-build1", ca.workDir)
+	assert.Equal(t, []string{"-race"}, ca.codegen)
+	assert.Equal(t, 13, ca.files)
+	assert.False(t, ca.std)
+
+	ca = parseCompileArgs([]string{"-std", "-p", "fmt", "print.go"})
+	assert.True(t, ca.std)
+	assert.Equal(t, -1, ca.importcfg)
+}
+
+func TestToolexec(t *testing.T) {
+	for _, mode := range []string{"count", "branch"} {
+		t.Run(mode, func(t *testing.T) { testToolexec(t, mode) })
+	}
+}
+
+func testToolexec(t *testing.T, mode string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	dir := buildWithToolexec(t, &ToolexecConfig{Mode: mode, AgentPort: addr, Singleton: true})
+	defer os.RemoveAll(dir)
+	run := exec.Command(filepath.Join(dir, "n"))
+	assert.NoError(t, run.Start())
+	defer run.Process.Kill()
+
+	get := func(api string) string {
+		for i := 0; i < 50; i++ {
+			if res, err := http.Get("http://" + addr + api); err == nil {
+				body, _ := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				return string(body)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return ""
+	}
+	p := get(CoverProfileAPI)
+	// the counters of the dependency reach the agent of the main package, the generated file has none
+	assert.True(t, strings.HasPrefix(p, "mode: "+profileMode(mode)+"\n"), p)
+	assert.Contains(t, p, "example.com/n/lib/lib.go:6.23,7.12 1 3\n")
+	assert.Contains(t, p, "example.com/n/main.go:")
+	assert.NotContains(t, p, "gen.go")
+	assert.NotContains(t, p, httpCoverApisFile)
+	if mode == "branch" {
+		// v > m holds for 1 and 3, not for 2
+		b := get(CoverBranchAPI)
+		assert.Contains(t, b, "example.com/n/lib/lib.go:7.6,7.11 if T 2\n")
+		assert.Contains(t, b, "example.com/n/lib/lib.go:7.6,7.11 if F 1\n")
+	}
+}
+
+// buildWithToolexec builds the native test project into n with goc toolexec configured as cfg,
+// in a directory the caller removes, the test is skipped if the toolchain can't build it
+func buildWithToolexec(t *testing.T, cfg *ToolexecConfig) string {
+	dir, err := ioutil.TempDir("", "goc-toolexec")
+	assert.NoError(t, err)
+	for name, content := range nativeTestFiles {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	if err := checkNativeToolchain(dir, ""); err != nil {
+		os.RemoveAll(dir)
+		t.Skip(err)
+	}
+
+	data, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+	self, err := os.Executable()
+	assert.NoError(t, err)
+	build := exec.Command("go", "build", "-o", "n", "-toolexec", fmt.Sprintf("%q", self))
+	build.Dir = dir
+	build.Env = append(os.Environ(), toolexecTestEnv+"="+string(data), toolexecTestEnv+"_STATE="+filepath.Join(dir, "state"))
+	if out, err := build.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("%v: %s", err, out)
+	}
+	return dir
+}
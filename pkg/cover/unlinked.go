@@ -0,0 +1,156 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/qiniu/goc/pkg/cover/internal/tool"
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// unlinkedPackages returns the packages of the project no main package links,
+// they never show up in the profiles of the services
+func unlinkedPackages(pkgs map[string]*Package, covers map[string]*PackageCover) []*Package {
+	var unlinked []*Package
+	for importPath, pkg := range pkgs {
+		if _, ok := covers[importPath]; ok {
+			continue
+		}
+		unlinked = append(unlinked, pkg)
+	}
+	sort.Slice(unlinked, func(i, j int) bool { return unlinked[i].ImportPath < unlinked[j].ImportPath })
+	return unlinked
+}
+
+// ZeroProfiles returns the profiles of the files of the packages with every block at count 0,
+// named the way the instrumented files are
+func ZeroProfiles(pkgs []*Package, mode string) ([]*cover.Profile, error) {
+	var profiles []*cover.Profile
+	for _, pkg := range pkgs {
+		files := append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+		for _, file := range files {
+			blocks, err := tool.Blocks(path.Join(pkg.Dir, file))
+			if err != nil {
+				return nil, fmt.Errorf("package %s, file %s: %v", pkg.ImportPath, file, err)
+			}
+			p := &cover.Profile{FileName: path.Join(pkg.ImportPath, file), Mode: mode}
+			for _, b := range blocks {
+				p.Blocks = append(p.Blocks, cover.ProfileBlock{
+					StartLine: b.StartLine,
+					StartCol:  b.StartCol,
+					EndLine:   b.EndLine,
+					EndCol:    b.EndCol,
+					NumStmt:   b.NumStmt,
+				})
+			}
+			// ordered as parsed profiles are
+			sort.Slice(p.Blocks, func(i, j int) bool {
+				bi, bj := p.Blocks[i], p.Blocks[j]
+				return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+			})
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles, nil
+}
+
+// StaticZeroProfiles lists the packages of the project in dir, as go list ./... does,
+// and returns their zero count profiles. Generated and vendored files are left out
+// as they are when instrumenting.
+func StaticZeroProfiles(dir, mode string) ([]*cover.Profile, error) {
+	pkgs, err := ListPackages(dir, []string{"-json", "./..."}, "")
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newFileFilter(false, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]*Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		all = append(all, filter.filter(pkg))
+	}
+	return ZeroProfiles(all, mode)
+}
+
+// AddZeroProfiles adds the zero count profiles of the files missing from the profiles,
+// the files already covered are left as they are. The result is sorted by file name.
+func AddZeroProfiles(profiles, zero []*cover.Profile) []*cover.Profile {
+	seen := make(map[string]bool, len(profiles))
+	mode := ""
+	for _, p := range profiles {
+		seen[p.FileName] = true
+		mode = p.Mode
+	}
+	merged := append([]*cover.Profile{}, profiles...)
+	for _, p := range zero {
+		if seen[p.FileName] {
+			continue
+		}
+		seen[p.FileName] = true
+		if mode != "" && p.Mode != mode {
+			withMode := *p
+			withMode.Mode = mode
+			p = &withMode
+		}
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].FileName < merged[j].FileName })
+	return merged
+}
+
+// dumpZeroProfiles writes the profiles in the text format, empty if there are none
+func dumpZeroProfiles(profiles []*cover.Profile) (string, error) {
+	if len(profiles) == 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := cov.DumpProfile(profiles, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PackageCoverList sums up the coverage of the profiles per package,
+// the FileName of the entries is the import path of the package
+func PackageCoverList(profiles []*cover.Profile) CoverageList {
+	index := make(map[string]int)
+	g := NewCoverageList()
+	for _, p := range profiles {
+		pkg := path.Dir(p.FileName)
+		i, ok := index[pkg]
+		if !ok {
+			i = len(g)
+			index[pkg] = i
+			g.append(newCoverage(pkg))
+		}
+		for _, b := range p.Blocks {
+			g[i].NAllStmts += b.NumStmt
+			if b.Count > 0 {
+				g[i].NCoveredStmts += b.NumStmt
+			}
+		}
+	}
+	g.Sort()
+	return g
+}
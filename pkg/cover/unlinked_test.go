@@ -0,0 +1,125 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+func TestUnlinkedPackages(t *testing.T) {
+	pkgs := map[string]*Package{
+		"example.com/p/cmd":    {ImportPath: "example.com/p/cmd", Name: "main"},
+		"example.com/p/used":   {ImportPath: "example.com/p/used"},
+		"example.com/p/unused": {ImportPath: "example.com/p/unused"},
+		"example.com/p/dead":   {ImportPath: "example.com/p/dead"},
+	}
+	covers := map[string]*PackageCover{"example.com/p/cmd": nil, "example.com/p/used": nil}
+
+	var unlinked []string
+	for _, pkg := range unlinkedPackages(pkgs, covers) {
+		unlinked = append(unlinked, pkg.ImportPath)
+	}
+	assert.Equal(t, []string{"example.com/p/dead", "example.com/p/unused"}, unlinked)
+}
+
+func TestZeroProfiles(t *testing.T) {
+	pkg := writeAnnotateTestPackage(t, cacheTestFiles)
+	defer os.RemoveAll(pkg.Dir)
+
+	profiles, err := ZeroProfiles([]*Package{pkg}, "count")
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, cov.DumpProfile(profiles, &buf))
+	// the blocks are the ones the instrumented file counts
+	assert.Equal(t, "mode: count\n"+
+		"example.com/a/a.go:3.19,4.11 1 0\n"+
+		"example.com/a/a.go:4.11,6.3 1 0\n"+
+		"example.com/a/a.go:7.2,7.10 1 0\n"+
+		"example.com/a/b.go:3.11,3.12 0 0\n", buf.String())
+
+	_, err = ZeroProfiles([]*Package{{Dir: pkg.Dir, ImportPath: "example.com/a", GoFiles: []string{"missing.go"}}}, "count")
+	assert.Error(t, err)
+}
+
+func TestAddZeroProfiles(t *testing.T) {
+	profiles := []*cover.Profile{
+		{FileName: "p/b/b.go", Mode: "atomic", Blocks: []cover.ProfileBlock{{StartLine: 1, EndLine: 2, NumStmt: 2, Count: 3}}},
+	}
+	zero := []*cover.Profile{
+		{FileName: "p/b/b.go", Mode: "set", Blocks: []cover.ProfileBlock{{StartLine: 1, EndLine: 2, NumStmt: 2}}},
+		{FileName: "p/a/a.go", Mode: "set", Blocks: []cover.ProfileBlock{{StartLine: 1, EndLine: 4, NumStmt: 6}}},
+	}
+
+	merged := AddZeroProfiles(profiles, zero)
+	assert.Len(t, merged, 2)
+	// covered files are kept, the missing ones are added in the mode of the profiles
+	assert.Equal(t, "p/a/a.go", merged[0].FileName)
+	assert.Equal(t, "atomic", merged[0].Mode)
+	assert.Equal(t, "set", zero[1].Mode)
+	assert.Equal(t, 3, merged[1].Blocks[0].Count)
+
+	packages := PackageCoverList(merged)
+	assert.Equal(t, "p/a", packages[0].FileName)
+	assert.Equal(t, "0.0%", packages[0].Percentage())
+	assert.Equal(t, "100.0%", packages[1].Percentage())
+	assert.Equal(t, "25.0%", packages.TotalPercentage())
+}
+
+func TestProfileWithUnlinked(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case CoverProfileAPI:
+			w.Write([]byte("mode: count\na/a.go:3.10,5.2 2 1\n"))
+		case CoverUnlinkedAPI:
+			w.Write([]byte("mode: count\na/a.go:3.10,5.2 2 0\nz/z.go:1.1,3.2 3 0\n"))
+		}
+	}))
+	defer agent.Close()
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != CoverProfileAPI {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("mode: count\nb/b.go:1.1,2.2 1 1\n"))
+	}))
+	defer old.Close()
+
+	server := &server{Store: NewMemoryStore()}
+	assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "a", Address: agent.URL}))
+	assert.NoError(t, server.Store.Add(ServiceUnderTest{Name: "b", Address: old.URL}))
+	router := server.Route(os.Stdout)
+
+	// services built without --unlinked, or by an older goc, report nothing
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/cover/profile?force=true&unlinked=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mode: count\na/a.go:3.10,5.2 2 1\nb/b.go:1.1,2.2 1 1\nz/z.go:1.1,3.2 3 0\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/cover/profile?force=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "mode: count\na/a.go:3.10,5.2 2 1\nb/b.go:1.1,2.2 1 1\n", w.Body.String())
+}
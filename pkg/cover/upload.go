@@ -0,0 +1,251 @@
+/*
+ Copyright 2020 Qiniu Cloud (qiniu.com)
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/tools/cover"
+	"k8s.io/test-infra/gopherage/pkg/cov"
+)
+
+// UploadAddressPrefix is the address the uploaded profiles are listed under, followed by their name
+const UploadAddressPrefix = "upload://"
+
+// uploadSuffix ends the names of the files the uploaded profiles are saved in
+const uploadSuffix = ".cov"
+
+// uploadStore keeps the profiles uploaded to the center, by name. Uploads of the same name
+// are merged together. They are saved in dir, next to the file of the services, so that
+// they outlive a restart of the center, a center without a dir loses them.
+type uploadStore struct {
+	mu       sync.Mutex
+	dir      string
+	profiles map[string][]*cover.Profile
+}
+
+// load reads the profiles saved in the directory of the store
+func (u *uploadStore) load() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(u.dir, "*"+uploadSuffix))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(file), uploadSuffix))
+		if err != nil {
+			continue
+		}
+		profiles, err := cover.ParseProfiles(file)
+		if err != nil {
+			return fmt.Errorf("failed to load the profiles uploaded as %s: %v", name, err)
+		}
+		if u.profiles == nil {
+			u.profiles = make(map[string][]*cover.Profile)
+		}
+		u.profiles[name] = profiles
+	}
+	return nil
+}
+
+func (u *uploadStore) add(name string, profiles []*cover.Profile) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.profiles == nil {
+		u.profiles = make(map[string][]*cover.Profile)
+	}
+	if old, ok := u.profiles[name]; ok {
+		merged, err := cov.MergeProfiles(old, profiles)
+		if err != nil {
+			return withStatus(http.StatusBadRequest, fmt.Errorf("not coherent with the profiles uploaded as %s before, clear them first: %v", name, err))
+		}
+		profiles = merged
+	}
+	if err := u.save(name, profiles); err != nil {
+		return fmt.Errorf("failed to save the profiles uploaded as %s: %v", name, err)
+	}
+	u.profiles[name] = profiles
+	return nil
+}
+
+// save writes the profiles of name to the directory of the store, if any
+func (u *uploadStore) save(name string, profiles []*cover.Profile) error {
+	if u.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(u.dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(u.dir, "upload-")
+	if err != nil {
+		return err
+	}
+	err = cov.DumpProfile(profiles, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), u.file(name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// file returns the file the profiles uploaded as name are saved in
+func (u *uploadStore) file(name string) string {
+	return filepath.Join(u.dir, url.PathEscape(name)+uploadSuffix)
+}
+
+// get returns a copy of the profiles uploaded as name
+func (u *uploadStore) get(name string) ([]*cover.Profile, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.profiles[name]
+	if !ok {
+		return nil, false
+	}
+	p, err := cov.MergeProfiles(p, nil)
+	return p, err == nil
+}
+
+func (u *uploadStore) remove(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.profiles, name)
+	if u.dir != "" {
+		if err := os.Remove(u.file(name)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("failed to remove the profiles uploaded as %s: %v", name, err)
+		}
+	}
+}
+
+func (u *uploadStore) names() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	names := make([]string, 0, len(u.profiles))
+	for name := range u.profiles {
+		names = append(names, name)
+	}
+	return names
+}
+
+// isUploadAddress reports whether the address lists uploaded profiles, and their name
+func isUploadAddress(address string) (string, bool) {
+	if !strings.HasPrefix(address, UploadAddressPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(address, UploadAddressPrefix), true
+}
+
+// allServices returns the registered services, and the uploaded profiles listed under their name
+// with an upload:// address if uploads. /v1 lists the agents only, unless asked for the uploads.
+func (s *server) allServices(uploads bool) map[string][]string {
+	services := make(map[string][]string)
+	for name, addrs := range s.Store.GetAll() {
+		services[name] = append(services[name], addrs...)
+	}
+	if !uploads {
+		return services
+	}
+	for _, name := range s.uploads.names() {
+		services[name] = append(services[name], UploadAddressPrefix+name)
+	}
+	return services
+}
+
+// upload API examples:
+// POST /v1/cover/upload?name=e2e with the files of a GOCOVERDIR, or goc profiles, as multipart "file" fields
+// stores the coverage of processes the center can't pull from, the profile API merges it in
+// if asked for the uploads, /v2 always does
+func (s *server) upload(c *gin.Context) {
+	result, err := s.addUpload(c)
+	if err != nil {
+		c.JSON(statusOf(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// addUpload stores the files of the request under its name, the errors of the request
+// are bad requests, the ones of the store internal
+func (s *server) addUpload(c *gin.Context) (*UploadResult, error) {
+	name := c.Query("name")
+	if strings.TrimSpace(name) == "" {
+		return nil, withStatus(http.StatusBadRequest, errors.New("invalid name"))
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	headers := form.File["file"]
+	if len(headers) == 0 {
+		return nil, withStatus(http.StatusBadRequest, errors.New("no file uploaded"))
+	}
+
+	covFiles := make(map[string][]byte)
+	var profiles [][]*cover.Profile
+	for _, header := range headers {
+		f, err := header.Open()
+		if err != nil {
+			return nil, withStatus(http.StatusBadRequest, err)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, withStatus(http.StatusBadRequest, err)
+		}
+		if IsCovDataFile(header.Filename) {
+			covFiles[header.Filename] = data
+			continue
+		}
+		profile, _ := SplitBranchSection(data)
+		p, err := cover.ParseProfilesFromReader(bytes.NewReader(profile))
+		if err != nil {
+			return nil, withStatus(http.StatusBadRequest, fmt.Errorf("%s: %v", header.Filename, err))
+		}
+		profiles = append(profiles, p)
+	}
+	if len(covFiles) > 0 {
+		p, err := ReadCovFiles(covFiles)
+		if err != nil {
+			return nil, withStatus(http.StatusBadRequest, err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	merged, err := cov.MergeMultipleProfiles(profiles)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	if err := s.uploads.add(name, merged); err != nil {
+		return nil, err
+	}
+	return &UploadResult{Name: name, Address: UploadAddressPrefix + name, Files: len(headers)}, nil
+}
@@ -183,13 +183,28 @@ func (c *GitPrComment) GetCommentFlag() string {
 func GenCommentContent(commentPrefix string, delta cover.DeltaCovList) string {
 	var buf bytes.Buffer
 	table := tablewriter.NewWriter(&buf)
-	table.SetHeader([]string{"File", "Base Coverage", "New Coverage", "Delta"})
+	// branch columns only show up when the profiles carry branch coverage
+	branches := false
+	for _, d := range delta {
+		branches = branches || d.BaseBranchPer != "" || d.NewBranchPer != ""
+	}
+	header := []string{"File", "Base Coverage", "New Coverage", "Delta"}
+	alignment := []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER}
+	if branches {
+		header = append(header, "Base Branch", "New Branch")
+		alignment = append(alignment, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER)
+	}
+	table.SetHeader(header)
 	table.SetAutoFormatHeaders(false)
 	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
 	table.SetCenterSeparator("|")
-	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER})
+	table.SetColumnAlignment(alignment)
 	for _, d := range delta {
-		table.Append([]string{fmt.Sprintf("[%s](%s)", d.FileName, d.LineCovLink), d.BasePer, d.NewPer, d.DeltaPer})
+		row := []string{fmt.Sprintf("[%s](%s)", d.FileName, d.LineCovLink), d.BasePer, d.NewPer, d.DeltaPer}
+		if branches {
+			row = append(row, d.BaseBranchPer, d.NewBranchPer)
+		}
+		table.Append(row)
 	}
 	table.Render()
 
@@ -111,7 +111,11 @@ func (j *Job) RunPresubmit() error {
 	}
 	if len(deltaCovList) > 0 {
 		totalDelta := cover.PercentStr(cover.TotalDelta(localP, baseP))
-		deltaCovList = append(deltaCovList, cover.DeltaCov{FileName: "Total", BasePer: baseP.TotalPercentage(), NewPer: localP.TotalPercentage(), DeltaPer: totalDelta})
+		total := cover.DeltaCov{FileName: "Total", BasePer: baseP.TotalPercentage(), NewPer: localP.TotalPercentage(), DeltaPer: totalDelta}
+		if localP.HasBranches() || baseP.HasBranches() {
+			total.BaseBranchPer, total.NewBranchPer = baseP.TotalBranchPercentage(), localP.TotalBranchPercentage()
+		}
+		deltaCovList = append(deltaCovList, total)
 	}
 	err = j.GithubComment.CreateGithubComment(commentPrefix, deltaCovList)
 	if err != nil {
@@ -159,6 +163,10 @@ func (j *Job) WriteChangedCov(changedFiles []string) error {
 	writeLine(cp, s.Text())
 
 	for s.Scan() {
+		// go tool cover does not know the branch section
+		if s.Text() == cover.BranchSectionHeader {
+			break
+		}
 		for _, file := range changedFiles {
 			if strings.HasPrefix(s.Text(), file) {
 				writeLine(cp, s.Text())
@@ -63,7 +63,7 @@ teardown_file() {
     run gocc clear --center=http://127.0.0.1:60001 --debug --debugcisyncfile ci-sync.bak;
     info clear2 output: $output
     [ "$status" -eq 0 ]
-    [[ "$output" == *"coverage counter clear call successfully"* ]]
+    [[ "$output" == *" cleared"* ]]
 
     wait $profile_pid
 }
@@ -89,7 +89,7 @@ teardown_file() {
     # clear by right service name
     run goc clear --service="test-service"
     [ "$status" -eq 0 ]
-    [[ "$output" =~ "coverage counter clear call successfully" ]]
+    [[ "$output" == *"test-service http://"*" cleared"* ]]
 
     # check by goc profile, the coverage count should be reset to 0
     run goc profile --coverfile="simple-project/a/a.go" --force
@@ -56,7 +56,7 @@ teardown_file() {
     run gocc remove --center=http://127.0.0.1:60001 --service="simple-project" --debug --debugcisyncfile ci-sync.bak;
     info remove1_2 output: $output
     [ "$status" -eq 0 ]
-    [[ "$output" =~ "removed from the center" ]]
+    [[ "$output" == *"simple-project http://"*" removed"* ]]
 
     run goc list --center=http://127.0.0.1:60001;
     info remove1_3 output: $output